@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	"github.com/qRe0/afterparty-bot/internal/offline"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
+	"github.com/qRe0/afterparty-bot/internal/repository"
+	"github.com/qRe0/afterparty-bot/internal/service"
+)
+
+const usage = `doorctl — offline door mode
+
+Usage:
+  doorctl [-file door-snapshot.db] [-door name] <command> [args]
+
+Commands:
+  pull            download a signed guest list snapshot from the database
+  search <query>  search the snapshot by surname, name or ticket number
+  enter <ticket>  mark a ticket as entered locally
+  status          show snapshot time and the number of unsynced entries
+  sync            push unsynced entries to the database and report conflicts
+`
+
+func main() {
+	hostname, _ := os.Hostname()
+
+	file := flag.String("file", "door-snapshot.db", "path to the local snapshot file")
+	door := flag.String("door", hostname, "name of this door, stored with every entry")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(context.Background(), *file, *door, flag.Arg(0), flag.Args()[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run(ctx context.Context, file, door, command string, args []string) error {
+	cfg, err := configs.LoadEnvs()
+	if err != nil {
+		return fmt.Errorf("doorctl.LoadEnvs(): failed to load env vars: %v", err)
+	}
+
+	store, err := offline.Open(file, cfg.Door.SnapshotSecret)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch command {
+	case "pull":
+		return pull(ctx, cfg, store)
+	case "search":
+		return search(store, cfg.LacesColor, cfg.Event.MinAge, strings.Join(args, " "))
+	case "enter":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: doorctl enter <ticket>")
+		}
+		return enter(store, door, cfg.Event.MinAge, args[0])
+	case "status":
+		return status(store)
+	case "sync":
+		return sync(ctx, cfg, store)
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command: %s", command)
+	}
+}
+
+func newService(cfg *configs.Config) (*ticket_service.AuditedTicketsService, error) {
+	db, err := ticket_repository.NewDatabaseConnection(cfg.DB)
+	if err != nil {
+		return nil, fmt.Errorf("doorctl.NewDatabaseConnection(): failed to init database: %v", err)
+	}
+
+	repository := ticket_repository.New(db, cfg.DB)
+	return ticket_service.NewAudited(ticket_service.New(repository, *cfg), repository), nil
+}
+
+func pull(ctx context.Context, cfg *configs.Config, store *offline.Store) error {
+	service, err := newService(cfg)
+	if err != nil {
+		return err
+	}
+
+	tickets, err := service.GuestList(ctx)
+	if err != nil {
+		return fmt.Errorf("doorctl.GuestList(): failed to download guest list: %v", err)
+	}
+
+	err = store.SaveSnapshot(tickets, time.Now())
+	if err != nil {
+		return fmt.Errorf("doorctl.SaveSnapshot(): failed to save snapshot: %v", err)
+	}
+
+	fmt.Printf("Список гостей сохранён: %d билетов\n", len(tickets))
+	return nil
+}
+
+func search(store *offline.Store, laces configs.LacesColors, minAge int, query string) error {
+	if strings.TrimSpace(query) == "" {
+		return fmt.Errorf("usage: doorctl search <query>")
+	}
+
+	found, err := store.Search(query)
+	if err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		fmt.Println("Не удалось найти клиента, даже с учетом опечаток.")
+		return nil
+	}
+
+	for _, ticket := range found {
+		fmt.Println(presenter.ResponseMapper(&ticket, laces, minAge))
+		fmt.Println()
+	}
+	return nil
+}
+
+func enter(store *offline.Store, door string, minAge int, ticketId string) error {
+	resp, err := store.MarkAsEntered(ticketId, door, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if warning := presenter.AgeWarning(resp, minAge); warning != "" {
+		fmt.Println(warning)
+	}
+	fmt.Printf("%s прошел контроль (ID: %s). Вход будет синхронизирован позже\n", resp.Name, resp.Id)
+	return nil
+}
+
+func status(store *offline.Store) error {
+	takenAt, err := store.Verify()
+	if err != nil {
+		return err
+	}
+
+	pending, err := store.PendingEntries()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Снимок списка гостей от %s\nНесинхронизированных входов: %d\n", takenAt.Local().Format(time.DateTime), len(pending))
+	return nil
+}
+
+func sync(ctx context.Context, cfg *configs.Config, store *offline.Store) error {
+	pending, err := store.PendingEntries()
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("Нет входов для синхронизации")
+		return nil
+	}
+
+	service, err := newService(cfg)
+	if err != nil {
+		return err
+	}
+
+	var synced, conflicts, failed int
+	for _, entry := range pending {
+		resp, conflict, err := service.SyncOfflineEntry(ctx, entry.TicketId, entry.Door)
+		if err != nil {
+			failed++
+			fmt.Printf("Ошибка: билет %s (вход %s) не синхронизирован: %v\n", entry.TicketId, entry.EnteredAt.Local().Format(time.TimeOnly), err)
+			continue
+		}
+
+		if conflict {
+			conflicts++
+			fmt.Printf("Конфликт: %s (ID: %s) уже был отмечен в другом месте, здесь вход в %s на двери %q\n",
+				resp.Name, resp.Id, entry.EnteredAt.Local().Format(time.TimeOnly), entry.Door)
+		} else {
+			synced++
+		}
+
+		err = store.MarkSynced(entry.Seq, conflict)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Синхронизировано: %d, конфликтов: %d, ошибок: %d\n", synced, conflicts, failed)
+	return nil
+}
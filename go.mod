@@ -3,7 +3,6 @@ module github.com/qRe0/afterparty-bot
 go 1.23.0
 
 require (
-	github.com/agnivade/levenshtein v1.2.1
 	github.com/caarlos0/env v3.5.0+incompatible
 	github.com/fogleman/gg v1.3.0
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
@@ -12,6 +11,7 @@ require (
 	github.com/lib/pq v1.10.9
 	github.com/pkg/errors v0.9.1
 	github.com/pressly/goose/v3 v3.22.1
+	go.etcd.io/bbolt v1.3.11
 	go.uber.org/zap v1.27.0
 )
 
@@ -22,4 +22,5 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/image v0.24.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.25.0 // indirect
 )
@@ -0,0 +1,578 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
+	"github.com/qRe0/afterparty-bot/internal/service"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+type role int
+
+const (
+	roleChecker role = iota
+	roleZoneChecker
+	roleSeller
+	roleAdmin
+)
+
+type staffKey struct{}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type saleResponse struct {
+	*models.SaleResult
+	TicketLink string `json:"ticket_link,omitempty"`
+	Message    string `json:"message"`
+}
+
+type Server struct {
+	service *ticket_service.AuditedTicketsService
+	bot     *tgbotapi.BotAPI
+	cfg     configs.Config
+	srv     *http.Server
+}
+
+func New(ctx context.Context, service *ticket_service.AuditedTicketsService, bot *tgbotapi.BotAPI, cfg configs.Config) *Server {
+	s := &Server{
+		service: service,
+		bot:     bot,
+		cfg:     cfg,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tickets", s.withRole(roleChecker, s.searchTickets))
+	mux.HandleFunc("GET /api/tickets/{id}", s.withRole(roleChecker, s.getTicket))
+	mux.HandleFunc("POST /api/tickets/{id}/enter", s.withRole(roleChecker, s.markAsEntered))
+	mux.HandleFunc("POST /api/tickets/{id}/exit", s.withRole(roleChecker, s.markAsExited))
+	mux.HandleFunc("POST /api/zones/{zone}/tickets/{id}/check", s.withRole(roleZoneChecker, s.checkZoneAccess))
+	mux.HandleFunc("POST /api/tickets", s.withRole(roleSeller, s.sellTicket))
+	mux.HandleFunc("POST /api/tickets/{id}/refund", s.withRole(roleAdmin, s.refundTicket))
+	mux.HandleFunc("POST /api/tickets/{id}/transfer", s.withRole(roleSeller, s.transferTicket))
+	mux.HandleFunc("PATCH /api/tickets/{id}", s.withRole(roleAdmin, s.editTicket))
+	mux.HandleFunc("GET /api/tickets/{id}/upgrade", s.withRole(roleSeller, s.upgradeQuote))
+	mux.HandleFunc("POST /api/tickets/{id}/upgrade", s.withRole(roleSeller, s.upgradeTicket))
+	mux.HandleFunc("GET /api/tickets/{id}/audit", s.withRole(roleAdmin, s.ticketAudit))
+	mux.HandleFunc("GET /api/tickets/{id}/history", s.withRole(roleAdmin, s.ticketHistory))
+	mux.HandleFunc("GET /api/stats", s.withRole(roleAdmin, s.stats))
+	mux.HandleFunc("GET /api/contacts", s.withRole(roleAdmin, s.contacts))
+	mux.HandleFunc("GET /api/blacklist", s.withRole(roleAdmin, s.blacklist))
+	mux.HandleFunc("POST /api/blacklist", s.withRole(roleAdmin, s.addToBlacklist))
+	mux.HandleFunc("DELETE /api/blacklist/{id}", s.withRole(roleAdmin, s.removeFromBlacklist))
+	mux.HandleFunc("POST /api/tickets/{id}/blacklist-override", s.withRole(roleAdmin, s.overrideBlacklist))
+	mux.HandleFunc("GET /api/waitlist", s.withRole(roleSeller, s.waitlist))
+	mux.HandleFunc("DELETE /api/waitlist/{id}", s.withRole(roleAdmin, s.removeFromWaitlist))
+
+	s.srv = &http.Server{
+		Addr:              cfg.API.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(_ net.Listener) context.Context { return ctx },
+	}
+
+	return s
+}
+
+func (s *Server) ListenAndServe() error {
+	err := s.srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+// withRole authenticates the request by its bearer token and checks
+// that the staff member the token belongs to has the required role.
+func (s *Server) withRole(required role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		user, known := s.lookupToken(token)
+		if !ok || !known {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid or missing token"})
+			return
+		}
+
+		if !s.hasRole(user.UserName, required) {
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: "not enough permissions"})
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), staffKey{}, models.Actor{ID: user.ID, UserName: user.UserName})
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// lookupToken compares the token with every known one in constant time, so the response time
+// doesn't tell how much of a token was guessed right.
+func (s *Server) lookupToken(token string) (configs.APIUser, bool) {
+	var (
+		found configs.APIUser
+		known bool
+	)
+	for candidate, user := range s.cfg.API.Tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			found, known = user, true
+		}
+	}
+
+	return found, known
+}
+
+func (s *Server) hasRole(userName string, required role) bool {
+	allowList := s.cfg.AllowList
+
+	switch required {
+	case roleChecker:
+		return utils.UserInList(userName, allowList.AllowedCheckers) || utils.UserInList(userName, allowList.Admins)
+	case roleZoneChecker:
+		return utils.UserInList(userName, allowList.ZoneCheckers) || utils.UserInList(userName, allowList.Admins)
+	case roleSeller:
+		return utils.UserInList(userName, allowList.AllowedSellers) || utils.UserInList(userName, allowList.Admins)
+	case roleAdmin:
+		return utils.UserInList(userName, allowList.Admins)
+	default:
+		return false
+	}
+}
+
+func (s *Server) searchTickets(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	tickets, err := s.service.SearchBySurname(r.Context(), staff, query)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	if tickets == nil {
+		tickets = []models.TicketResponse{}
+	}
+	writeJSON(w, http.StatusOK, tickets)
+}
+
+func (s *Server) getTicket(w http.ResponseWriter, r *http.Request) {
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	ticket, err := s.service.SearchById(r.Context(), staff, r.PathValue("id"))
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ticket)
+}
+
+func (s *Server) markAsEntered(w http.ResponseWriter, r *http.Request) {
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+	idChecked := r.URL.Query().Get("id_checked") == "true"
+	checkpoint, ok := s.checkpoint(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "Неизвестный вход"})
+		return
+	}
+
+	ticket, err := s.service.MarkAsEntered(r.Context(), staff, r.PathValue("id"), checkpoint, idChecked)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ticket)
+}
+
+func (s *Server) markAsExited(w http.ResponseWriter, r *http.Request) {
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+	checkpoint, ok := s.checkpoint(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "Неизвестный вход"})
+		return
+	}
+
+	ticket, err := s.service.MarkAsExited(r.Context(), staff, r.PathValue("id"), checkpoint)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ticket)
+}
+
+func (s *Server) checkZoneAccess(w http.ResponseWriter, r *http.Request) {
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	scan, err := s.service.CheckZoneAccess(r.Context(), staff, r.PathValue("zone"), r.PathValue("id"))
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, scan)
+}
+
+// checkpoint reads the optional ?checkpoint= parameter, which has to be one of CHECKPOINTS.
+func (s *Server) checkpoint(r *http.Request) (string, bool) {
+	checkpoint := r.URL.Query().Get("checkpoint")
+	if checkpoint == "" {
+		return "", true
+	}
+
+	return checkpoint, slices.Contains(s.cfg.Door.Checkpoints, checkpoint)
+}
+
+func (s *Server) sellTicket(w http.ResponseWriter, r *http.Request) {
+	var client models.ClientData
+	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "malformed request body"})
+		return
+	}
+
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	fio, err := utils.FormatFIO(client.FIO)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "Проверьте введенное ФИО"})
+		return
+	}
+	client.FIO = fio
+
+	ticketType, ok := utils.ValidateTicketType(client.TicketType, s.cfg.SalesOption)
+	if !ok || (strings.HasPrefix(ticketType, "вип") && !utils.UserInList(staff.UserName, s.cfg.AllowList.VIPSellers)) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "Неверный тип билета"})
+		return
+	}
+	client.TicketType = ticketType
+
+	price, err := utils.ParseTicketPrice(strconv.Itoa(client.Price), staff.UserName, s.cfg.AllowList)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "Проверьте введенную цену"})
+		return
+	}
+	client.Price = price
+
+	if err := normalizeContacts(&client); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	result, err := s.service.SellTicket(r.Context(), staff, client)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	resp := saleResponse{SaleResult: result, Message: presenter.Sale(result, s.bot.Self.UserName)}
+	if result.LinkToken != "" {
+		resp.TicketLink = presenter.TicketLink(s.bot.Self.UserName, result.LinkToken)
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) refundTicket(w http.ResponseWriter, r *http.Request) {
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	ticket, err := s.service.RefundTicket(r.Context(), staff, r.PathValue("id"))
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ticket)
+}
+
+// transferTicket gives the ticket to another person under the same number. The previous holder's
+// link to the ticket in the bot no longer works.
+func (s *Server) transferTicket(w http.ResponseWriter, r *http.Request) {
+	var transfer models.TicketTransfer
+	if err := json.NewDecoder(r.Body).Decode(&transfer); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "malformed request body"})
+		return
+	}
+	transfer.TicketId = r.PathValue("id")
+
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	transferred, err := s.service.TransferTicket(r.Context(), staff, transfer)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, transferred)
+}
+
+// editTicket corrects the name, the type or the price of a sold ticket. Omitted fields stay as they are.
+func (s *Server) editTicket(w http.ResponseWriter, r *http.Request) {
+	var edit models.TicketEdit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "malformed request body"})
+		return
+	}
+	edit.TicketId = r.PathValue("id")
+
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	edited, err := s.service.EditTicket(r.Context(), staff, edit)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, edited)
+}
+
+// upgradeQuote returns the surcharge for upgrading the ticket to ?ticket_type= without changing it.
+func (s *Server) upgradeQuote(w http.ResponseWriter, r *http.Request) {
+	upgrade := models.TicketUpgrade{TicketId: r.PathValue("id"), TicketType: r.URL.Query().Get("ticket_type")}
+
+	quote, err := s.service.UpgradeQuote(r.Context(), upgrade)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, quote)
+}
+
+// upgradeTicket moves a Базовый ticket to a VIP table and credits the surcharge to the seller.
+func (s *Server) upgradeTicket(w http.ResponseWriter, r *http.Request) {
+	var upgrade models.TicketUpgrade
+	if err := json.NewDecoder(r.Body).Decode(&upgrade); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "malformed request body"})
+		return
+	}
+	upgrade.TicketId = r.PathValue("id")
+
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	upgraded, err := s.service.UpgradeTicket(r.Context(), staff, upgrade)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, upgraded)
+}
+
+func (s *Server) ticketAudit(w http.ResponseWriter, r *http.Request) {
+	records, err := s.service.TicketAudit(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	if records == nil {
+		records = []models.AuditRecord{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+func (s *Server) ticketHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := s.service.TicketHistory(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (s *Server) contacts(w http.ResponseWriter, r *http.Request) {
+	contacts, err := s.service.BuyerContacts(r.Context())
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	if contacts == nil {
+		contacts = []models.BuyerContact{}
+	}
+	writeJSON(w, http.StatusOK, contacts)
+}
+
+func (s *Server) blacklist(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.service.Blacklist(r.Context())
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	if entries == nil {
+		entries = []models.BlacklistEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) addToBlacklist(w http.ResponseWriter, r *http.Request) {
+	var entry models.BlacklistEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "malformed request body"})
+		return
+	}
+
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	added, err := s.service.AddToBlacklist(r.Context(), staff, entry)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, added)
+}
+
+func (s *Server) removeFromBlacklist(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "malformed blacklist id"})
+		return
+	}
+
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	err = s.service.RemoveFromBlacklist(r.Context(), staff, id)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) waitlist(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.service.Waitlist(r.Context())
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	if entries == nil {
+		entries = []models.WaitlistEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) removeFromWaitlist(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "malformed waitlist id"})
+		return
+	}
+
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	err = s.service.RemoveFromWaitlist(r.Context(), staff, id)
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// overrideBlacklist lets the guest in despite the blacklist match on the next POST /enter.
+func (s *Server) overrideBlacklist(w http.ResponseWriter, r *http.Request) {
+	staff := r.Context().Value(staffKey{}).(models.Actor)
+
+	ticket, err := s.service.OverrideBlacklist(r.Context(), staff, r.PathValue("id"))
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ticket)
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.service.Stats(r.Context())
+	if err != nil {
+		writeServiceError(r.Context(), w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// normalizeContacts validates the optional buyer contacts the same way the sale flow in the bot does.
+func normalizeContacts(client *models.ClientData) error {
+	var err error
+
+	if client.BuyerTag != "" {
+		if client.BuyerTag, err = utils.ParseTelegramUsername(client.BuyerTag); err != nil {
+			return errors.New("Проверьте введенный @username")
+		}
+	}
+	if client.BuyerPhone != "" {
+		if client.BuyerPhone, err = utils.ParsePhone(client.BuyerPhone); err != nil {
+			return errors.New("Проверьте введенный телефон")
+		}
+	}
+	if client.BuyerEmail != "" {
+		if client.BuyerEmail, err = utils.ParseEmail(client.BuyerEmail); err != nil {
+			return errors.New("Проверьте введенный email")
+		}
+	}
+	if client.BuyerBirthDate != "" {
+		birthDate, err := time.Parse(utils.BirthDateLayout, client.BuyerBirthDate)
+		if err != nil || birthDate.After(time.Now()) {
+			return errors.New("Проверьте дату рождения (ГГГГ-ММ-ДД)")
+		}
+	}
+
+	return nil
+}
+
+func writeServiceError(ctx context.Context, w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, errs.ErrTicketNotFound), errors.Is(err, errs.ErrTicketNotRefundable), errors.Is(err, errs.ErrBlacklistNotFound),
+		errors.Is(err, errs.ErrWaitlistNotFound), errors.Is(err, errs.ErrTicketNotTransferable), errors.Is(err, errs.ErrTicketNotUpgradable):
+		status = http.StatusNotFound
+	case errors.Is(err, errs.ErrCheckingBaseParameters):
+		status = http.StatusBadRequest
+	case errors.Is(err, errs.ErrPossibleNamesake), errors.Is(err, errs.ErrTicketNoTaken),
+		errors.Is(err, errs.ErrAlreadyInside), errors.Is(err, errs.ErrNotInside), errors.Is(err, errs.ErrReentryNotAllowed),
+		errors.Is(err, errs.ErrSoldOut):
+		status = http.StatusConflict
+	case errors.Is(err, errs.ErrIDCheckRequired):
+		status = http.StatusPreconditionRequired
+	case errors.Is(err, errs.ErrBlacklisted), errors.Is(err, errs.ErrOverrideNotAllowed), errors.Is(err, errs.ErrUpgradeNotAllowed):
+		status = http.StatusForbidden
+	}
+
+	if status == http.StatusInternalServerError {
+		logger.New(ctx).Error("API:: Service returned error", zap.Error(err))
+	}
+
+	writeJSON(w, status, errorResponse{Error: presenter.ErrorMessage(err)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -4,9 +4,14 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/qRe0/afterparty-bot/internal/api"
 	"github.com/qRe0/afterparty-bot/internal/configs"
 	"github.com/qRe0/afterparty-bot/internal/handlers"
 	"github.com/qRe0/afterparty-bot/internal/migrations"
@@ -16,6 +21,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// apiShutdownTimeout is how long the HTTP API may take to finish the requests in flight on shutdown.
+const apiShutdownTimeout = 10 * time.Second
+
 func Run() error {
 	ctx := context.Background()
 
@@ -26,6 +34,8 @@ func Run() error {
 	defer lgr.Sync()
 
 	ctx = logger.InjectInContext(ctx, lgr)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	cfg, err := configs.LoadEnvs()
 	if err != nil {
@@ -33,39 +43,58 @@ func Run() error {
 	}
 	lgr.Debug("Envs loaded successfully")
 
-	db, err := ticket_repository.NewDatabaseConnection(cfg.DB)
-	if err != nil {
-		return fmt.Errorf("app.NewDatabaseConnection(): failed to init database: %v", err)
-	}
-	lgr.Debug("Database connection configured")
-
-	m, err := migrator.New(db)
-	if err != nil {
-		return fmt.Errorf("app.migrator.New(): failed to init database migrtor: %v", err)
-	}
-	lgr.Debug("Migrator inited")
-	err = m.Latest()
-	if err != nil {
-		return fmt.Errorf("app.m.Latest(): failed to migrate database to latest version: %v", err)
-	}
-	lgr.Debug("Database migrated successfully")
-
-	botInstance, err := tgbotapi.NewBotAPI(cfg.TG.Token)
+	botInstance, err := tgbotapi.NewBotAPIWithAPIEndpoint(cfg.TG.Token, cfg.TG.APIEndpoint)
 	if err != nil {
 		return fmt.Errorf("app.NewBotAPI(): failed to init telegram bot instance: %v", err)
 	}
 	lgr.Debug("Bot API instance inited")
 
-	repository := ticket_repository.New(db, cfg.DB)
+	repository, err := newRepository(ctx, cfg, lgr)
+	if err != nil {
+		return err
+	}
 	lgr.Debug("Repository layer inited")
-	service := ticket_service.New(repository, *cfg)
+	service := ticket_service.NewAudited(ticket_service.New(repository, *cfg), repository)
 	lgr.Debug("Service layer inited")
-	handler := handlers.New(service, cfg.AllowList)
+	broadcaster := ticket_service.NewBroadcastService(repository, *cfg)
+	lgr.Debug("Broadcast service inited")
+	handler := handlers.New(service, broadcaster, cfg.AllowList)
 	lgr.Debug("Handler layer inited")
 
+	go broadcaster.Run(ctx, botInstance)
+	lgr.Debug("Broadcast worker started")
+
+	go handler.RunWaitlist(ctx, botInstance)
+	lgr.Debug("Waitlist worker started")
+
+	if cfg.API.Addr != "" {
+		apiServer := api.New(ctx, service, botInstance, *cfg)
+		go func() {
+			err := apiServer.ListenAndServe()
+			if err != nil {
+				lgr.Error("HTTP API server stopped", zap.Error(err))
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+			defer cancel()
+
+			err := apiServer.Shutdown(shutdownCtx)
+			if err != nil {
+				lgr.Error("HTTP API server didn't shut down gracefully", zap.Error(err))
+			}
+		}()
+		lgr.Info("HTTP API server started", zap.String("addr", cfg.API.Addr))
+	}
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 300
 	updates := botInstance.GetUpdatesChan(u)
+	go func() {
+		<-ctx.Done()
+		botInstance.StopReceivingUpdates()
+	}()
 	lgr.Info("App inited successfully")
 
 	ch := make(chan struct{}, cfg.TG.UsersCount)
@@ -86,3 +115,53 @@ func Run() error {
 
 	return nil
 }
+
+type storage interface {
+	ticket_service.TicketsRepo
+	ticket_service.BroadcastRepo
+	ticket_service.AuditRepo
+	MoveTicketNoStart(ctx context.Context, start int64) error
+}
+
+// newRepository connects to the database and migrates it, or keeps everything in memory in demo mode.
+func newRepository(ctx context.Context, cfg *configs.Config, lgr *zap.Logger) (storage, error) {
+	var repository storage
+	if cfg.Demo.Enabled {
+		lgr.Warn("Demo mode: data is kept in memory and lost on restart")
+		repository = ticket_repository.NewMemory()
+	} else {
+		db, err := connectDatabase(cfg, lgr)
+		if err != nil {
+			return nil, err
+		}
+		repository = ticket_repository.New(db, cfg.DB)
+	}
+
+	err := repository.MoveTicketNoStart(ctx, cfg.SalesOption.TicketNoStart)
+	if err != nil {
+		return nil, fmt.Errorf("app.MoveTicketNoStart(): failed to apply TICKET_NO_START: %v", err)
+	}
+
+	return repository, nil
+}
+
+func connectDatabase(cfg *configs.Config, lgr *zap.Logger) (*sqlx.DB, error) {
+	db, err := ticket_repository.NewDatabaseConnection(cfg.DB)
+	if err != nil {
+		return nil, fmt.Errorf("app.NewDatabaseConnection(): failed to init database: %v", err)
+	}
+	lgr.Debug("Database connection configured")
+
+	m, err := migrator.New(db)
+	if err != nil {
+		return nil, fmt.Errorf("app.migrator.New(): failed to init database migrtor: %v", err)
+	}
+	lgr.Debug("Migrator inited")
+	err = m.Latest()
+	if err != nil {
+		return nil, fmt.Errorf("app.m.Latest(): failed to migrate database to latest version: %v", err)
+	}
+	lgr.Debug("Database migrated successfully")
+
+	return db, nil
+}
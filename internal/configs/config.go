@@ -1,6 +1,11 @@
 package configs
 
 import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/caarlos0/env"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
@@ -16,14 +21,17 @@ type DBConfig struct {
 }
 
 type TelegramAPIConfig struct {
-	Token      string `env:"TELEGRAM_TOKEN"`
-	UsersCount int    `env:"USERS_COUNT"`
+	Token       string `env:"TELEGRAM_TOKEN"`
+	UsersCount  int    `env:"USERS_COUNT"`
+	APIEndpoint string `env:"TELEGRAM_API_ENDPOINT" envDefault:"https://api.telegram.org/bot%s/%s"`
 }
 
 type GoogleSheets struct {
 	Secret        string `env:"SECRET_KEY"`
 	DeploymentURL string `env:"DEPLOYMENT_URL"`
-	TableID       string `env:"TABLE_ID"`
+	// UpdateURL is the deployment of scripts/sheet_update.gs, which replaces the row of an edited ticket.
+	UpdateURL string `env:"UPDATE_DEPLOYMENT_URL"`
+	TableID   string `env:"TABLE_ID"`
 }
 
 type LacesColors struct {
@@ -36,20 +44,107 @@ type SalesOptions struct {
 	VIPTablesCount int      `env:"VIP_TABLES_COUNT"`
 	Prices         []int    `env:"PRICES" envSeparator:","`
 	Dates          []string `env:"DATES" envSeparator:","`
+	TicketNoStart  int64    `env:"TICKET_NO_START" envDefault:"1"`
+	// Capacity is how many tickets may be sold, 0 for no limit. Once it is reached, buyers go to the waitlist.
+	Capacity int `env:"EVENT_CAPACITY" envDefault:"0"`
+	// WaitlistHold is how long a freed spot is held for the next person on the waitlist.
+	WaitlistHold time.Duration `env:"WAITLIST_HOLD" envDefault:"30m"`
+}
+
+type EventInfo struct {
+	Title    string `env:"EVENT_TITLE"`
+	StartsAt string `env:"EVENT_STARTS_AT"`
+	Address  string `env:"EVENT_ADDRESS"`
+	FAQFile  string `env:"FAQ_FILE"`
+	MinAge   int    `env:"EVENT_MIN_AGE" envDefault:"0"`
+	FAQ      string
+}
+
+type SearchOptions struct {
+	ResultsLimit int `env:"SEARCH_RESULTS_LIMIT" envDefault:"20"`
+	PageSize     int `env:"SEARCH_PAGE_SIZE" envDefault:"5"`
+	// BlacklistMinScore is how similar a name has to be to a blacklist entry to be flagged.
+	BlacklistMinScore float64 `env:"BLACKLIST_MIN_SCORE" envDefault:"0.5"`
+}
+
+type BroadcastOptions struct {
+	RatePerSecond int `env:"BROADCAST_RATE" envDefault:"25"`
+}
+
+type tempDoorOptions struct {
+	SnapshotSecret  string   `env:"DOOR_SNAPSHOT_SECRET"`
+	Checkpoints     []string `env:"CHECKPOINTS" envSeparator:","`
+	CheckpointRules []string `env:"CHECKPOINT_RULES" envSeparator:","`
+}
+
+type DoorOptions struct {
+	SnapshotSecret string
+	Checkpoints    []string
+	// TicketTypeCheckpoints maps a lower-case ticket type prefix ("вип") to the only checkpoints it may use.
+	TicketTypeCheckpoints map[string][]string
+}
+
+type tempZoneOptions struct {
+	Zones []string `env:"ZONES" envSeparator:","`
+	Rules []string `env:"ZONE_RULES" envSeparator:","`
+}
+
+// ZoneOptions describe the areas inside the venue with their own access control, such as a VIP area.
+type ZoneOptions struct {
+	Zones []string
+	// Rules maps a zone to the lower-case ticket type prefixes and "#<ticket number>" entries allowed in it.
+	Rules map[string][]string
+}
+
+const (
+	ReentryNone      = "none"
+	ReentryLimited   = "limited"
+	ReentryUnlimited = "unlimited"
+)
+
+// EntryOptions set whether a guest who went out may come back: never, up to MaxReentries times or always.
+type EntryOptions struct {
+	ReentryPolicy string `env:"REENTRY_POLICY" envDefault:"none"`
+	MaxReentries  int    `env:"MAX_REENTRIES" envDefault:"0"`
+}
+
+type DemoOptions struct {
+	Enabled bool `env:"DEMO_MODE" envDefault:"false"`
+}
+
+type tempAPIOptions struct {
+	Addr   string   `env:"HTTP_ADDR"`
+	Tokens []string `env:"API_TOKENS" envSeparator:","`
+}
+
+type APIOptions struct {
+	Addr   string
+	Tokens map[string]APIUser
+}
+
+// APIUser is the staff member an API token belongs to. The Telegram ID is stored with
+// their sales and other actions, as if they had used the bot.
+type APIUser struct {
+	UserName string
+	ID       int64
 }
 
 type tempAllowList struct {
 	AllowedSellers  []string `env:"ALLOWED_SELLERS"  envSeparator:","`
 	AllowedCheckers []string `env:"ALLOWED_CHECKERS" envSeparator:","`
+	ZoneCheckers    []string `env:"ZONE_CHECKERS" envSeparator:","`
 	VIPSellers      []string `env:"VIP_SELLERS"  envSeparator:","`
 	SSSellers       []string `env:"SS_SELLERS"  envSeparator:","`
+	Admins          []string `env:"ADMINS"  envSeparator:","`
 }
 
 type AllowList struct {
 	AllowedSellers  map[string]bool
 	AllowedCheckers map[string]bool
+	ZoneCheckers    map[string]bool
 	VIPSellers      map[string]bool
 	SSSellers       map[string]bool
+	Admins          map[string]bool
 }
 
 type Config struct {
@@ -59,6 +154,14 @@ type Config struct {
 	SalesOption SalesOptions
 	Sheet       GoogleSheets
 	AllowList   AllowList
+	Event       EventInfo
+	Broadcast   BroadcastOptions
+	Search      SearchOptions
+	Door        DoorOptions
+	Zone        ZoneOptions
+	Entry       EntryOptions
+	API         APIOptions
+	Demo        DemoOptions
 }
 
 func LoadEnvs() (*Config, error) {
@@ -74,6 +177,14 @@ func LoadEnvs() (*Config, error) {
 		salesOptions SalesOptions
 		sheet        GoogleSheets
 		tmpAllowList tempAllowList
+		event        EventInfo
+		broadcast    BroadcastOptions
+		search       SearchOptions
+		tmpDoor      tempDoorOptions
+		tmpZone      tempZoneOptions
+		entry        EntryOptions
+		tmpAPI       tempAPIOptions
+		demo         DemoOptions
 	)
 
 	err = env.Parse(&dbCfg)
@@ -106,17 +217,106 @@ func LoadEnvs() (*Config, error) {
 		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "List of allowed users")
 	}
 
+	err = env.Parse(&event)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Event info")
+	}
+
+	err = env.Parse(&broadcast)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Broadcast options")
+	}
+
+	err = env.Parse(&search)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Search options")
+	}
+
+	err = env.Parse(&tmpDoor)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Door options")
+	}
+
+	err = env.Parse(&tmpZone)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Zone options")
+	}
+
+	err = env.Parse(&entry)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Entry options")
+	}
+
+	switch entry.ReentryPolicy {
+	case ReentryNone, ReentryLimited, ReentryUnlimited:
+	default:
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Re-entry policy")
+	}
+
+	err = env.Parse(&tmpAPI)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "HTTP API")
+	}
+
+	err = env.Parse(&demo)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Demo mode")
+	}
+
+	checkpointRules, err := parseCheckpointRules(tmpDoor.CheckpointRules, tmpDoor.Checkpoints)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Checkpoint rules")
+	}
+
+	zoneRules, err := parseZoneRules(tmpZone.Rules, tmpZone.Zones)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "Zone rules")
+	}
+
+	apiTokens, err := parseAPITokens(tmpAPI.Tokens)
+	if err != nil {
+		return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "HTTP API tokens")
+	}
+
+	if event.FAQFile != "" {
+		faq, err := os.ReadFile(event.FAQFile)
+		if err != nil {
+			return nil, errors.Wrap(customErrors.ErrLoadEnvVars, "FAQ file")
+		}
+		event.FAQ = strings.TrimSpace(string(faq))
+	}
+
 	cfg := &Config{
 		DB:          dbCfg,
 		TG:          tgConfig,
 		LacesColor:  lacesColor,
 		SalesOption: salesOptions,
 		Sheet:       sheet,
+		Event:       event,
+		Broadcast:   broadcast,
+		Search:      search,
+		Entry:       entry,
+		Demo:        demo,
+		Door: DoorOptions{
+			SnapshotSecret:        tmpDoor.SnapshotSecret,
+			Checkpoints:           tmpDoor.Checkpoints,
+			TicketTypeCheckpoints: checkpointRules,
+		},
+		Zone: ZoneOptions{
+			Zones: tmpZone.Zones,
+			Rules: zoneRules,
+		},
+		API: APIOptions{
+			Addr:   tmpAPI.Addr,
+			Tokens: apiTokens,
+		},
 		AllowList: AllowList{
 			AllowedSellers:  SliceToMap(tmpAllowList.AllowedSellers),
 			AllowedCheckers: SliceToMap(tmpAllowList.AllowedCheckers),
+			ZoneCheckers:    SliceToMap(tmpAllowList.ZoneCheckers),
 			VIPSellers:      SliceToMap(tmpAllowList.VIPSellers),
 			SSSellers:       SliceToMap(tmpAllowList.SSSellers),
+			Admins:          SliceToMap(tmpAllowList.Admins),
 		},
 	}
 
@@ -131,3 +331,95 @@ func SliceToMap(userSlice []string) map[string]bool {
 
 	return mp
 }
+
+// parseAPITokens turns "token:username:telegramID" entries into a token -> staff member map.
+func parseAPITokens(entries []string) (map[string]APIUser, error) {
+	tokens := make(map[string]APIUser, len(entries))
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("malformed API token entry %q", entry)
+		}
+		id, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || id <= 0 {
+			return nil, errors.Errorf("malformed Telegram ID in API token entry %q", entry)
+		}
+		tokens[parts[0]] = APIUser{UserName: strings.TrimPrefix(parts[1], "@"), ID: id}
+	}
+
+	return tokens, nil
+}
+
+// parseCheckpointRules turns "ticketType:checkpoint|checkpoint" rules into a ticket type -> checkpoints map.
+// Every checkpoint has to be one of CHECKPOINTS.
+func parseCheckpointRules(rules, checkpoints []string) (map[string][]string, error) {
+	routes, err := parseRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	known := SliceToMap(checkpoints)
+	routes = lowerKeys(routes)
+	for ticketType, doors := range routes {
+		for _, door := range doors {
+			if !known[door] {
+				return nil, errors.Errorf("unknown checkpoint %q for %q", door, ticketType)
+			}
+		}
+	}
+
+	return routes, nil
+}
+
+// parseZoneRules turns "zone:ticketType|#ticketNo" rules into a zone -> allowed entries map.
+// Every zone has to be one of ZONES.
+func parseZoneRules(rules, zones []string) (map[string][]string, error) {
+	access, err := parseRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	known := SliceToMap(zones)
+	for zone, allowed := range access {
+		if !known[zone] {
+			return nil, errors.Errorf("unknown zone %q", zone)
+		}
+		for i := range allowed {
+			allowed[i] = strings.ToLower(allowed[i])
+		}
+	}
+
+	return access, nil
+}
+
+// parseRules turns "key:value|value" rules into a key -> values map, merging repeated keys.
+// Values are trimmed, and an empty one is an error: a zone rule ending in "|" would otherwise
+// match every ticket by prefix.
+func parseRules(rules []string) (map[string][]string, error) {
+	parsed := make(map[string][]string, len(rules))
+	for _, rule := range rules {
+		key, values, ok := strings.Cut(rule, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || values == "" {
+			return nil, errors.Errorf("malformed rule %q", rule)
+		}
+		for _, value := range strings.Split(values, "|") {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				return nil, errors.Errorf("empty value in rule %q", rule)
+			}
+			parsed[key] = append(parsed[key], value)
+		}
+	}
+
+	return parsed, nil
+}
+
+func lowerKeys(rules map[string][]string) map[string][]string {
+	lowered := make(map[string][]string, len(rules))
+	for key, values := range rules {
+		lowered[strings.ToLower(key)] = append(lowered[strings.ToLower(key)], values...)
+	}
+
+	return lowered
+}
@@ -0,0 +1,61 @@
+package configs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseZoneRules(t *testing.T) {
+	zones := []string{"vip", "backstage"}
+
+	tests := []struct {
+		name    string
+		rules   []string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name:  "types and ticket numbers",
+			rules: []string{"vip:VIP|#12", "backstage:#7"},
+			want:  map[string][]string{"vip": {"vip", "#12"}, "backstage": {"#7"}},
+		},
+		{
+			name:  "spaces around values",
+			rules: []string{"vip: VIP | #12 "},
+			want:  map[string][]string{"vip": {"vip", "#12"}},
+		},
+		{
+			name:    "trailing separator",
+			rules:   []string{"vip:VIP|"},
+			wantErr: true,
+		},
+		{
+			name:    "blank value",
+			rules:   []string{"vip:VIP| |#12"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown zone",
+			rules:   []string{"lounge:VIP"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseZoneRules(tt.rules, zones)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseZoneRules() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseZoneRules() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseZoneRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
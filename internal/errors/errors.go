@@ -5,4 +5,24 @@ import "github.com/pkg/errors"
 var (
 	ErrCheckingBaseParameters = errors.New("failed to check base parameters: something wrong with ")
 	ErrLoadEnvVars            = errors.New("failed to load env vars")
+
+	ErrTicketNotFound      = errors.New("ticket not found")
+	ErrPossibleNamesake    = errors.New("a ticket for the same name already exists")
+	ErrTicketNoTaken       = errors.New("ticket number is already taken")
+	ErrInvalidTicketLink   = errors.New("ticket link is invalid or bound to another account")
+	ErrTicketNotRefundable = errors.New("ticket is not found, already refunded or already used")
+	ErrImageGeneration     = errors.New("failed to generate ticket image")
+	ErrIDCheckRequired     = errors.New("guest may be under age, ID check is required")
+	ErrAlreadyInside       = errors.New("guest is already inside")
+	ErrNotInside           = errors.New("guest is not inside")
+	ErrReentryNotAllowed   = errors.New("re-entry is not allowed")
+	ErrBlacklisted         = errors.New("guest matches a blacklist entry")
+	ErrOverrideNotAllowed  = errors.New("only admins may override the blacklist")
+	ErrBlacklistNotFound   = errors.New("blacklist entry not found")
+	ErrSoldOut             = errors.New("event is sold out")
+	ErrWaitlistNotFound    = errors.New("waitlist entry not found or already handled")
+
+	ErrTicketNotTransferable = errors.New("ticket is not found, refunded or already used to enter")
+	ErrTicketNotUpgradable   = errors.New("ticket is not found, refunded or not Базовый")
+	ErrUpgradeNotAllowed     = errors.New("only VIP sellers and admins may upgrade tickets")
 )
@@ -0,0 +1,322 @@
+// Package fakebot imitates the part of the Telegram Bot API the bot uses, so that
+// handlers can be driven through scripted conversations without Telegram.
+package fakebot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	Token       = "fake-token"
+	BotUserName = "afterparty_test_bot"
+
+	maxPollWait = time.Second
+)
+
+// Request is a call the bot made to the fake server.
+type Request struct {
+	Method string
+	Params url.Values
+	// MessageID is the id of the message the call sent or edited, for pressing its buttons.
+	MessageID int
+}
+
+func (r Request) ChatID() int64 {
+	chatID, _ := strconv.ParseInt(r.Params.Get("chat_id"), 10, 64)
+	return chatID
+}
+
+// Text returns the message text, the photo caption or the callback answer text.
+func (r Request) Text() string {
+	for _, key := range []string{"text", "caption"} {
+		if value := r.Params.Get(key); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// Buttons returns the texts of the reply or inline keyboard attached to the request, row by row.
+func (r Request) Buttons() [][]string {
+	var markup struct {
+		Keyboard [][]struct {
+			Text string `json:"text"`
+		} `json:"keyboard"`
+		InlineKeyboard [][]struct {
+			Text string `json:"text"`
+		} `json:"inline_keyboard"`
+	}
+	if err := json.Unmarshal([]byte(r.Params.Get("reply_markup")), &markup); err != nil {
+		return nil
+	}
+
+	var buttons [][]string
+	for _, row := range append(markup.Keyboard, markup.InlineKeyboard...) {
+		var texts []string
+		for _, btn := range row {
+			texts = append(texts, btn.Text)
+		}
+		buttons = append(buttons, texts)
+	}
+
+	return buttons
+}
+
+// CallbackData returns the callback data of the inline button with the given text.
+func (r Request) CallbackData(buttonText string) (string, bool) {
+	var markup tgbotapi.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(r.Params.Get("reply_markup")), &markup); err != nil {
+		return "", false
+	}
+
+	for _, row := range markup.InlineKeyboard {
+		for _, btn := range row {
+			if btn.Text == buttonText && btn.CallbackData != nil {
+				return *btn.CallbackData, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Server queues updates for getUpdates and records every other call the bot makes.
+type Server struct {
+	srv *httptest.Server
+
+	mu            sync.Mutex
+	updates       []tgbotapi.Update
+	requests      []Request
+	nextUpdateID  int
+	nextMessageID int
+	changed       chan struct{}
+}
+
+func New() *Server {
+	s := &Server{
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		changed:       make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Endpoint is the value for TELEGRAM_API_ENDPOINT that points the bot at this server.
+func (s *Server) Endpoint() string {
+	return s.srv.URL + "/bot%s/%s"
+}
+
+func (s *Server) Bot() (*tgbotapi.BotAPI, error) {
+	return tgbotapi.NewBotAPIWithAPIEndpoint(Token, s.Endpoint())
+}
+
+// SendText queues a text message from the user in their private chat with the bot.
+func (s *Server) SendText(from tgbotapi.User, text string) {
+	s.SendMessage(tgbotapi.Message{From: &from, Text: text})
+}
+
+// SendMessage queues a message from msg.From. Chat, date and message id are filled in when missing.
+func (s *Server) SendMessage(msg tgbotapi.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.Chat == nil {
+		msg.Chat = &tgbotapi.Chat{ID: msg.From.ID, Type: "private", UserName: msg.From.UserName}
+	}
+	if msg.MessageID == 0 {
+		msg.MessageID = s.messageID()
+	}
+	if msg.Date == 0 {
+		msg.Date = int(time.Now().Unix())
+	}
+	if strings.HasPrefix(msg.Text, "/") {
+		command := strings.SplitN(msg.Text, " ", 2)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+
+	s.push(tgbotapi.Update{Message: &msg})
+}
+
+// PressButton queues a callback query as if the user pressed an inline button under the bot's message.
+func (s *Server) PressButton(from tgbotapi.User, messageID int, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	callback := &tgbotapi.CallbackQuery{
+		ID:   strconv.Itoa(s.nextUpdateID),
+		From: &from,
+		Message: &tgbotapi.Message{
+			MessageID: messageID,
+			Chat:      &tgbotapi.Chat{ID: from.ID, Type: "private"},
+		},
+		Data: data,
+	}
+
+	s.push(tgbotapi.Update{CallbackQuery: callback})
+}
+
+// Requests returns the recorded calls of the given method, or every call if method is empty.
+func (s *Server) Requests(method string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.filter(method)
+}
+
+// WaitFor blocks until the bot has made at least n calls of the method and returns them.
+func (s *Server) WaitFor(method string, n int, timeout time.Duration) ([]Request, error) {
+	deadline := time.After(timeout)
+
+	for {
+		s.mu.Lock()
+		found := s.filter(method)
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(found) >= n {
+			return found, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return found, fmt.Errorf("fakebot: got %d %s calls, want %d", len(found), method, n)
+		}
+	}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "bot"+Token {
+		writeResult(w, http.StatusUnauthorized, nil, "Unauthorized")
+		return
+	}
+	method := parts[1]
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		writeResult(w, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	switch method {
+	case "getMe":
+		writeResult(w, http.StatusOK, tgbotapi.User{ID: 1, IsBot: true, FirstName: "Afterparty", UserName: BotUserName}, "")
+	case "getUpdates":
+		writeResult(w, http.StatusOK, s.poll(r.Form), "")
+	case "sendMessage", "sendPhoto", "editMessageText", "editMessageReplyMarkup":
+		writeResult(w, http.StatusOK, s.record(method, r.Form), "")
+	default:
+		s.record(method, r.Form)
+		writeResult(w, http.StatusOK, true, "")
+	}
+}
+
+// poll answers getUpdates with the queued updates after offset, waiting a little for new ones
+// so that the bot's long polling does not spin.
+func (s *Server) poll(params url.Values) []tgbotapi.Update {
+	offset, _ := strconv.Atoi(params.Get("offset"))
+	deadline := time.After(maxPollWait)
+
+	for {
+		s.mu.Lock()
+		var pending []tgbotapi.Update
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				pending = append(pending, update)
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(pending) > 0 {
+			return pending
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return []tgbotapi.Update{}
+		}
+	}
+}
+
+func (s *Server) record(method string, params url.Values) tgbotapi.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messageID, _ := strconv.Atoi(params.Get("message_id"))
+	if messageID == 0 {
+		messageID = s.messageID()
+	}
+	req := Request{Method: method, Params: params, MessageID: messageID}
+	s.requests = append(s.requests, req)
+	s.notify()
+
+	return tgbotapi.Message{
+		MessageID: messageID,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: req.ChatID(), Type: "private"},
+		Text:      req.Text(),
+	}
+}
+
+func (s *Server) push(update tgbotapi.Update) {
+	update.UpdateID = s.nextUpdateID
+	s.nextUpdateID++
+	s.updates = append(s.updates, update)
+	s.notify()
+}
+
+// notify wakes up everyone waiting for a change. Must be called with s.mu held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) messageID() int {
+	id := s.nextMessageID
+	s.nextMessageID++
+	return id
+}
+
+func (s *Server) filter(method string) []Request {
+	var found []Request
+	for _, req := range s.requests {
+		if method == "" || req.Method == method {
+			found = append(found, req)
+		}
+	}
+
+	return found
+}
+
+func writeResult(w http.ResponseWriter, status int, result interface{}, description string) {
+	body := map[string]interface{}{"ok": description == ""}
+	if description == "" {
+		body["result"] = result
+	} else {
+		body["error_code"] = status
+		body["description"] = description
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
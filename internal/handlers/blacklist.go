@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	blacklistAddData         = "blacklist_add"
+	blacklistRemovePrefix    = "blacklist_remove_"
+	blacklistSaleConfirmData = "blacklist_sale_confirm"
+	blacklistSaleCancelData  = "blacklist_sale_cancel"
+	blacklistEnterPrefix     = "blacklist_enter_"
+)
+
+// sendBlacklist shows the admin the blacklist with a button to remove each entry and one to add a new one.
+func (mh *MessagesHandler) sendBlacklist(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+
+	entries, err := mh.service.Blacklist(ctx)
+	if err != nil {
+		lgr.Warn("HandleMessages:: Blacklist:: Error during Blacklist service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, entry := range entries {
+		btn := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Убрать: %s", entry.FullName), blacklistRemovePrefix+strconv.FormatInt(entry.Id, 10))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(btn))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Добавить", blacklistAddData)))
+
+	msg := tgbotapi.NewMessage(chatID, presenter.Blacklist(entries))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	_, _ = bot.Send(msg)
+}
+
+func (mh *MessagesHandler) handleBlacklistCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+	chatID := callback.Message.Chat.ID
+
+	if !utils.UserInList(callback.From.UserName, mh.cfg.Admins) {
+		lgr.Info("Unauthorized user trying to manage blacklist")
+		_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, "У Вас нет прав для управления чёрным списком."))
+		return
+	}
+	_, _ = bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+
+	if callback.Data == blacklistAddData {
+		mh.blacklists[chatID] = &models.BlacklistEntry{}
+		mh.userStates[chatID] = "awaiting_blacklist_name"
+		msg := tgbotapi.NewMessage(chatID, "Введите ФИО гостя, которого нужно добавить в чёрный список:")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, blacklistRemovePrefix), 10, 64)
+	if err != nil {
+		return
+	}
+
+	respMsg := "Запись убрана из чёрного списка."
+	err = mh.service.RemoveFromBlacklist(ctx, actorFrom(callback.From), id)
+	if err != nil {
+		lgr.Warn("HandleMessages:: RemoveFromBlacklist:: Error during RemoveFromBlacklist service method", zap.Error(err))
+		respMsg = presenter.ErrorMessage(err)
+	}
+	msg := tgbotapi.NewMessage(chatID, respMsg)
+	_, _ = bot.Send(msg)
+}
+
+func (mh *MessagesHandler) handleBlacklistName(chatID int64, bot *tgbotapi.BotAPI, text string) {
+	if len(strings.Fields(text)) < 2 {
+		msg := tgbotapi.NewMessage(chatID, "Нужны хотя бы фамилия и имя. Введите ещё раз:")
+		_, _ = bot.Send(msg)
+		return
+	}
+	mh.blacklists[chatID].FullName = text
+
+	askOptional(chatID, bot, "Отправьте фото гостя")
+	mh.userStates[chatID] = "awaiting_blacklist_photo"
+}
+
+func (mh *MessagesHandler) handleBlacklistPhoto(chatID int64, bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if len(message.Photo) > 0 {
+		// Telegram sends every size of the photo, the largest one last.
+		mh.blacklists[chatID].PhotoFileId = message.Photo[len(message.Photo)-1].FileID
+	} else if message.Text != "Пропустить" {
+		msg := tgbotapi.NewMessage(chatID, "Отправьте фото или нажмите «Пропустить»:")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Укажите причину запрета:")
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	_, _ = bot.Send(msg)
+	mh.userStates[chatID] = "awaiting_blacklist_reason"
+}
+
+func (mh *MessagesHandler) handleBlacklistReason(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, admin models.Actor, text string) {
+	lgr := logger.New(ctx)
+
+	if strings.TrimSpace(text) == "" {
+		msg := tgbotapi.NewMessage(chatID, "Причина не может быть пустой. Введите ещё раз:")
+		_, _ = bot.Send(msg)
+		return
+	}
+	mh.blacklists[chatID].Reason = text
+
+	entry, err := mh.service.AddToBlacklist(ctx, admin, *mh.blacklists[chatID])
+	if err != nil {
+		lgr.Warn("HandleMessages:: AddToBlacklist:: Error during AddToBlacklist service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	mh.userStates[chatID] = ""
+	delete(mh.blacklists, chatID)
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("%s добавлен(а) в чёрный список.", entry.FullName))
+	_, _ = bot.Send(msg)
+	utils.ShowOptions(chatID, bot, admin.UserName, mh.cfg)
+}
+
+// askBlacklistOverride stops the sale to a buyer matching the blacklist. Only an admin may go on with it.
+func (mh *MessagesHandler) askBlacklistOverride(chatID int64, bot *tgbotapi.BotAPI, userName string, blacklisted []models.BlacklistEntry) {
+	mh.sendBlacklistPhotos(chatID, bot, blacklisted)
+
+	if !utils.UserInList(userName, mh.cfg.Admins) {
+		mh.userStates[chatID] = ""
+		delete(mh.clientData, chatID)
+
+		msg := tgbotapi.NewMessage(chatID, presenter.BlacklistWarning(blacklisted)+"\n\nПродажа остановлена. Обратитесь к администратору.")
+		_, _ = bot.Send(msg)
+		utils.ShowOptions(chatID, bot, userName, mh.cfg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, presenter.BlacklistWarning(blacklisted))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Продать под мою ответственность", blacklistSaleConfirmData),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", blacklistSaleCancelData),
+		),
+	)
+	_, _ = bot.Send(msg)
+	mh.userStates[chatID] = "awaiting_blacklist_confirmation"
+}
+
+func (mh *MessagesHandler) handleBlacklistSaleCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	chatID := callback.Message.Chat.ID
+	if mh.userStates[chatID] != "awaiting_blacklist_confirmation" {
+		return
+	}
+
+	removeInlineKeyboard(ctx, bot, chatID, callback.Message.MessageID)
+
+	if callback.Data == blacklistSaleCancelData || !utils.UserInList(callback.From.UserName, mh.cfg.Admins) {
+		mh.userStates[chatID] = ""
+		delete(mh.clientData, chatID)
+
+		msg := tgbotapi.NewMessage(chatID, "Продажа отменена.")
+		_, _ = bot.Send(msg)
+		utils.ShowOptions(chatID, bot, callback.From.UserName, mh.cfg)
+		return
+	}
+
+	mh.clientData[chatID].BlacklistOverride = true
+	mh.checkNamesakes(ctx, chatID, bot, callback.From.UserName)
+}
+
+// markAsEntered lets the guest in from the search results, asking for an admin's decision
+// if the guest matches the blacklist and for an ID check if they may be under age.
+func (mh *MessagesHandler) markAsEntered(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, checker *tgbotapi.User, ticketId string) {
+	lgr := logger.New(ctx)
+
+	resp, err := mh.service.MarkAsEntered(ctx, actorFrom(checker), ticketId, mh.checkpoints[checker.ID], false)
+	switch {
+	case errors.Is(err, errs.ErrBlacklisted):
+		mh.reportBlacklisted(chatID, bot, checker.UserName, resp)
+	case errors.Is(err, errs.ErrIDCheckRequired):
+		mh.askIDCheck(chatID, bot, resp)
+	case err != nil:
+		lgr.Warn("HandleMessages:: MarkAsEntered:: Error during MarkAsEntered service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+	default:
+		mh.updateSearchResult(ctx, chatID, bot, resp)
+		msg := tgbotapi.NewMessage(chatID, presenter.Entered(resp))
+		_, _ = bot.Send(msg)
+	}
+}
+
+// reportBlacklisted refuses the entry of a blacklisted guest. An admin may let them in anyway.
+func (mh *MessagesHandler) reportBlacklisted(chatID int64, bot *tgbotapi.BotAPI, userName string, resp *models.TicketResponse) {
+	mh.sendBlacklistPhotos(chatID, bot, resp.Blacklist)
+
+	text := fmt.Sprintf("%s\n\n%s (ID: %s).", presenter.BlacklistWarning(resp.Blacklist), resp.Name, resp.Id)
+	if !utils.UserInList(userName, mh.cfg.Admins) {
+		msg := tgbotapi.NewMessage(chatID, text+" Не впускайте гостя и позовите администратора.")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Впустить под мою ответственность", blacklistEnterPrefix+resp.Id),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", idCheckCancelPrefix+resp.Id),
+		),
+	)
+	_, _ = bot.Send(msg)
+}
+
+func (mh *MessagesHandler) handleBlacklistEnterCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+	chatID := callback.Message.Chat.ID
+	ticketId := strings.TrimPrefix(callback.Data, blacklistEnterPrefix)
+
+	removeInlineKeyboard(ctx, bot, chatID, callback.Message.MessageID)
+
+	_, err := mh.service.OverrideBlacklist(ctx, actorFrom(callback.From), ticketId)
+	if err != nil {
+		lgr.Warn("HandleMessages:: OverrideBlacklist:: Error during OverrideBlacklist service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	mh.markAsEntered(ctx, chatID, bot, callback.From, ticketId)
+}
+
+// sendBlacklistPhotos sends the photos of the blacklist entries, so the guest can be compared with them.
+func (mh *MessagesHandler) sendBlacklistPhotos(chatID int64, bot *tgbotapi.BotAPI, entries []models.BlacklistEntry) {
+	for _, entry := range entries {
+		if entry.PhotoFileId == "" {
+			continue
+		}
+
+		photoMsg := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(entry.PhotoFileId))
+		photoMsg.Caption = fmt.Sprintf("⛔ Чёрный список: %s — %s", entry.FullName, entry.Reason)
+		_, _ = bot.Send(photoMsg)
+	}
+}
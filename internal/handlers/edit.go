@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	editFieldFIO   = "ФИО"
+	editFieldType  = "Тип билета"
+	editFieldPrice = "Цена"
+)
+
+func (mh *MessagesHandler) handleEditTicketNo(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, admin models.Actor, text string) {
+	lgr := logger.New(ctx)
+
+	if _, err := strconv.Atoi(text); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Номер билета должен быть числом. Введите ещё раз:")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	ticket, err := mh.service.SearchById(ctx, admin, text)
+	if err != nil {
+		lgr.Warn("HandleMessages:: SearchById:: Error during SearchById service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	mh.edits[chatID] = &models.TicketEdit{TicketId: ticket.Id}
+	mh.userStates[chatID] = "awaiting_edit_field"
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Билет №%s (%s), владелец: %s.\n\nЧто нужно изменить?", ticket.Id, ticket.TicketType, ticket.Name))
+	msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(editFieldFIO),
+			tgbotapi.NewKeyboardButton(editFieldType),
+			tgbotapi.NewKeyboardButton(editFieldPrice),
+		),
+	)
+	_, _ = bot.Send(msg)
+}
+
+func (mh *MessagesHandler) handleEditField(chatID int64, bot *tgbotapi.BotAPI, text string) {
+	var prompt string
+	switch text {
+	case editFieldFIO:
+		mh.userStates[chatID] = "awaiting_edit_fio"
+		prompt = "Введите исправленное ФИО:"
+	case editFieldType:
+		mh.userStates[chatID] = "awaiting_edit_type"
+		prompt = "Введите новый тип билета (Базовый или ВИП с номером стола, например ВИП3):"
+	case editFieldPrice:
+		mh.userStates[chatID] = "awaiting_edit_price"
+		prompt = "Введите исправленную стоимость билета:"
+	default:
+		msg := tgbotapi.NewMessage(chatID, "Выберите, что изменить, кнопкой ниже.")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, prompt)
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	_, _ = bot.Send(msg)
+}
+
+// handleEditValue checks the new value like the sale does and applies the edit.
+func (mh *MessagesHandler) handleEditValue(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, admin models.Actor, text string) {
+	edit := mh.edits[chatID]
+
+	switch mh.userStates[chatID] {
+	case "awaiting_edit_fio":
+		fio, err := utils.FormatFIO(text)
+		if err != nil {
+			msg := tgbotapi.NewMessage(chatID, "Проверьте введенное ФИО")
+			_, _ = bot.Send(msg)
+			return
+		}
+		edit.FullName = fio
+	case "awaiting_edit_type":
+		text = strings.ReplaceAll(text, " ", "")
+		if _, ok := utils.ValidateTicketType(text, mh.service.Cfg.SalesOption); !ok {
+			msg := tgbotapi.NewMessage(chatID, "Неверный тип (возможно неправильный формат стола). Попробуйте ещё раз:")
+			_, _ = bot.Send(msg)
+			return
+		}
+		edit.TicketType = text
+	case "awaiting_edit_price":
+		// Whether the ticket's seller could sell at this price is checked by EditTicket.
+		price, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil || price <= 0 {
+			msg := tgbotapi.NewMessage(chatID, "Проверьте введенную цену. Попробуйте ещё раз:")
+			_, _ = bot.Send(msg)
+			return
+		}
+		edit.Price = price
+	}
+
+	mh.editTicket(ctx, chatID, bot, admin)
+}
+
+func (mh *MessagesHandler) editTicket(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, admin models.Actor) {
+	lgr := logger.New(ctx)
+
+	edited, err := mh.service.EditTicket(ctx, admin, *mh.edits[chatID])
+	if errors.Is(err, errs.ErrCheckingBaseParameters) {
+		*mh.edits[chatID] = models.TicketEdit{TicketId: mh.edits[chatID].TicketId}
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err)+". Введите ещё раз:")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	mh.userStates[chatID] = ""
+	delete(mh.edits, chatID)
+
+	respMsg := ""
+	if err != nil {
+		lgr.Warn("HandleMessages:: EditTicket:: Error during EditTicket service method", zap.Error(err))
+		respMsg = presenter.ErrorMessage(err)
+	} else {
+		respMsg = presenter.Edited(edited)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, respMsg)
+	_, _ = bot.Send(msg)
+	utils.ShowOptions(chatID, bot, admin.UserName, mh.cfg)
+}
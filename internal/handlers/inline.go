@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	inlineEnterPrefix   = "inline_enter_"
+	inlineEnterIDPrefix = "inline_enter_id_"
+	inlineExitPrefix    = "inline_exit_"
+	inlineCacheTime     = 0
+)
+
+func (mh *MessagesHandler) handleInlineQuery(ctx context.Context, query *tgbotapi.InlineQuery, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		CacheTime:     inlineCacheTime,
+		IsPersonal:    true,
+		Results:       []interface{}{},
+	}
+
+	if !utils.UserInList(query.From.UserName, mh.cfg.AllowedCheckers) {
+		lgr.Info("Unauthorized user trying to use inline search")
+		answer.SwitchPMText = "Поиск доступен только контролёрам"
+		answer.SwitchPMParameter = "inline_denied"
+		_, _ = bot.Request(answer)
+		return
+	}
+
+	text := strings.TrimSpace(query.Query)
+	if text == "" {
+		_, _ = bot.Request(answer)
+		return
+	}
+
+	var tickets []models.TicketResponse
+	if _, err := strconv.Atoi(text); err == nil {
+		resp, err := mh.service.SearchById(ctx, actorFrom(query.From), text)
+		if err != nil {
+			lgr.Debug("HandleInlineQuery:: SearchById:: Nothing found", zap.Error(err))
+		}
+		if resp != nil {
+			tickets = append(tickets, *resp)
+		}
+	} else {
+		respList, err := mh.service.SearchBySurname(ctx, actorFrom(query.From), text)
+		if err != nil {
+			lgr.Warn("HandleInlineQuery:: SearchBySurname:: Error during SearchBySurname service method", zap.Error(err))
+		}
+		tickets = respList
+	}
+
+	for _, ticket := range tickets {
+		answer.Results = append(answer.Results, mh.inlineTicketResult(&ticket, query.From.ID))
+	}
+
+	_, err := bot.Request(answer)
+	if err != nil {
+		lgr.Warn("HandleInlineQuery:: Failed to answer inline query", zap.Error(err))
+	}
+}
+
+func (mh *MessagesHandler) inlineTicketResult(ticket *models.TicketResponse, checkerID int64) tgbotapi.InlineQueryResultArticle {
+	laces := mh.service.Cfg.LacesColor
+
+	laceColor, ok := utils.LaceColor(ticket.TicketType, laces)
+	if !ok {
+		laceColor = "?"
+	}
+	entranceStatus := "❌ не внутри"
+	if ticket.PassedControlZone {
+		entranceStatus = "✅ внутри"
+	}
+
+	result := tgbotapi.NewInlineQueryResultArticle(ticket.Id, fmt.Sprintf("%s (№%s)", ticket.Name, ticket.Id), mh.ticketCard(ticket, checkerID))
+	result.Description = fmt.Sprintf("%s · %s · %s", ticket.TicketType, laceColor, entranceStatus)
+	result.ReplyMarkup = inlineEntryKeyboard(ticket)
+
+	return result
+}
+
+// inlineEntryKeyboard offers «Выход» for a guest who is inside and «Впустить» otherwise.
+func inlineEntryKeyboard(ticket *models.TicketResponse) *tgbotapi.InlineKeyboardMarkup {
+	btn := tgbotapi.NewInlineKeyboardButtonData("Впустить", inlineEnterPrefix+ticket.Id)
+	if ticket.PassedControlZone {
+		btn = tgbotapi.NewInlineKeyboardButtonData("Выход", inlineExitPrefix+ticket.Id)
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(btn))
+	return &keyboard
+}
+
+func (mh *MessagesHandler) handleInlineEnterCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+
+	if !utils.UserInList(callback.From.UserName, mh.cfg.AllowedCheckers) {
+		lgr.Info("Unauthorized user trying to mark entrance from inline result")
+		_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, "У Вас нет прав для отметки входа."))
+		return
+	}
+
+	userId, idChecked := strings.TrimPrefix(callback.Data, inlineEnterIDPrefix), true
+	if userId == callback.Data {
+		userId, idChecked = strings.TrimPrefix(callback.Data, inlineEnterPrefix), false
+	}
+
+	resp, err := mh.service.MarkAsEntered(ctx, actorFrom(callback.From), userId, mh.checkpoints[callback.From.ID], idChecked)
+	if errors.Is(err, errs.ErrIDCheckRequired) {
+		_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, presenter.IDCheckPrompt(resp, mh.service.Cfg.Event.MinAge)))
+
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Документ проверен — впустить", inlineEnterIDPrefix+userId)),
+		)
+		edit := tgbotapi.EditMessageReplyMarkupConfig{
+			BaseEdit: tgbotapi.BaseEdit{InlineMessageID: callback.InlineMessageID, ReplyMarkup: &keyboard},
+		}
+		_, _ = bot.Request(edit)
+		return
+	}
+	if err != nil {
+		lgr.Warn("HandleInlineEnterCallback:: MarkAsEntered:: Error during MarkAsEntered service method", zap.Error(err))
+		_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, presenter.ErrorMessage(err)))
+		return
+	}
+	_, _ = bot.Request(tgbotapi.NewCallback(callback.ID, presenter.Entered(resp)))
+
+	mh.updateInlineTicket(ctx, callback, bot, resp)
+}
+
+func (mh *MessagesHandler) handleInlineExitCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+
+	if !utils.UserInList(callback.From.UserName, mh.cfg.AllowedCheckers) {
+		lgr.Info("Unauthorized user trying to mark exit from inline result")
+		_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, "У Вас нет прав для отметки выхода."))
+		return
+	}
+
+	userId := strings.TrimPrefix(callback.Data, inlineExitPrefix)
+	resp, err := mh.service.MarkAsExited(ctx, actorFrom(callback.From), userId, mh.checkpoints[callback.From.ID])
+	if err != nil {
+		lgr.Warn("HandleInlineExitCallback:: MarkAsExited:: Error during MarkAsExited service method", zap.Error(err))
+		_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, presenter.ErrorMessage(err)))
+		return
+	}
+	_, _ = bot.Request(tgbotapi.NewCallback(callback.ID, presenter.Exited(resp)))
+
+	mh.updateInlineTicket(ctx, callback, bot, resp)
+}
+
+// updateInlineTicket redraws the card posted from an inline result with the new entry status.
+func (mh *MessagesHandler) updateInlineTicket(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI, resp *models.TicketResponse) {
+	edit := tgbotapi.EditMessageTextConfig{
+		BaseEdit: tgbotapi.BaseEdit{InlineMessageID: callback.InlineMessageID, ReplyMarkup: inlineEntryKeyboard(resp)},
+		Text:     mh.ticketCard(resp, callback.From.ID),
+	}
+	_, err := bot.Request(edit)
+	if err != nil {
+		logger.New(ctx).Warn("HandleInlineEnterCallback:: Failed to update inline message", zap.Error(err))
+	}
+}
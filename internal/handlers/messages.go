@@ -1,41 +1,110 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
-	"fmt"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
 	"github.com/qRe0/afterparty-bot/internal/configs"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
 	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
 	"github.com/qRe0/afterparty-bot/internal/service"
 	"github.com/qRe0/afterparty-bot/internal/shared/logger"
 	"github.com/qRe0/afterparty-bot/internal/shared/utils"
 	"go.uber.org/zap"
 )
 
+const (
+	broadcastTargetStaff      = "Весь персонал"
+	broadcastTargetCheckers   = "Контролёры"
+	broadcastTargetSellers    = "Продавцы"
+	broadcastTargetBuyers     = "Покупатели"
+	broadcastTargetTicketType = "Тип билета"
+
+	broadcastConfirmData = "broadcast_confirm"
+	broadcastCancelData  = "broadcast_cancel"
+	idCheckConfirmPrefix = "confirm_yes_"
+	idCheckCancelPrefix  = "confirm_no_"
+	namesakeConfirmData  = "namesake_confirm"
+	namesakeCancelData   = "namesake_cancel"
+	ticketLinkPrefix     = "/start t_"
+	auditCommand         = "/audit"
+	defaultFAQ           = "Билет можно открыть в любой момент кнопкой «Мой билет» или командой /myticket.\n\nНа входе назовите фамилию или номер билета — после проверки Вам выдадут браслет.\n\nПо остальным вопросам обращайтесь к продавцу, у которого Вы купили билет."
+)
+
 type TicketsService interface {
-	SearchBySurname(ctx context.Context, surname *string, chatID *int64, bot *tgbotapi.BotAPI) ([]models.TicketResponse, string, error)
-	SearchById(ctx context.Context, userId *string, chatID *int64, bot *tgbotapi.BotAPI) (*models.TicketResponse, string, error)
-	SellTicket(ctx context.Context, update tgbotapi.Update, bot *tgbotapi.BotAPI, client *models.ClientData) (string, *bytes.Buffer, bool, error)
-	MarkAsEntered(ctx context.Context, userId *string, chatID *int64, bot *tgbotapi.BotAPI) (string, error)
+	SearchBySurname(ctx context.Context, staff models.Actor, surname string) ([]models.TicketResponse, error)
+	SearchById(ctx context.Context, staff models.Actor, ticketId string) (*models.TicketResponse, error)
+	SellTicket(ctx context.Context, seller models.Actor, client models.ClientData) (*models.SaleResult, error)
+	MarkAsEntered(ctx context.Context, checker models.Actor, ticketId, checkpoint string, idChecked bool) (*models.TicketResponse, error)
+	MarkAsExited(ctx context.Context, checker models.Actor, ticketId, checkpoint string) (*models.TicketResponse, error)
+	CheckZoneAccess(ctx context.Context, checker models.Actor, zone, ticketId string) (*models.ZoneScan, error)
+	BlacklistMatches(ctx context.Context, fullName string) ([]models.BlacklistEntry, error)
+	OverrideBlacklist(ctx context.Context, admin models.Actor, ticketId string) (*models.TicketResponse, error)
+	ReissueTicket(ctx context.Context, actor models.Actor, ticketId string) (*models.IssuedTicket, error)
+	LinkTicket(ctx context.Context, token string, buyer models.Actor, chatID int64) (*models.IssuedTicket, error)
+	MyTickets(ctx context.Context, chatID int64) ([]models.TicketResponse, error)
+	TicketImage(ctx context.Context, ticketId string) ([]byte, error)
+	TicketAudit(ctx context.Context, ticketNo string) ([]models.AuditRecord, error)
+	Waitlist(ctx context.Context) ([]models.WaitlistEntry, error)
+	AddToWaitlist(ctx context.Context, seller models.Actor, chatID int64, entry models.WaitlistEntry) (*models.WaitlistEntry, error)
+	RemoveFromWaitlist(ctx context.Context, actor models.Actor, id int64) error
+	WaitlistOffer(ctx context.Context, id int64) (*models.WaitlistEntry, error)
+	DeclineWaitlistOffer(ctx context.Context, seller models.Actor, id int64) (*models.WaitlistEntry, error)
+	SoldOut(ctx context.Context) (bool, error)
+	TransferTicket(ctx context.Context, actor models.Actor, transfer models.TicketTransfer) (*models.TicketTransfer, error)
+	EditTicket(ctx context.Context, admin models.Actor, edit models.TicketEdit) (*models.TicketEditResult, error)
+	UpgradeQuote(ctx context.Context, upgrade models.TicketUpgrade) (*models.TicketUpgradeResult, error)
+	UpgradeTicket(ctx context.Context, seller models.Actor, upgrade models.TicketUpgrade) (*models.TicketUpgradeResult, error)
+}
+
+type BroadcastService interface {
+	RememberUser(ctx context.Context, chatID int64, userName string)
+	PreviewBroadcast(ctx context.Context, draft *models.Broadcast) (string, int, error)
+	StartBroadcast(ctx context.Context, draft *models.Broadcast) (string, error)
 }
 
 type MessagesHandler struct {
-	service    *ticket_service.TicketsService
-	userStates map[int64]string
-	clientData map[int64]*models.ClientData
-	cfg        configs.AllowList
+	service     *ticket_service.AuditedTicketsService
+	broadcaster BroadcastService
+	userStates  map[int64]string
+	clientData  map[int64]*models.ClientData
+	broadcasts  map[int64]*models.Broadcast
+	searches    map[int64]*searchSession
+	blacklists  map[int64]*models.BlacklistEntry
+	waitlists   map[int64]*models.WaitlistEntry
+	transfers   map[int64]*models.TicketTransfer
+	edits       map[int64]*models.TicketEdit
+	upgrades    map[int64]*models.TicketUpgrade
+	cfg         configs.AllowList
+
+	// checkpoints and zones hold the checkpoint each checker chose at /start and the zone
+	// each zone checker guards, by user ID (the same as the ID of their private chat with the bot).
+	checkpoints map[int64]string
+	zones       map[int64]string
 }
 
-func New(service *ticket_service.TicketsService, cfg configs.AllowList) MessagesHandler {
+func New(service *ticket_service.AuditedTicketsService, broadcaster BroadcastService, cfg configs.AllowList) MessagesHandler {
 	return MessagesHandler{
-		service:    service,
-		userStates: make(map[int64]string),
-		clientData: make(map[int64]*models.ClientData),
-		cfg:        cfg,
+		service:     service,
+		broadcaster: broadcaster,
+		userStates:  make(map[int64]string),
+		clientData:  make(map[int64]*models.ClientData),
+		broadcasts:  make(map[int64]*models.Broadcast),
+		searches:    make(map[int64]*searchSession),
+		blacklists:  make(map[int64]*models.BlacklistEntry),
+		waitlists:   make(map[int64]*models.WaitlistEntry),
+		transfers:   make(map[int64]*models.TicketTransfer),
+		edits:       make(map[int64]*models.TicketEdit),
+		upgrades:    make(map[int64]*models.TicketUpgrade),
+		checkpoints: make(map[int64]string),
+		zones:       make(map[int64]string),
+		cfg:         cfg,
 	}
 }
 
@@ -43,33 +112,96 @@ func (mh *MessagesHandler) HandleMessages(ctx context.Context, update tgbotapi.U
 	var chatID int64
 	lgr := logger.New(ctx)
 
+	if update.InlineQuery != nil {
+		mh.handleInlineQuery(ctx, update.InlineQuery, bot)
+		return
+	}
+
+	if update.CallbackQuery != nil && strings.HasPrefix(update.CallbackQuery.Data, inlineEnterPrefix) {
+		mh.handleInlineEnterCallback(ctx, update.CallbackQuery, bot)
+		return
+	}
+
+	if update.CallbackQuery != nil && strings.HasPrefix(update.CallbackQuery.Data, inlineExitPrefix) {
+		mh.handleInlineExitCallback(ctx, update.CallbackQuery, bot)
+		return
+	}
+
 	if update.CallbackQuery != nil {
 		chatID = update.CallbackQuery.Message.Chat.ID
 		data := update.CallbackQuery.Data
 
-		if strings.HasPrefix(data, "confirm_yes_") {
-			userId := strings.TrimPrefix(data, "confirm_yes_")
-			msg, err := mh.service.MarkAsEntered(ctx, &userId, &chatID, bot)
+		if strings.HasPrefix(data, idCheckConfirmPrefix) {
+			if !mh.allowedAtDoor(ctx, update.CallbackQuery, bot) {
+				return
+			}
+			userId := strings.TrimPrefix(data, idCheckConfirmPrefix)
+			removeInlineKeyboard(ctx, bot, chatID, update.CallbackQuery.Message.MessageID)
+			resp, err := mh.service.MarkAsEntered(ctx, actorFrom(update.CallbackQuery.From), userId, mh.checkpoints[update.CallbackQuery.From.ID], true)
+			msg := presenter.ErrorMessage(err)
 			if err != nil {
 				lgr.Warn("HandleMessages:: MarkAsEntered:: Error during MarkAsEntered service method (1st call) with error: ", zap.Error(err))
-				botMsg := tgbotapi.NewMessage(chatID, msg)
-				_, _ = bot.Send(botMsg)
+			} else {
+				msg = presenter.Entered(resp)
+				mh.updateSearchResult(ctx, chatID, bot, resp)
 			}
 			botMsg := tgbotapi.NewMessage(chatID, msg)
 			_, _ = bot.Send(botMsg)
-		} else if strings.HasPrefix(data, "confirm_no_") {
-			msg := tgbotapi.NewMessage(chatID, "Операция отменена.")
-			_, _ = bot.Send(msg)
-		} else {
-			userId := data
-			msg, err := mh.service.MarkAsEntered(ctx, &userId, &chatID, bot)
+		} else if strings.HasPrefix(data, searchExitPrefix) {
+			if !mh.allowedAtDoor(ctx, update.CallbackQuery, bot) {
+				return
+			}
+			userId := strings.TrimPrefix(data, searchExitPrefix)
+			resp, err := mh.service.MarkAsExited(ctx, actorFrom(update.CallbackQuery.From), userId, mh.checkpoints[update.CallbackQuery.From.ID])
+			msg := presenter.ErrorMessage(err)
 			if err != nil {
-				lgr.Warn("HandleMessages:: MarkAsEntered:: Error during MarkAsEntered service method (2nd call) with error: ", zap.Error(err))
-				botMsg := tgbotapi.NewMessage(chatID, msg)
-				_, _ = bot.Send(botMsg)
+				lgr.Warn("HandleMessages:: MarkAsExited:: Error during MarkAsExited service method", zap.Error(err))
+			} else {
+				msg = presenter.Exited(resp)
+				mh.updateSearchResult(ctx, chatID, bot, resp)
 			}
 			botMsg := tgbotapi.NewMessage(chatID, msg)
 			_, _ = bot.Send(botMsg)
+		} else if strings.HasPrefix(data, blacklistEnterPrefix) {
+			mh.handleBlacklistEnterCallback(ctx, update.CallbackQuery, bot)
+		} else if data == blacklistAddData || strings.HasPrefix(data, blacklistRemovePrefix) {
+			mh.handleBlacklistCallback(ctx, update.CallbackQuery, bot)
+			return
+		} else if data == waitlistAddData || strings.HasPrefix(data, waitlistRemovePrefix) {
+			mh.handleWaitlistCallback(ctx, update.CallbackQuery, bot)
+			return
+		} else if strings.HasPrefix(data, waitlistSellPrefix) || strings.HasPrefix(data, waitlistDeclinePrefix) {
+			mh.handleWaitlistOfferCallback(ctx, update.CallbackQuery, bot)
+		} else if data == transferBlacklistConfirmData || data == transferBlacklistCancelData {
+			mh.handleTransferBlacklistCallback(ctx, update.CallbackQuery, bot)
+		} else if strings.HasPrefix(data, transferReissuePrefix) {
+			mh.handleTransferReissueCallback(ctx, update.CallbackQuery, bot)
+		} else if data == upgradeConfirmData || data == upgradeCancelData {
+			mh.handleUpgradeCallback(ctx, update.CallbackQuery, bot)
+		} else if data == blacklistSaleConfirmData || data == blacklistSaleCancelData {
+			mh.handleBlacklistSaleCallback(ctx, update.CallbackQuery, bot)
+		} else if strings.HasPrefix(data, zoneCheckPrefix) {
+			mh.handleZoneCheckCallback(ctx, update.CallbackQuery, bot)
+			return
+		} else if strings.HasPrefix(data, idCheckCancelPrefix) {
+			removeInlineKeyboard(ctx, bot, chatID, update.CallbackQuery.Message.MessageID)
+			msg := tgbotapi.NewMessage(chatID, "Операция отменена.")
+			_, _ = bot.Send(msg)
+		} else if data == namesakeConfirmData || data == namesakeCancelData {
+			mh.handleNamesakeCallback(ctx, update.CallbackQuery, bot, data)
+		} else if data == broadcastConfirmData || data == broadcastCancelData {
+			mh.handleBroadcastCallback(ctx, update, bot, chatID, data)
+		} else if strings.HasPrefix(data, searchPagePrefix) {
+			mh.handleSearchPageCallback(ctx, update.CallbackQuery, bot)
+			return
+		} else if data == searchNoopData {
+			_, _ = bot.Request(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+			return
+		} else {
+			if !mh.allowedAtDoor(ctx, update.CallbackQuery, bot) {
+				return
+			}
+			mh.markAsEntered(ctx, chatID, bot, update.CallbackQuery.From, data)
 		}
 
 		callback := tgbotapi.NewCallback(update.CallbackQuery.ID, "")
@@ -85,18 +217,106 @@ func (mh *MessagesHandler) HandleMessages(ctx context.Context, update tgbotapi.U
 		text := update.Message.Text
 		userName := update.Message.From.UserName
 
+		if strings.HasPrefix(text, ticketLinkPrefix) {
+			token := strings.TrimPrefix(text, ticketLinkPrefix)
+			issued, err := mh.service.LinkTicket(ctx, token, actorFrom(update.Message.From), chatID)
+			if err != nil {
+				lgr.Warn("HandleMessages:: LinkTicket:: Error during LinkTicket service method", zap.Error(err))
+				msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+				_, _ = bot.Send(msg)
+				return
+			}
+
+			sendTicket(chatID, bot, issued.Image, presenter.Linked(issued))
+
+			if !utils.IsStaff(userName, mh.cfg) {
+				utils.ShowBuyerOptions(chatID, bot)
+			}
+			return
+		}
+
+		if command, ticketNo, _ := strings.Cut(text, " "); command == auditCommand {
+			mh.sendAudit(ctx, chatID, bot, userName, strings.TrimSpace(ticketNo))
+			return
+		}
+
 		switch text {
+		case "/myticket", "Мой билет":
+			mh.sendLinkedTickets(ctx, chatID, bot)
+			return
+
+		case "/event", "О мероприятии":
+			msg := tgbotapi.NewMessage(chatID, presenter.EventInfoMapper(mh.service.Cfg.Event))
+			_, _ = bot.Send(msg)
+			return
+
+		case "/help", "Помощь":
+			faq := mh.service.Cfg.Event.FAQ
+			if faq == "" {
+				faq = defaultFAQ
+			}
+			msg := tgbotapi.NewMessage(chatID, faq)
+			_, _ = bot.Send(msg)
+			return
+
 		case "/start":
-			if !utils.UserInList(userName, mh.cfg.AllowedCheckers) && !utils.UserInList(userName, mh.cfg.AllowedSellers) {
-				lgr.Info("Unauthorized user trying to use bot")
-				msg := tgbotapi.NewMessage(chatID, "У Вас нет прав на использование бота.")
-				_, _ = bot.Send(msg)
+			if !utils.IsStaff(userName, mh.cfg) {
+				lgr.Info("Buyer started bot")
+				mh.userStates[chatID] = ""
+				utils.ShowBuyerOptions(chatID, bot)
 				return
 			}
+			mh.broadcaster.RememberUser(ctx, chatID, userName)
 			mh.userStates[chatID] = ""
+			if utils.UserInList(userName, mh.cfg.AllowedCheckers) && len(mh.service.Cfg.Door.Checkpoints) > 0 {
+				mh.askCheckpoint(chatID, bot)
+				return
+			}
 			utils.ShowOptions(chatID, bot, userName, mh.cfg)
 			return
 
+		case "/broadcast":
+			if !utils.UserInList(userName, mh.cfg.Admins) {
+				lgr.Info("Unauthorized user trying to use bot")
+				msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для рассылки сообщений.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.broadcasts[chatID] = &models.Broadcast{
+				AuthorTag:    "@" + userName,
+				AuthorChatId: chatID,
+			}
+			mh.userStates[chatID] = "awaiting_broadcast_target"
+
+			replyKeyboard := tgbotapi.NewReplyKeyboard(
+				tgbotapi.NewKeyboardButtonRow(
+					tgbotapi.NewKeyboardButton(broadcastTargetStaff),
+					tgbotapi.NewKeyboardButton(broadcastTargetCheckers),
+					tgbotapi.NewKeyboardButton(broadcastTargetSellers),
+				),
+				tgbotapi.NewKeyboardButtonRow(
+					tgbotapi.NewKeyboardButton(broadcastTargetBuyers),
+					tgbotapi.NewKeyboardButton(broadcastTargetTicketType),
+				),
+			)
+			replyKeyboard.OneTimeKeyboard = true
+			replyKeyboard.ResizeKeyboard = true
+
+			msg := tgbotapi.NewMessage(chatID, "Выберите получателей рассылки:")
+			msg.ReplyMarkup = replyKeyboard
+			_, _ = bot.Send(msg)
+			return
+
+		case "/blacklist":
+			if !utils.UserInList(userName, mh.cfg.Admins) {
+				lgr.Info("Unauthorized user trying to use bot")
+				msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для управления чёрным списком.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.sendBlacklist(ctx, chatID, bot)
+			return
+
 		case "Отметить вход":
 			if !utils.UserInList(userName, mh.cfg.AllowedCheckers) {
 				lgr.Info("Unauthorized user trying to use bot")
@@ -105,10 +325,20 @@ func (mh *MessagesHandler) HandleMessages(ctx context.Context, update tgbotapi.U
 				return
 			}
 			mh.userStates[chatID] = "awaiting_id_surname"
-			msg := tgbotapi.NewMessage(chatID, "Введите фамилию или номер билета для поиска:")
+			msg := tgbotapi.NewMessage(chatID, "Введите фамилию, имя или номер билета для поиска:")
 			_, _ = bot.Send(msg)
 			return
 
+		case "Проверить доступ":
+			if !utils.UserInList(userName, mh.cfg.ZoneCheckers) {
+				lgr.Info("Unauthorized user trying to use bot")
+				msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для проверки доступа.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.startZoneCheck(chatID, update.Message.From.ID, bot)
+			return
+
 		case "Продать билет":
 			if !utils.UserInList(userName, mh.cfg.AllowedSellers) {
 				lgr.Info("Unauthorized user trying to use bot")
@@ -116,60 +346,137 @@ func (mh *MessagesHandler) HandleMessages(ctx context.Context, update tgbotapi.U
 				_, _ = bot.Send(msg)
 				return
 			}
+			soldOut, err := mh.service.SoldOut(ctx)
+			if err != nil {
+				// SellTicket checks the capacity again, so the sale may go on.
+				lgr.Warn("HandleMessages:: SoldOut:: Error during SoldOut service method", zap.Error(err))
+			}
+			if soldOut {
+				mh.offerWaitlist(chatID, bot)
+				return
+			}
 			mh.clientData[chatID] = &models.ClientData{}
 			mh.userStates[chatID] = "awaiting_client_fio"
 			msg := tgbotapi.NewMessage(chatID, "Введите ФИО покупателя:")
 			_, _ = bot.Send(msg)
 			return
+
+		case "/waitlist", "Лист ожидания":
+			if !utils.UserInList(userName, mh.cfg.AllowedSellers) && !utils.UserInList(userName, mh.cfg.Admins) {
+				lgr.Info("Unauthorized user trying to use bot")
+				msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для работы с листом ожидания.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.sendWaitlist(ctx, chatID, bot, userName)
+			return
+
+		case "Передать билет":
+			if !mh.canTransfer(userName) {
+				lgr.Info("Unauthorized user trying to use bot")
+				msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для передачи билетов.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.userStates[chatID] = "awaiting_transfer_ticket_no"
+			msg := tgbotapi.NewMessage(chatID, "Введите номер билета, который нужно передать другому человеку:")
+			_, _ = bot.Send(msg)
+			return
+
+		case "Повысить до ВИП":
+			if !utils.CanUpgradeToVIP(userName, mh.cfg) {
+				lgr.Info("Unauthorized user trying to use bot")
+				msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для продажи ВИП-билетов.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.userStates[chatID] = "awaiting_upgrade_ticket_no"
+			msg := tgbotapi.NewMessage(chatID, "Введите номер билета, который нужно повысить до ВИП:")
+			_, _ = bot.Send(msg)
+			return
+
+		case "/edit", "Изменить билет":
+			if !utils.UserInList(userName, mh.cfg.Admins) {
+				lgr.Info("Unauthorized user trying to use bot")
+				msg := tgbotapi.NewMessage(chatID, "Изменять проданные билеты может только администратор.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.userStates[chatID] = "awaiting_edit_ticket_no"
+			msg := tgbotapi.NewMessage(chatID, "Введите номер билета, который нужно изменить:")
+			_, _ = bot.Send(msg)
+			return
+
+		case "Перевыпустить билет":
+			if !utils.UserInList(userName, mh.cfg.AllowedSellers) && !utils.UserInList(userName, mh.cfg.Admins) {
+				lgr.Info("Unauthorized user trying to use bot")
+				msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для перевыпуска билетов.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.userStates[chatID] = "awaiting_reissue_ticket_no"
+			msg := tgbotapi.NewMessage(chatID, "Введите номер билета для перевыпуска:")
+			_, _ = bot.Send(msg)
+			return
 		}
 
 		switch mh.userStates[chatID] {
-		case "awaiting_id_surname":
-			if _, err := strconv.Atoi(text); err == nil {
-				resp, respMsg, err := mh.service.SearchById(ctx, &update.Message.Text, &chatID, bot)
-				if err != nil {
-					lgr.Warn("HandleMessages:: SearchById:: Error during MarkAsEntered service method", zap.Error(err))
-					botMsg := tgbotapi.NewMessage(chatID, respMsg)
-					_, _ = bot.Send(botMsg)
-				}
-				if respMsg != "Не найдено пользователся с указанным номером билета или ФИО" {
-					msg := tgbotapi.NewMessage(chatID, respMsg)
-					_, _ = bot.Send(msg)
-				}
+		case "awaiting_checkpoint":
+			if !slices.Contains(mh.service.Cfg.Door.Checkpoints, text) {
+				msg := tgbotapi.NewMessage(chatID, "Неверный выбор. Выберите вход кнопкой ниже.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.checkpoints[update.Message.From.ID] = text
+			mh.userStates[chatID] = ""
+
+			msg := tgbotapi.NewMessage(chatID, presenter.Checkpoint(text))
+			_, _ = bot.Send(msg)
+			utils.ShowOptions(chatID, bot, userName, mh.cfg)
+
+		case "awaiting_zone":
+			mh.handleZoneChoice(chatID, update.Message.From.ID, bot, text)
+
+		case "awaiting_zone_ticket":
+			mh.handleZoneTicket(ctx, chatID, bot, actorFrom(update.Message.From), text)
+
+		case "awaiting_blacklist_name":
+			mh.handleBlacklistName(chatID, bot, text)
+
+		case "awaiting_blacklist_photo":
+			mh.handleBlacklistPhoto(chatID, bot, update.Message)
+
+		case "awaiting_blacklist_reason":
+			mh.handleBlacklistReason(ctx, chatID, bot, actorFrom(update.Message.From), text)
 
-				var inlineKeyboard [][]tgbotapi.InlineKeyboardButton
+		case "awaiting_id_surname":
+			var (
+				found   []models.TicketResponse
+				respMsg = presenter.NoClientsFound
+				err     error
+			)
+			if _, convErr := strconv.Atoi(text); convErr == nil {
+				var resp *models.TicketResponse
+				resp, err = mh.service.SearchById(ctx, actorFrom(update.Message.From), text)
 				if resp != nil {
-					if !resp.PassedControlZone {
-						btn := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s (ID: %s)", resp.Name, resp.Id), resp.Id)
-						inlineKeyboard = append(inlineKeyboard, tgbotapi.NewInlineKeyboardRow(btn))
-					}
-					msg := tgbotapi.NewMessage(chatID, "Выберите нужного покупателя, чтобы отметить вход:")
-					msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(inlineKeyboard...)
-					_, _ = bot.Send(msg)
-				} else {
-					lgr.Info("HandleMessages:: SearchById:: Response is nil. No clients found")
+					found = append(found, *resp)
 				}
 			} else {
-				respList, respMsg, err := mh.service.SearchBySurname(ctx, &update.Message.Text, &chatID, bot)
-				if err != nil {
-					lgr.Warn("HandleMessages:: SearchById:: Error during MarkAsEntered service method", zap.Error(err))
-					botMsg := tgbotapi.NewMessage(chatID, respMsg)
-					_, _ = bot.Send(botMsg)
-				}
-				msg := tgbotapi.NewMessage(chatID, respMsg)
-				_, _ = bot.Send(msg)
+				found, err = mh.service.SearchBySurname(ctx, actorFrom(update.Message.From), text)
+			}
+			if err != nil {
+				lgr.Warn("HandleMessages:: awaiting_id_surname:: Error during search service method", zap.Error(err))
+				respMsg = presenter.ErrorMessage(err)
+			}
 
-				var inlineKeyboard [][]tgbotapi.InlineKeyboardButton
-				for _, resp := range respList {
-					if !resp.PassedControlZone {
-						btn := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s (ID: %s)", resp.Name, resp.Id), resp.Id)
-						inlineKeyboard = append(inlineKeyboard, tgbotapi.NewInlineKeyboardRow(btn))
-					}
-				}
-				msg = tgbotapi.NewMessage(chatID, "Выберите нужного покупателя, чтобы отметить вход:")
-				msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(inlineKeyboard...)
+			if len(found) == 0 {
+				lgr.Info("HandleMessages:: awaiting_id_surname:: No clients found")
+				msg := tgbotapi.NewMessage(chatID, respMsg)
 				_, _ = bot.Send(msg)
+				return
 			}
+
+			mh.sendSearchResults(ctx, chatID, bot, found)
 		case "awaiting_client_fio":
 			if text == "" {
 				msg := tgbotapi.NewMessage(chatID, "ФИО не может быть пустым. Введите ещё раз:")
@@ -184,27 +491,50 @@ func (mh *MessagesHandler) HandleMessages(ctx context.Context, update tgbotapi.U
 			}
 			mh.clientData[chatID].FIO = formattedFio
 
-			baseButton := tgbotapi.NewKeyboardButton("Базовый")
-			vipButton := tgbotapi.NewKeyboardButton("ВИП")
-			var replyKeyboard tgbotapi.ReplyKeyboardMarkup
+			mh.checkBuyer(ctx, chatID, bot, userName)
 
-			if utils.UserInList(userName, mh.cfg.VIPSellers) {
-				replyKeyboard = tgbotapi.NewReplyKeyboard(
-					tgbotapi.NewKeyboardButtonRow(baseButton, vipButton),
-				)
-			} else {
-				replyKeyboard = tgbotapi.NewReplyKeyboard(
-					tgbotapi.NewKeyboardButtonRow(baseButton),
-				)
-			}
-			replyKeyboard.OneTimeKeyboard = true
-			replyKeyboard.ResizeKeyboard = true
+		case "awaiting_waitlist_name":
+			mh.handleWaitlistName(chatID, bot, text)
 
-			msg := tgbotapi.NewMessage(chatID, "Выберите тип билета:")
-			msg.ReplyMarkup = replyKeyboard
+		case "awaiting_waitlist_contact":
+			mh.handleWaitlistContact(ctx, chatID, bot, actorFrom(update.Message.From), update.Message)
+
+		case "awaiting_transfer_ticket_no":
+			mh.handleTransferTicketNo(ctx, chatID, bot, actorFrom(update.Message.From), text)
+
+		case "awaiting_transfer_fio":
+			mh.handleTransferFIO(ctx, chatID, bot, actorFrom(update.Message.From), text)
+
+		case "awaiting_transfer_blacklist_confirmation":
+			msg := tgbotapi.NewMessage(chatID, "Подтвердите передачу или отмените её кнопками выше.")
 			_, _ = bot.Send(msg)
 
-			mh.userStates[chatID] = "awaiting_client_ticket_type_choice"
+		case "awaiting_edit_ticket_no":
+			mh.handleEditTicketNo(ctx, chatID, bot, actorFrom(update.Message.From), text)
+
+		case "awaiting_edit_field":
+			mh.handleEditField(chatID, bot, text)
+
+		case "awaiting_edit_fio", "awaiting_edit_type", "awaiting_edit_price":
+			mh.handleEditValue(ctx, chatID, bot, actorFrom(update.Message.From), text)
+
+		case "awaiting_upgrade_ticket_no":
+			mh.handleUpgradeTicketNo(chatID, bot, text)
+
+		case "awaiting_upgrade_table":
+			mh.handleUpgradeTable(ctx, chatID, bot, text)
+
+		case "awaiting_upgrade_confirmation":
+			msg := tgbotapi.NewMessage(chatID, "Подтвердите повышение или отмените его кнопками выше.")
+			_, _ = bot.Send(msg)
+
+		case "awaiting_blacklist_confirmation":
+			msg := tgbotapi.NewMessage(chatID, "Подтвердите продажу или отмените её кнопками выше.")
+			_, _ = bot.Send(msg)
+
+		case "awaiting_namesake_confirmation":
+			msg := tgbotapi.NewMessage(chatID, "Подтвердите, что это другой человек, или отмените продажу кнопками выше.")
+			_, _ = bot.Send(msg)
 
 		case "awaiting_client_ticket_type_choice":
 			removeKeyboard := tgbotapi.NewRemoveKeyboard(true)
@@ -285,39 +615,506 @@ func (mh *MessagesHandler) HandleMessages(ctx context.Context, update tgbotapi.U
 				return
 			}
 
+			mh.clientData[chatID].RepostExists = utils.CheckRepost(text)
+
+			skipButton := tgbotapi.NewKeyboardButton("Пропустить")
+			replyKeyboard := tgbotapi.NewReplyKeyboard(
+				tgbotapi.NewKeyboardButtonRow(skipButton),
+			)
+			replyKeyboard.OneTimeKeyboard = true
+			replyKeyboard.ResizeKeyboard = true
+
+			msg := tgbotapi.NewMessage(chatID, "Введите Telegram покупателя (@username) или перешлите его контакт, чтобы он получил билет в боте:")
+			msg.ReplyMarkup = replyKeyboard
+			_, _ = bot.Send(msg)
+			mh.userStates[chatID] = "awaiting_client_telegram"
+
+		case "awaiting_client_telegram":
+			if contact := update.Message.Contact; contact != nil {
+				// A contact without a Telegram account still gives a phone to tell namesakes apart,
+				// but the buyer can't get the ticket in the bot.
+				mh.clientData[chatID].BuyerTgId = contact.UserID
+				mh.clientData[chatID].BuyerPhone, _ = utils.ParsePhone(contact.PhoneNumber)
+			} else if text != "Пропустить" {
+				buyerTag, err := utils.ParseTelegramUsername(text)
+				if err != nil {
+					msg := tgbotapi.NewMessage(chatID, "Проверьте введенный @username. Попробуйте ещё раз или нажмите «Пропустить»:")
+					_, _ = bot.Send(msg)
+					return
+				}
+				mh.clientData[chatID].BuyerTag = buyerTag
+			}
+
+			if mh.clientData[chatID].BuyerPhone != "" {
+				askOptional(chatID, bot, "Введите email покупателя")
+				mh.userStates[chatID] = "awaiting_client_email"
+				return
+			}
+
+			askOptional(chatID, bot, "Введите телефон покупателя (+375291234567), перешлите его контакт")
+			mh.userStates[chatID] = "awaiting_client_phone"
+
+		case "awaiting_client_phone":
+			if contact := update.Message.Contact; contact != nil {
+				phone, err := utils.ParsePhone(contact.PhoneNumber)
+				if err != nil {
+					msg := tgbotapi.NewMessage(chatID, "В контакте нет корректного телефона. Введите его вручную или нажмите «Пропустить»:")
+					_, _ = bot.Send(msg)
+					return
+				}
+				mh.clientData[chatID].BuyerPhone = phone
+				if mh.clientData[chatID].BuyerTgId == 0 {
+					mh.clientData[chatID].BuyerTgId = contact.UserID
+				}
+			} else if text != "Пропустить" {
+				phone, err := utils.ParsePhone(text)
+				if err != nil {
+					msg := tgbotapi.NewMessage(chatID, "Проверьте введенный телефон. Попробуйте ещё раз или нажмите «Пропустить»:")
+					_, _ = bot.Send(msg)
+					return
+				}
+				mh.clientData[chatID].BuyerPhone = phone
+			}
+
+			askOptional(chatID, bot, "Введите email покупателя")
+			mh.userStates[chatID] = "awaiting_client_email"
+
+		case "awaiting_client_email":
+			if text != "Пропустить" {
+				email, err := utils.ParseEmail(text)
+				if err != nil {
+					msg := tgbotapi.NewMessage(chatID, "Проверьте введенный email. Попробуйте ещё раз или нажмите «Пропустить»:")
+					_, _ = bot.Send(msg)
+					return
+				}
+				mh.clientData[chatID].BuyerEmail = email
+			}
+
+			askOptional(chatID, bot, "Введите дату рождения покупателя (ДД.ММ.ГГГГ)")
+			mh.userStates[chatID] = "awaiting_client_birth_date"
+
+		case "awaiting_client_birth_date":
+			if text != "Пропустить" {
+				birthDate, err := utils.ParseBirthDate(text, time.Now())
+				if err != nil {
+					msg := tgbotapi.NewMessage(chatID, "Проверьте дату рождения (ДД.ММ.ГГГГ). Попробуйте ещё раз или нажмите «Пропустить»:")
+					_, _ = bot.Send(msg)
+					return
+				}
+				mh.clientData[chatID].BuyerBirthDate = birthDate
+			}
+
+			consentKeyboard := tgbotapi.NewReplyKeyboard(
+				tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("Да"), tgbotapi.NewKeyboardButton("Нет")),
+			)
+			consentKeyboard.OneTimeKeyboard = true
+			consentKeyboard.ResizeKeyboard = true
+
+			msg := tgbotapi.NewMessage(chatID, "Покупатель согласен получать новости о следующих мероприятиях? (Да/Нет)")
+			msg.ReplyMarkup = consentKeyboard
+			_, _ = bot.Send(msg)
+			mh.userStates[chatID] = "awaiting_client_consent"
+
+		case "awaiting_client_consent":
+			switch strings.ToLower(text) {
+			case "да":
+				mh.clientData[chatID].MarketingConsent = true
+			case "нет":
+				mh.clientData[chatID].MarketingConsent = false
+			default:
+				msg := tgbotapi.NewMessage(chatID, "Ответьте «Да» или «Нет»:")
+				_, _ = bot.Send(msg)
+				return
+			}
+
 			removeKeyboard := tgbotapi.NewRemoveKeyboard(true)
 			removeMsg := tgbotapi.NewMessage(chatID, "Ответ получен.")
 			removeMsg.ReplyMarkup = removeKeyboard
 			_, _ = bot.Send(removeMsg)
 
-			mh.clientData[chatID].RepostExists = utils.CheckRepost(text)
-
 			msg := tgbotapi.NewMessage(chatID, "Операция обрабатывается...")
 			_, _ = bot.Send(msg)
 
-			respMsg, imgBuffer, ticketGenerated, err := mh.service.SellTicket(ctx, update, bot, mh.clientData[chatID])
+			result, err := mh.service.SellTicket(ctx, actorFrom(update.Message.From), *mh.clientData[chatID])
+			if errors.Is(err, errs.ErrSoldOut) {
+				mh.userStates[chatID] = ""
+				delete(mh.clientData, chatID)
+				mh.offerWaitlist(chatID, bot)
+				return
+			}
 			if err != nil {
-				msg := tgbotapi.NewMessage(chatID, respMsg)
+				lgr.Warn("HandleMessages:: SellTicket:: Error during SellTicket service method", zap.Error(err))
+				msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
 				_, _ = bot.Send(msg)
 				return
 			}
 
-			if imgBuffer != nil && ticketGenerated {
-				photoMsg := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{
-					Name:  "ticket.png",
-					Bytes: imgBuffer.Bytes(),
-				})
-				photoMsg.Caption = respMsg
-				_, _ = bot.Send(photoMsg)
-			} else {
-				msg := tgbotapi.NewMessage(chatID, "Не удалось отправить изображение")
+			sendTicket(chatID, bot, result.Image, presenter.Sale(result, bot.Self.UserName))
+
+			mh.userStates[chatID] = ""
+			delete(mh.clientData, chatID)
+
+			utils.ShowOptions(chatID, bot, userName, mh.cfg)
+
+		case "awaiting_broadcast_target":
+			targets := map[string]string{
+				broadcastTargetStaff:    models.BroadcastTargetStaff,
+				broadcastTargetCheckers: models.BroadcastTargetCheckers,
+				broadcastTargetSellers:  models.BroadcastTargetSellers,
+				broadcastTargetBuyers:   models.BroadcastTargetBuyers,
+			}
+
+			removeKeyboard := tgbotapi.NewRemoveKeyboard(true)
+			if text == broadcastTargetTicketType {
+				msg := tgbotapi.NewMessage(chatID, "Введите тип билета (например, Базовый или ВИП1):")
+				msg.ReplyMarkup = removeKeyboard
+				_, _ = bot.Send(msg)
+				mh.userStates[chatID] = "awaiting_broadcast_ticket_type"
+				return
+			}
+
+			target, ok := targets[text]
+			if !ok {
+				msg := tgbotapi.NewMessage(chatID, "Неверный выбор. Выберите получателей кнопкой ниже.")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.broadcasts[chatID].Target = target
+
+			msg := tgbotapi.NewMessage(chatID, "Введите текст рассылки:")
+			msg.ReplyMarkup = removeKeyboard
+			_, _ = bot.Send(msg)
+			mh.userStates[chatID] = "awaiting_broadcast_text"
+
+		case "awaiting_broadcast_ticket_type":
+			ticketType, ok := utils.ValidateTicketType(strings.ReplaceAll(text, " ", ""), mh.service.Cfg.SalesOption)
+			if !ok {
+				msg := tgbotapi.NewMessage(chatID, "Неверный тип билета. Попробуйте ещё раз:")
+				_, _ = bot.Send(msg)
+				return
+			}
+			mh.broadcasts[chatID].Target = models.BroadcastTargetTypePrefix + ticketType
+
+			msg := tgbotapi.NewMessage(chatID, "Введите текст рассылки:")
+			_, _ = bot.Send(msg)
+			mh.userStates[chatID] = "awaiting_broadcast_text"
+
+		case "awaiting_broadcast_text":
+			if strings.TrimSpace(text) == "" {
+				msg := tgbotapi.NewMessage(chatID, "Текст рассылки не может быть пустым. Введите ещё раз:")
 				_, _ = bot.Send(msg)
+				return
 			}
+			mh.broadcasts[chatID].Text = text
 
+			respMsg, recipientsCount, err := mh.broadcaster.PreviewBroadcast(ctx, mh.broadcasts[chatID])
+			if err != nil {
+				lgr.Warn("HandleMessages:: PreviewBroadcast:: Error during PreviewBroadcast service method", zap.Error(err))
+				msg := tgbotapi.NewMessage(chatID, respMsg)
+				_, _ = bot.Send(msg)
+				return
+			}
+
+			msg := tgbotapi.NewMessage(chatID, respMsg)
+			if recipientsCount > 0 {
+				msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+					tgbotapi.NewInlineKeyboardRow(
+						tgbotapi.NewInlineKeyboardButtonData("Отправить", broadcastConfirmData),
+						tgbotapi.NewInlineKeyboardButtonData("Отмена", broadcastCancelData),
+					),
+				)
+			}
+			_, _ = bot.Send(msg)
 			mh.userStates[chatID] = ""
-			delete(mh.clientData, chatID)
 
+		case "awaiting_reissue_ticket_no":
+			if _, err := strconv.Atoi(text); err != nil {
+				msg := tgbotapi.NewMessage(chatID, "Номер билета должен быть числом. Введите ещё раз:")
+				_, _ = bot.Send(msg)
+				return
+			}
+
+			issued, err := mh.service.ReissueTicket(ctx, actorFrom(update.Message.From), text)
+			if err != nil {
+				lgr.Warn("HandleMessages:: ReissueTicket:: Error during ReissueTicket service method", zap.Error(err))
+				msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+				_, _ = bot.Send(msg)
+				return
+			}
+
+			sendTicket(chatID, bot, issued.Image, presenter.Reissued(issued))
+
+			mh.userStates[chatID] = ""
 			utils.ShowOptions(chatID, bot, userName, mh.cfg)
 		}
 	}
 }
+
+// askOptional asks for an optional sale field, which the seller can skip with «Пропустить».
+func askOptional(chatID int64, bot *tgbotapi.BotAPI, question string) {
+	skipKeyboard := tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("Пропустить")),
+	)
+	skipKeyboard.OneTimeKeyboard = true
+	skipKeyboard.ResizeKeyboard = true
+
+	msg := tgbotapi.NewMessage(chatID, question+" или нажмите «Пропустить»:")
+	msg.ReplyMarkup = skipKeyboard
+	_, _ = bot.Send(msg)
+}
+
+// checkBuyer stops the sale to a buyer matching the blacklist and goes on to the namesakes check otherwise.
+func (mh *MessagesHandler) checkBuyer(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, userName string) {
+	blacklisted, err := mh.service.BlacklistMatches(ctx, mh.clientData[chatID].FIO)
+	if err != nil {
+		logger.New(ctx).Warn("HandleMessages:: BlacklistMatches:: Error during BlacklistMatches service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+	if len(blacklisted) > 0 {
+		mh.askBlacklistOverride(chatID, bot, userName, blacklisted)
+		return
+	}
+
+	mh.checkNamesakes(ctx, chatID, bot, userName)
+}
+
+// checkNamesakes asks the seller to confirm the buyer is a different person if a ticket
+// has already been sold for the same name, and goes on to the ticket type otherwise.
+func (mh *MessagesHandler) checkNamesakes(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, userName string) {
+	namesakes, err := mh.service.FindNamesakes(ctx, mh.clientData[chatID].FIO)
+	if err != nil {
+		logger.New(ctx).Warn("HandleMessages:: FindNamesakes:: Error during FindNamesakes service method", zap.Error(err))
+	}
+	if len(namesakes) > 0 {
+		msg := tgbotapi.NewMessage(chatID, presenter.Namesakes(namesakes))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Это другой человек", namesakeConfirmData),
+				tgbotapi.NewInlineKeyboardButtonData("Отмена", namesakeCancelData),
+			),
+		)
+		_, _ = bot.Send(msg)
+		mh.userStates[chatID] = "awaiting_namesake_confirmation"
+		return
+	}
+
+	mh.askTicketType(chatID, bot, userName)
+}
+
+func (mh *MessagesHandler) askTicketType(chatID int64, bot *tgbotapi.BotAPI, userName string) {
+	baseButton := tgbotapi.NewKeyboardButton("Базовый")
+	vipButton := tgbotapi.NewKeyboardButton("ВИП")
+	var replyKeyboard tgbotapi.ReplyKeyboardMarkup
+
+	if utils.UserInList(userName, mh.cfg.VIPSellers) {
+		replyKeyboard = tgbotapi.NewReplyKeyboard(
+			tgbotapi.NewKeyboardButtonRow(baseButton, vipButton),
+		)
+	} else {
+		replyKeyboard = tgbotapi.NewReplyKeyboard(
+			tgbotapi.NewKeyboardButtonRow(baseButton),
+		)
+	}
+	replyKeyboard.OneTimeKeyboard = true
+	replyKeyboard.ResizeKeyboard = true
+
+	msg := tgbotapi.NewMessage(chatID, "Выберите тип билета:")
+	msg.ReplyMarkup = replyKeyboard
+	_, _ = bot.Send(msg)
+
+	mh.userStates[chatID] = "awaiting_client_ticket_type_choice"
+}
+
+func (mh *MessagesHandler) askCheckpoint(chatID int64, bot *tgbotapi.BotAPI) {
+	mh.userStates[chatID] = "awaiting_checkpoint"
+
+	var rows [][]tgbotapi.KeyboardButton
+	for _, checkpoint := range mh.service.Cfg.Door.Checkpoints {
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(checkpoint)))
+	}
+	keyboard := tgbotapi.NewReplyKeyboard(rows...)
+	keyboard.OneTimeKeyboard = true
+	keyboard.ResizeKeyboard = true
+
+	msg := tgbotapi.NewMessage(chatID, "Выберите вход, на котором Вы работаете:")
+	msg.ReplyMarkup = keyboard
+	_, _ = bot.Send(msg)
+}
+
+// ticketCard is the door card of the ticket for the checker, warning them when the guest
+// has to use another checkpoint.
+func (mh *MessagesHandler) ticketCard(ticket *models.TicketResponse, checkerID int64) string {
+	card := presenter.ResponseMapper(ticket, mh.service.Cfg.LacesColor, mh.service.Cfg.Event.MinAge)
+	if warning := presenter.CheckpointWarning(ticket, mh.checkpoints[checkerID], mh.service.Cfg.Door); warning != "" {
+		card = warning + "\n\n" + card
+	}
+
+	return card
+}
+
+// allowedAtDoor checks that the user pressing an entry or exit button under a search result is a
+// checker, like the inline callbacks do: the buttons stay usable in a forwarded message.
+func (mh *MessagesHandler) allowedAtDoor(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) bool {
+	if utils.UserInList(callback.From.UserName, mh.cfg.AllowedCheckers) {
+		return true
+	}
+
+	logger.New(ctx).Info("Unauthorized user trying to mark entrance or exit")
+	_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, "У Вас нет прав для отметки входа и выхода."))
+	return false
+}
+
+// askIDCheck shows the age warning and lets the checker confirm they have seen the guest's ID.
+func (mh *MessagesHandler) askIDCheck(chatID int64, bot *tgbotapi.BotAPI, resp *models.TicketResponse) {
+	msg := tgbotapi.NewMessage(chatID, presenter.IDCheckPrompt(resp, mh.service.Cfg.Event.MinAge))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Документ проверен", idCheckConfirmPrefix+resp.Id),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", idCheckCancelPrefix+resp.Id),
+		),
+	)
+	_, _ = bot.Send(msg)
+}
+
+func (mh *MessagesHandler) handleNamesakeCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI, data string) {
+	chatID := callback.Message.Chat.ID
+	if mh.userStates[chatID] != "awaiting_namesake_confirmation" {
+		return
+	}
+
+	removeInlineKeyboard(ctx, bot, chatID, callback.Message.MessageID)
+
+	if data == namesakeCancelData {
+		mh.userStates[chatID] = ""
+		delete(mh.clientData, chatID)
+
+		msg := tgbotapi.NewMessage(chatID, "Продажа отменена.")
+		_, _ = bot.Send(msg)
+		utils.ShowOptions(chatID, bot, callback.From.UserName, mh.cfg)
+		return
+	}
+
+	mh.clientData[chatID].NamesakeConfirmed = true
+	mh.askTicketType(chatID, bot, callback.From.UserName)
+}
+
+// sendAudit shows an admin the audit log of the ticket: who did what to it and when.
+func (mh *MessagesHandler) sendAudit(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, userName, ticketNo string) {
+	lgr := logger.New(ctx)
+
+	if !utils.UserInList(userName, mh.cfg.Admins) {
+		lgr.Info("Unauthorized user trying to use bot")
+		msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для просмотра истории билетов.")
+		_, _ = bot.Send(msg)
+		return
+	}
+	if ticketNo == "" {
+		msg := tgbotapi.NewMessage(chatID, "Укажите номер билета: /audit 42")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	records, err := mh.service.TicketAudit(ctx, ticketNo)
+	if err != nil {
+		lgr.Warn("HandleMessages:: TicketAudit:: Error during TicketAudit service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, presenter.AuditLog(ticketNo, records))
+	_, _ = bot.Send(msg)
+}
+
+func (mh *MessagesHandler) sendLinkedTickets(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+
+	tickets, err := mh.service.MyTickets(ctx, chatID)
+	if err != nil {
+		lgr.Warn("HandleMessages:: MyTickets:: Error during MyTickets service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+	if len(tickets) == 0 {
+		msg := tgbotapi.NewMessage(chatID, presenter.NoLinkedTickets)
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	for _, ticket := range tickets {
+		image, err := mh.service.TicketImage(ctx, ticket.Id)
+		if err != nil {
+			lgr.Warn("HandleMessages:: TicketImage:: Failed to generate ticket image", zap.Error(err))
+		}
+
+		sendTicket(chatID, bot, image, presenter.BuyerResponseMapper(&ticket, mh.service.Cfg.LacesColor))
+	}
+}
+
+// sendTicket sends the ticket image with the caption, or only the caption if there is no image.
+func sendTicket(chatID int64, bot *tgbotapi.BotAPI, image []byte, caption string) {
+	if image == nil {
+		msg := tgbotapi.NewMessage(chatID, caption)
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	photoMsg := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{
+		Name:  "ticket.png",
+		Bytes: image,
+	})
+	photoMsg.Caption = caption
+	_, _ = bot.Send(photoMsg)
+}
+
+// removeInlineKeyboard takes the buttons off a message once one of them is used. Telegram rejects
+// "inline_keyboard": null, which an InlineKeyboardMarkup without rows encodes to, so the rows are an empty slice.
+func removeInlineKeyboard(ctx context.Context, bot *tgbotapi.BotAPI, chatID int64, messageID int) {
+	markup := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	_, err := bot.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, markup))
+	if err != nil {
+		logger.New(ctx).Warn("HandleMessages:: Can't remove inline keyboard", zap.Error(err))
+	}
+}
+
+func actorFrom(user *tgbotapi.User) models.Actor {
+	return models.Actor{ID: user.ID, UserName: user.UserName}
+}
+
+func (mh *MessagesHandler) handleBroadcastCallback(ctx context.Context, update tgbotapi.Update, bot *tgbotapi.BotAPI, chatID int64, data string) {
+	lgr := logger.New(ctx)
+
+	if !utils.UserInList(update.CallbackQuery.From.UserName, mh.cfg.Admins) {
+		lgr.Info("Unauthorized user trying to use bot")
+		msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для рассылки сообщений.")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	draft, ok := mh.broadcasts[chatID]
+	delete(mh.broadcasts, chatID)
+
+	removeInlineKeyboard(ctx, bot, chatID, update.CallbackQuery.Message.MessageID)
+
+	if data == broadcastCancelData {
+		msg := tgbotapi.NewMessage(chatID, "Рассылка отменена.")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	if !ok {
+		msg := tgbotapi.NewMessage(chatID, "Черновик рассылки не найден. Начните заново с /broadcast")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	respMsg, err := mh.broadcaster.StartBroadcast(ctx, draft)
+	if err != nil {
+		lgr.Warn("HandleMessages:: StartBroadcast:: Error during StartBroadcast service method", zap.Error(err))
+	}
+	msg := tgbotapi.NewMessage(chatID, respMsg)
+	_, _ = bot.Send(msg)
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	"github.com/qRe0/afterparty-bot/internal/fakebot"
+	ticket_repository "github.com/qRe0/afterparty-bot/internal/repository"
+	ticket_service "github.com/qRe0/afterparty-bot/internal/service"
+)
+
+const replyTimeout = 5 * time.Second
+
+var (
+	seller  = tgbotapi.User{ID: 10, UserName: "seller"}
+	checker = tgbotapi.User{ID: 20, UserName: "checker"}
+)
+
+// startBot runs the handler in demo mode against the fake Bot API, one update at a time like a single chat would see them.
+func startBot(t *testing.T) *fakebot.Server {
+	t.Helper()
+
+	srv := fakebot.New()
+	bot, err := srv.Bot()
+	if err != nil {
+		t.Fatalf("fakebot.Bot() error = %v", err)
+	}
+
+	cfg := configs.Config{
+		SalesOption: configs.SalesOptions{Prices: []int{15, 17, 20, 22, 50}, Dates: []string{"2099-01-01"}},
+		AllowList: configs.AllowList{
+			AllowedSellers:  map[string]bool{seller.UserName: true},
+			AllowedCheckers: map[string]bool{checker.UserName: true},
+		},
+		Search: configs.SearchOptions{ResultsLimit: 20, PageSize: 5, BlacklistMinScore: 0.5},
+		Demo:   configs.DemoOptions{Enabled: true},
+	}
+	repo := ticket_repository.NewMemory()
+	service := ticket_service.NewAudited(ticket_service.New(repo, cfg), repo)
+	handler := New(service, ticket_service.NewBroadcastService(repo, cfg), cfg.AllowList)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := bot.GetUpdatesChan(tgbotapi.NewUpdate(0))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for update := range updates {
+			handler.HandleMessages(ctx, update, bot)
+		}
+	}()
+
+	t.Cleanup(func() {
+		bot.StopReceivingUpdates()
+		<-done
+		cancel()
+		srv.Close()
+	})
+
+	return srv
+}
+
+// conversation is one staff member's chat with the bot. It remembers which of the bot's calls it
+// has already read, so every step waits for a reply to that step.
+type conversation struct {
+	t    *testing.T
+	srv  *fakebot.Server
+	user tgbotapi.User
+	read int
+}
+
+func (c *conversation) say(text, wantReply string) fakebot.Request {
+	c.t.Helper()
+	c.srv.SendText(c.user, text)
+	return c.expect(wantReply)
+}
+
+func (c *conversation) press(msg fakebot.Request, button, wantReply string) fakebot.Request {
+	c.t.Helper()
+	data, ok := msg.CallbackData(button)
+	if !ok {
+		c.t.Fatalf("no %q button under %q, buttons: %v", button, msg.Text(), msg.Buttons())
+	}
+	c.srv.PressButton(c.user, msg.MessageID, data)
+	return c.expect(wantReply)
+}
+
+// expect waits for a call to the user's chat whose text contains want.
+func (c *conversation) expect(want string) fakebot.Request {
+	c.t.Helper()
+
+	for n := c.read + 1; ; {
+		requests, err := c.srv.WaitFor("", n, replyTimeout)
+		for i := c.read; i < len(requests); i++ {
+			if requests[i].ChatID() == c.user.ID && strings.Contains(requests[i].Text(), want) {
+				c.read = i + 1
+				return requests[i]
+			}
+		}
+		if err != nil {
+			var texts []string
+			for _, req := range requests[c.read:] {
+				texts = append(texts, req.Method+": "+req.Text())
+			}
+			c.t.Fatalf("no reply containing %q, got %q", want, texts)
+		}
+		n = len(requests) + 1
+	}
+}
+
+func (c *conversation) sell(fio string) fakebot.Request {
+	c.t.Helper()
+	c.say("Продать билет", "Введите ФИО покупателя")
+	c.say(fio, "Выберите тип билета")
+	c.say("Базовый", "Введите стоимость билета")
+	c.say("17", "Укажите наличие репоста")
+	c.say("Нет", "Введите Telegram покупателя")
+	c.say("Пропустить", "Введите телефон покупателя")
+	c.say("Пропустить", "Введите email покупателя")
+	c.say("Пропустить", "Введите дату рождения покупателя")
+	c.say("Пропустить", "Покупатель согласен")
+	return c.say("Нет", "Билет успешно продан!")
+}
+
+func TestSellSearchCheckIn(t *testing.T) {
+	srv := startBot(t)
+	sales := &conversation{t: t, srv: srv, user: seller}
+	door := &conversation{t: t, srv: srv, user: checker}
+
+	sold := sales.sell("Иванов Иван")
+	if !strings.Contains(sold.Text(), "Номер билета: 1") {
+		t.Fatalf("sale reply = %q, want ticket 1", sold.Text())
+	}
+
+	door.say("Отметить вход", "Введите фамилию, имя или номер билета")
+	results := door.say("иванов", "Найдено покупателей: 1")
+	edited := door.press(results, "Впустить: Иванов Иван (ID: 1)", "Найдено покупателей: 1")
+	if edited.Method != "editMessageText" || edited.MessageID != results.MessageID {
+		t.Fatalf("search results updated with %s of message %d, want editMessageText of %d", edited.Method, edited.MessageID, results.MessageID)
+	}
+	if _, ok := edited.CallbackData("Выход: Иванов Иван (ID: 1)"); !ok {
+		t.Fatalf("updated search results buttons = %v, want the exit button", edited.Buttons())
+	}
+	door.expect("Иванов Иван прошел контроль (ID: 1)")
+
+	door.say("Отметить вход", "Введите фамилию, имя или номер билета")
+	again := door.say("1", "Найдено покупателей: 1")
+	if _, ok := again.CallbackData("Выход: Иванов Иван (ID: 1)"); !ok {
+		t.Fatalf("search by number buttons = %v, want the guest inside", again.Buttons())
+	}
+}
+
+func TestSellNamesake(t *testing.T) {
+	srv := startBot(t)
+	sales := &conversation{t: t, srv: srv, user: seller}
+
+	sales.sell("Петров Пётр")
+
+	sales.say("Продать билет", "Введите ФИО покупателя")
+	warning := sales.say("Петров Пётр", "Билет на такое ФИО уже продан")
+	sales.press(warning, "Это другой человек", "Выберите тип билета")
+
+	var removed bool
+	for _, req := range srv.Requests("editMessageReplyMarkup") {
+		if req.MessageID != warning.MessageID {
+			continue
+		}
+		if markup := req.Params.Get("reply_markup"); markup != `{"inline_keyboard":[]}` {
+			t.Fatalf("namesake buttons removed with %s, want an empty row list", markup)
+		}
+		removed = true
+	}
+	if !removed {
+		t.Fatal("namesake buttons were not removed")
+	}
+}
+
+func TestUnknownStaffCannotSell(t *testing.T) {
+	srv := startBot(t)
+	stranger := &conversation{t: t, srv: srv, user: tgbotapi.User{ID: 30, UserName: "stranger"}}
+
+	stranger.say("Продать билет", "У Вас нет прав для продажи билетов.")
+}
+
+func TestOnlyCheckersPressDoorButtons(t *testing.T) {
+	srv := startBot(t)
+	sales := &conversation{t: t, srv: srv, user: seller}
+	door := &conversation{t: t, srv: srv, user: checker}
+
+	sales.sell("Иванов Иван")
+	door.say("Отметить вход", "Введите фамилию, имя или номер билета")
+	results := door.say("иванов", "Найдено покупателей: 1")
+	data, ok := results.CallbackData("Впустить: Иванов Иван (ID: 1)")
+	if !ok {
+		t.Fatalf("search results buttons = %v, want the enter button", results.Buttons())
+	}
+
+	// The seller got the results forwarded and presses the button.
+	srv.PressButton(seller, results.MessageID, data)
+	answers, err := srv.WaitFor("answerCallbackQuery", 1, replyTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := answers[0].Text(); !strings.Contains(text, "нет прав") {
+		t.Fatalf("callback answered with %q, want a refusal", text)
+	}
+
+	door.say("Отметить вход", "Введите фамилию, имя или номер билета")
+	again := door.say("1", "Найдено покупателей: 1")
+	if _, ok := again.CallbackData("Впустить: Иванов Иван (ID: 1)"); !ok {
+		t.Fatalf("search buttons after the refused press = %v, want the guest still outside", again.Buttons())
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	searchPagePrefix = "search_page_"
+	searchNoopData   = "search_noop"
+	searchExitPrefix = "exit_"
+)
+
+// searchSession keeps the ranked results of the checker's last search,
+// so the single result message can be paged and refreshed in place.
+type searchSession struct {
+	tickets   []models.TicketResponse
+	page      int
+	messageID int
+	checkerID int64
+}
+
+func (mh *MessagesHandler) sendSearchResults(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, tickets []models.TicketResponse) {
+	lgr := logger.New(ctx)
+
+	var blacklisted []models.BlacklistEntry
+	for _, ticket := range tickets {
+		for _, entry := range ticket.Blacklist {
+			if !slices.ContainsFunc(blacklisted, func(b models.BlacklistEntry) bool { return b.Id == entry.Id }) {
+				blacklisted = append(blacklisted, entry)
+			}
+		}
+	}
+	mh.sendBlacklistPhotos(chatID, bot, blacklisted)
+
+	session := &searchSession{tickets: tickets, checkerID: chatID}
+	text, keyboard := mh.renderSearchPage(session)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	sent, err := bot.Send(msg)
+	if err != nil {
+		lgr.Warn("HandleMessages:: sendSearchResults:: Failed to send search results", zap.Error(err))
+		return
+	}
+
+	session.messageID = sent.MessageID
+	mh.searches[chatID] = session
+}
+
+func (mh *MessagesHandler) handleSearchPageCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	chatID := callback.Message.Chat.ID
+
+	session, ok := mh.searches[chatID]
+	if !ok || session.messageID != callback.Message.MessageID {
+		_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, "Результаты поиска устарели. Выполните поиск заново."))
+		return
+	}
+
+	page, err := strconv.Atoi(strings.TrimPrefix(callback.Data, searchPagePrefix))
+	if err != nil {
+		_, _ = bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	session.page = page
+
+	mh.editSearchMessage(ctx, chatID, bot, session)
+	_, _ = bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+}
+
+// updateSearchResult sets the entry status of the ticket in the cached results
+// and redraws the result message, so the checker sees the change without a new search.
+func (mh *MessagesHandler) updateSearchResult(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, resp *models.TicketResponse) {
+	session, ok := mh.searches[chatID]
+	if !ok {
+		return
+	}
+
+	for i := range session.tickets {
+		if session.tickets[i].Id == resp.Id {
+			session.tickets[i].PassedControlZone = resp.PassedControlZone
+		}
+	}
+
+	mh.editSearchMessage(ctx, chatID, bot, session)
+}
+
+func (mh *MessagesHandler) editSearchMessage(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, session *searchSession) {
+	lgr := logger.New(ctx)
+
+	text, keyboard := mh.renderSearchPage(session)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, session.messageID, text, keyboard)
+	_, err := bot.Request(edit)
+	if err != nil {
+		lgr.Warn("HandleMessages:: editSearchMessage:: Failed to edit search results", zap.Error(err))
+	}
+}
+
+func (mh *MessagesHandler) renderSearchPage(session *searchSession) (string, tgbotapi.InlineKeyboardMarkup) {
+	pageSize := mh.service.Cfg.Search.PageSize
+	if pageSize <= 0 {
+		pageSize = len(session.tickets)
+	}
+
+	pages := (len(session.tickets) + pageSize - 1) / pageSize
+	if session.page >= pages {
+		session.page = pages - 1
+	}
+	if session.page < 0 {
+		session.page = 0
+	}
+
+	from := session.page * pageSize
+	to := min(from+pageSize, len(session.tickets))
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("Найдено покупателей: %d (страница %d из %d)\n\n", len(session.tickets), session.page+1, pages))
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, ticket := range session.tickets[from:to] {
+		text.WriteString(mh.ticketCard(&ticket, session.checkerID) + "\n\n")
+
+		var btn tgbotapi.InlineKeyboardButton
+		if ticket.PassedControlZone {
+			btn = tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Выход: %s (ID: %s)", ticket.Name, ticket.Id), searchExitPrefix+ticket.Id)
+		} else {
+			btn = tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Впустить: %s (ID: %s)", ticket.Name, ticket.Id), ticket.Id)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(btn))
+	}
+
+	if pages > 1 {
+		var nav []tgbotapi.InlineKeyboardButton
+		if session.page > 0 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀", searchPagePrefix+strconv.Itoa(session.page-1)))
+		}
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", session.page+1, pages), searchNoopData))
+		if session.page < pages-1 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("▶", searchPagePrefix+strconv.Itoa(session.page+1)))
+		}
+		rows = append(rows, nav)
+	}
+
+	return strings.TrimSpace(text.String()), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
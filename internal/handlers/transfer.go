@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	transferBlacklistConfirmData = "transfer_blacklist_confirm"
+	transferBlacklistCancelData  = "transfer_blacklist_cancel"
+	transferReissuePrefix        = "transfer_reissue_"
+)
+
+func (mh *MessagesHandler) canTransfer(userName string) bool {
+	return utils.UserInList(userName, mh.cfg.AllowedSellers) || utils.UserInList(userName, mh.cfg.Admins)
+}
+
+func (mh *MessagesHandler) handleTransferTicketNo(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, staff models.Actor, text string) {
+	lgr := logger.New(ctx)
+
+	if _, err := strconv.Atoi(text); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Номер билета должен быть числом. Введите ещё раз:")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	ticket, err := mh.service.SearchById(ctx, staff, text)
+	if err != nil {
+		lgr.Warn("HandleMessages:: SearchById:: Error during SearchById service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	mh.transfers[chatID] = &models.TicketTransfer{TicketId: ticket.Id}
+	mh.userStates[chatID] = "awaiting_transfer_fio"
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Билет №%s (%s), владелец: %s.\n\nВведите ФИО нового владельца:", ticket.Id, ticket.TicketType, ticket.Name))
+	_, _ = bot.Send(msg)
+}
+
+// handleTransferFIO stops the transfer to a person matching the blacklist. Only an admin may go on with it.
+func (mh *MessagesHandler) handleTransferFIO(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, staff models.Actor, text string) {
+	lgr := logger.New(ctx)
+
+	formattedFio, err := utils.FormatFIO(text)
+	if err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Проверьте введенное ФИО")
+		_, _ = bot.Send(msg)
+		return
+	}
+	mh.transfers[chatID].FullName = formattedFio
+
+	blacklisted, err := mh.service.BlacklistMatches(ctx, formattedFio)
+	if err != nil {
+		lgr.Warn("HandleMessages:: BlacklistMatches:: Error during BlacklistMatches service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+	if len(blacklisted) == 0 {
+		mh.transferTicket(ctx, chatID, bot, staff)
+		return
+	}
+
+	mh.sendBlacklistPhotos(chatID, bot, blacklisted)
+
+	if !utils.UserInList(staff.UserName, mh.cfg.Admins) {
+		mh.userStates[chatID] = ""
+		delete(mh.transfers, chatID)
+
+		msg := tgbotapi.NewMessage(chatID, presenter.BlacklistWarning(blacklisted)+"\n\nПередача остановлена. Обратитесь к администратору.")
+		_, _ = bot.Send(msg)
+		utils.ShowOptions(chatID, bot, staff.UserName, mh.cfg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, presenter.BlacklistWarning(blacklisted))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Передать под мою ответственность", transferBlacklistConfirmData),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", transferBlacklistCancelData),
+		),
+	)
+	_, _ = bot.Send(msg)
+	mh.userStates[chatID] = "awaiting_transfer_blacklist_confirmation"
+}
+
+func (mh *MessagesHandler) handleTransferBlacklistCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	chatID := callback.Message.Chat.ID
+	if mh.userStates[chatID] != "awaiting_transfer_blacklist_confirmation" {
+		return
+	}
+
+	removeInlineKeyboard(ctx, bot, chatID, callback.Message.MessageID)
+
+	if callback.Data == transferBlacklistCancelData || !utils.UserInList(callback.From.UserName, mh.cfg.Admins) {
+		mh.userStates[chatID] = ""
+		delete(mh.transfers, chatID)
+
+		msg := tgbotapi.NewMessage(chatID, "Передача отменена.")
+		_, _ = bot.Send(msg)
+		utils.ShowOptions(chatID, bot, callback.From.UserName, mh.cfg)
+		return
+	}
+
+	mh.transfers[chatID].BlacklistOverride = true
+	mh.transferTicket(ctx, chatID, bot, actorFrom(callback.From))
+}
+
+// transferTicket passes the ticket to the new holder and offers to re-issue its image for them.
+func (mh *MessagesHandler) transferTicket(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, staff models.Actor) {
+	lgr := logger.New(ctx)
+
+	transferred, err := mh.service.TransferTicket(ctx, staff, *mh.transfers[chatID])
+	if err != nil {
+		lgr.Warn("HandleMessages:: TransferTicket:: Error during TransferTicket service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	mh.userStates[chatID] = ""
+	delete(mh.transfers, chatID)
+
+	msg := tgbotapi.NewMessage(chatID, presenter.Transferred(transferred))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Перевыпустить билет", transferReissuePrefix+transferred.TicketId),
+		),
+	)
+	_, _ = bot.Send(msg)
+	utils.ShowOptions(chatID, bot, staff.UserName, mh.cfg)
+}
+
+func (mh *MessagesHandler) handleTransferReissueCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+	chatID := callback.Message.Chat.ID
+
+	if !mh.canTransfer(callback.From.UserName) {
+		lgr.Info("Unauthorized user trying to use bot")
+		return
+	}
+
+	removeInlineKeyboard(ctx, bot, chatID, callback.Message.MessageID)
+
+	issued, err := mh.service.ReissueTicket(ctx, actorFrom(callback.From), strings.TrimPrefix(callback.Data, transferReissuePrefix))
+	if err != nil {
+		lgr.Warn("HandleMessages:: ReissueTicket:: Error during ReissueTicket service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	sendTicket(chatID, bot, issued.Image, presenter.Reissued(issued))
+}
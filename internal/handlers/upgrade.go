@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	upgradeConfirmData = "upgrade_confirm"
+	upgradeCancelData  = "upgrade_cancel"
+)
+
+func (mh *MessagesHandler) handleUpgradeTicketNo(chatID int64, bot *tgbotapi.BotAPI, text string) {
+	if _, err := strconv.Atoi(text); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Номер билета должен быть числом. Введите ещё раз:")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	mh.upgrades[chatID] = &models.TicketUpgrade{TicketId: text}
+	mh.userStates[chatID] = "awaiting_upgrade_table"
+
+	msg := tgbotapi.NewMessage(chatID, "Введите номер ВИП-стола:")
+	_, _ = bot.Send(msg)
+}
+
+// handleUpgradeTable shows the surcharge for the chosen table and asks the seller to confirm it.
+func (mh *MessagesHandler) handleUpgradeTable(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, text string) {
+	lgr := logger.New(ctx)
+
+	upgrade := mh.upgrades[chatID]
+	upgrade.TicketType = "ВИП" + text
+
+	quote, err := mh.service.UpgradeQuote(ctx, *upgrade)
+	if errors.Is(err, errs.ErrCheckingBaseParameters) {
+		msg := tgbotapi.NewMessage(chatID, "Неверный номер стола. Попробуйте ещё раз:")
+		_, _ = bot.Send(msg)
+		return
+	}
+	if err != nil {
+		lgr.Warn("HandleMessages:: UpgradeQuote:: Error during UpgradeQuote service method", zap.Error(err))
+		mh.userStates[chatID] = ""
+		delete(mh.upgrades, chatID)
+
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, presenter.UpgradeQuote(quote, mh.service.Cfg.LacesColor))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Доплата получена", upgradeConfirmData),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", upgradeCancelData),
+		),
+	)
+	_, _ = bot.Send(msg)
+	mh.userStates[chatID] = "awaiting_upgrade_confirmation"
+}
+
+// handleUpgradeCallback upgrades the ticket once the seller has taken the surcharge. The surcharge
+// is computed again, so it is what the seller is credited with even if prices changed meanwhile.
+func (mh *MessagesHandler) handleUpgradeCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+	chatID := callback.Message.Chat.ID
+	if mh.userStates[chatID] != "awaiting_upgrade_confirmation" {
+		return
+	}
+
+	removeInlineKeyboard(ctx, bot, chatID, callback.Message.MessageID)
+
+	upgrade := *mh.upgrades[chatID]
+	mh.userStates[chatID] = ""
+	delete(mh.upgrades, chatID)
+
+	if callback.Data == upgradeCancelData || !utils.CanUpgradeToVIP(callback.From.UserName, mh.cfg) {
+		msg := tgbotapi.NewMessage(chatID, "Повышение отменено.")
+		_, _ = bot.Send(msg)
+		utils.ShowOptions(chatID, bot, callback.From.UserName, mh.cfg)
+		return
+	}
+
+	respMsg := ""
+	upgraded, err := mh.service.UpgradeTicket(ctx, actorFrom(callback.From), upgrade)
+	if err != nil {
+		lgr.Warn("HandleMessages:: UpgradeTicket:: Error during UpgradeTicket service method", zap.Error(err))
+		respMsg = presenter.ErrorMessage(err)
+	} else {
+		respMsg = presenter.Upgraded(upgraded, mh.service.Cfg.LacesColor)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, respMsg)
+	_, _ = bot.Send(msg)
+	utils.ShowOptions(chatID, bot, callback.From.UserName, mh.cfg)
+}
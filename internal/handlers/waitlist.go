@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	waitlistAddData       = "waitlist_add"
+	waitlistRemovePrefix  = "waitlist_remove_"
+	waitlistSellPrefix    = "waitlist_sell_"
+	waitlistDeclinePrefix = "waitlist_decline_"
+
+	// waitlistCheckInterval is how often held offers are checked for expiry. Refunds and
+	// declined offers advance the waitlist right away.
+	waitlistCheckInterval = time.Minute
+)
+
+// RunWaitlist offers freed spots to the waitlist and moves expired offers on to the next person,
+// telling the sellers who added them. It also runs once at start, which picks up a raised EVENT_CAPACITY.
+func (mh *MessagesHandler) RunWaitlist(ctx context.Context, bot *tgbotapi.BotAPI) {
+	ticker := time.NewTicker(waitlistCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		mh.advanceWaitlist(ctx, bot)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-mh.service.WaitlistWake():
+		}
+	}
+}
+
+func (mh *MessagesHandler) advanceWaitlist(ctx context.Context, bot *tgbotapi.BotAPI) {
+	offered, expired, err := mh.service.AdvanceWaitlist(ctx)
+	if err != nil {
+		logger.New(ctx).Warn("HandleMessages:: AdvanceWaitlist:: Error during AdvanceWaitlist service method", zap.Error(err))
+	}
+
+	for _, entry := range expired {
+		msg := tgbotapi.NewMessage(entry.AddedByChatId, presenter.WaitlistOfferExpired(entry))
+		_, _ = bot.Send(msg)
+	}
+
+	for _, entry := range offered {
+		id := strconv.FormatInt(entry.Id, 10)
+		msg := tgbotapi.NewMessage(entry.AddedByChatId, presenter.WaitlistOffer(entry))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Продать билет", waitlistSellPrefix+id),
+				tgbotapi.NewInlineKeyboardButtonData("Отказался", waitlistDeclinePrefix+id),
+			),
+		)
+		_, _ = bot.Send(msg)
+	}
+}
+
+// sendWaitlist shows the waitlist in order with a button to add a person. Admins may remove entries.
+func (mh *MessagesHandler) sendWaitlist(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, userName string) {
+	lgr := logger.New(ctx)
+
+	entries, err := mh.service.Waitlist(ctx)
+	if err != nil {
+		lgr.Warn("HandleMessages:: Waitlist:: Error during Waitlist service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if utils.UserInList(userName, mh.cfg.Admins) {
+		for _, entry := range entries {
+			btn := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Убрать: %s", entry.FullName), waitlistRemovePrefix+strconv.FormatInt(entry.Id, 10))
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(btn))
+		}
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Добавить", waitlistAddData)))
+
+	msg := tgbotapi.NewMessage(chatID, presenter.Waitlist(entries))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	_, _ = bot.Send(msg)
+}
+
+// offerWaitlist tells the seller the event is sold out and offers to add the buyer to the waitlist instead.
+func (mh *MessagesHandler) offerWaitlist(chatID int64, bot *tgbotapi.BotAPI) {
+	msg := tgbotapi.NewMessage(chatID, "Билеты распроданы. Покупателя можно добавить в лист ожидания — когда место освободится, Вам придёт уведомление.")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Добавить в лист ожидания", waitlistAddData)),
+	)
+	_, _ = bot.Send(msg)
+}
+
+func (mh *MessagesHandler) handleWaitlistCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+	chatID := callback.Message.Chat.ID
+	userName := callback.From.UserName
+
+	if callback.Data == waitlistAddData {
+		if !utils.UserInList(userName, mh.cfg.AllowedSellers) && !utils.UserInList(userName, mh.cfg.Admins) {
+			lgr.Info("Unauthorized user trying to manage waitlist")
+			_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, "У Вас нет прав для работы с листом ожидания."))
+			return
+		}
+		_, _ = bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+
+		mh.waitlists[chatID] = &models.WaitlistEntry{}
+		mh.userStates[chatID] = "awaiting_waitlist_name"
+		msg := tgbotapi.NewMessage(chatID, "Введите ФИО человека, которого нужно добавить в лист ожидания:")
+		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	if !utils.UserInList(userName, mh.cfg.Admins) {
+		lgr.Info("Unauthorized user trying to manage waitlist")
+		_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, "Убирать из листа ожидания может только администратор."))
+		return
+	}
+	_, _ = bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, waitlistRemovePrefix), 10, 64)
+	if err != nil {
+		return
+	}
+
+	respMsg := "Запись убрана из листа ожидания."
+	err = mh.service.RemoveFromWaitlist(ctx, actorFrom(callback.From), id)
+	if err != nil {
+		lgr.Warn("HandleMessages:: RemoveFromWaitlist:: Error during RemoveFromWaitlist service method", zap.Error(err))
+		respMsg = presenter.ErrorMessage(err)
+	}
+	msg := tgbotapi.NewMessage(chatID, respMsg)
+	_, _ = bot.Send(msg)
+}
+
+func (mh *MessagesHandler) handleWaitlistName(chatID int64, bot *tgbotapi.BotAPI, text string) {
+	if strings.TrimSpace(text) == "" {
+		msg := tgbotapi.NewMessage(chatID, "ФИО не может быть пустым. Введите ещё раз:")
+		_, _ = bot.Send(msg)
+		return
+	}
+	mh.waitlists[chatID].FullName = text
+
+	msg := tgbotapi.NewMessage(chatID, "Введите телефон или Telegram (@username) для связи или перешлите контакт:")
+	_, _ = bot.Send(msg)
+	mh.userStates[chatID] = "awaiting_waitlist_contact"
+}
+
+func (mh *MessagesHandler) handleWaitlistContact(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, seller models.Actor, message *tgbotapi.Message) {
+	lgr := logger.New(ctx)
+
+	contact := strings.TrimSpace(message.Text)
+	if message.Contact != nil {
+		contact = message.Contact.PhoneNumber
+		if phone, err := utils.ParsePhone(contact); err == nil {
+			contact = phone
+		}
+	}
+	if contact == "" {
+		msg := tgbotapi.NewMessage(chatID, "Контакт не может быть пустым. Введите ещё раз:")
+		_, _ = bot.Send(msg)
+		return
+	}
+	mh.waitlists[chatID].Contact = contact
+
+	entry, err := mh.service.AddToWaitlist(ctx, seller, chatID, *mh.waitlists[chatID])
+	if err != nil {
+		lgr.Warn("HandleMessages:: AddToWaitlist:: Error during AddToWaitlist service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	mh.userStates[chatID] = ""
+	delete(mh.waitlists, chatID)
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("%s добавлен(а) в лист ожидания. Когда место освободится, Вам придёт уведомление.", entry.FullName))
+	_, _ = bot.Send(msg)
+	utils.ShowOptions(chatID, bot, seller.UserName, mh.cfg)
+}
+
+// handleWaitlistOfferCallback starts the sale for the spot held for the person, or passes the spot on if they declined.
+func (mh *MessagesHandler) handleWaitlistOfferCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+	chatID := callback.Message.Chat.ID
+	userName := callback.From.UserName
+
+	removeInlineKeyboard(ctx, bot, chatID, callback.Message.MessageID)
+
+	if strings.HasPrefix(callback.Data, waitlistDeclinePrefix) {
+		id, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, waitlistDeclinePrefix), 10, 64)
+		if err != nil {
+			return
+		}
+
+		respMsg := "Отказ записан, место предложено следующему в листе ожидания."
+		_, err = mh.service.DeclineWaitlistOffer(ctx, actorFrom(callback.From), id)
+		if err != nil {
+			lgr.Warn("HandleMessages:: DeclineWaitlistOffer:: Error during DeclineWaitlistOffer service method", zap.Error(err))
+			respMsg = presenter.ErrorMessage(err)
+		}
+		msg := tgbotapi.NewMessage(chatID, respMsg)
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	if !utils.UserInList(userName, mh.cfg.AllowedSellers) {
+		lgr.Info("Unauthorized user trying to use bot")
+		msg := tgbotapi.NewMessage(chatID, "У Вас нет прав для продажи билетов.")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, waitlistSellPrefix), 10, 64)
+	if err != nil {
+		return
+	}
+
+	entry, err := mh.service.WaitlistOffer(ctx, id)
+	if err != nil {
+		lgr.Warn("HandleMessages:: WaitlistOffer:: Error during WaitlistOffer service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	fio, err := utils.FormatFIO(entry.FullName)
+	if err != nil {
+		fio = entry.FullName
+	}
+	mh.clientData[chatID] = &models.ClientData{FIO: fio, WaitlistId: entry.Id}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Продажа билета для %s из листа ожидания.", fio))
+	_, _ = bot.Send(msg)
+	mh.checkBuyer(ctx, chatID, bot, userName)
+}
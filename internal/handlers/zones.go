@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/presenter"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+const zoneCheckPrefix = "zone_check_"
+
+// startZoneCheck begins the «Проверить доступ» flow: the zone checker picks the zone they guard
+// unless there is only one.
+func (mh *MessagesHandler) startZoneCheck(chatID, userID int64, bot *tgbotapi.BotAPI) {
+	zones := mh.service.Cfg.Zone.Zones
+	switch len(zones) {
+	case 0:
+		msg := tgbotapi.NewMessage(chatID, "Зоны с контролем доступа не настроены.")
+		_, _ = bot.Send(msg)
+	case 1:
+		mh.zones[userID] = zones[0]
+		mh.askZoneTicket(chatID, bot, zones[0])
+	default:
+		mh.userStates[chatID] = "awaiting_zone"
+
+		var rows [][]tgbotapi.KeyboardButton
+		for _, zone := range zones {
+			rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(zone)))
+		}
+		keyboard := tgbotapi.NewReplyKeyboard(rows...)
+		keyboard.OneTimeKeyboard = true
+		keyboard.ResizeKeyboard = true
+
+		msg := tgbotapi.NewMessage(chatID, "Выберите зону, доступ в которую Вы проверяете:")
+		msg.ReplyMarkup = keyboard
+		_, _ = bot.Send(msg)
+	}
+}
+
+func (mh *MessagesHandler) handleZoneChoice(chatID, userID int64, bot *tgbotapi.BotAPI, text string) {
+	if !slices.Contains(mh.service.Cfg.Zone.Zones, text) {
+		msg := tgbotapi.NewMessage(chatID, "Неверный выбор. Выберите зону кнопкой ниже.")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	mh.zones[userID] = text
+	mh.askZoneTicket(chatID, bot, text)
+}
+
+func (mh *MessagesHandler) askZoneTicket(chatID int64, bot *tgbotapi.BotAPI, zone string) {
+	mh.userStates[chatID] = "awaiting_zone_ticket"
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Зона: %s. Введите номер билета, фамилию или имя гостя:", zone))
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	_, _ = bot.Send(msg)
+}
+
+// handleZoneTicket checks a ticket number right away and lets the checker pick the guest
+// from the search results otherwise.
+func (mh *MessagesHandler) handleZoneTicket(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, checker models.Actor, text string) {
+	lgr := logger.New(ctx)
+
+	if _, err := strconv.Atoi(text); err == nil {
+		mh.sendZoneScan(ctx, chatID, bot, checker, text)
+		return
+	}
+
+	found, err := mh.service.SearchBySurname(ctx, checker, text)
+	if err != nil {
+		lgr.Warn("HandleMessages:: awaiting_zone_ticket:: Error during SearchBySurname service method", zap.Error(err))
+		msg := tgbotapi.NewMessage(chatID, presenter.ErrorMessage(err))
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, presenter.SearchResults(found, mh.service.Cfg.LacesColor, mh.service.Cfg.Event.MinAge))
+	if len(found) > 0 {
+		msg.ReplyMarkup = zoneCheckKeyboard(found)
+	}
+	_, _ = bot.Send(msg)
+}
+
+func (mh *MessagesHandler) handleZoneCheckCallback(ctx context.Context, callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+	if !utils.UserInList(callback.From.UserName, mh.cfg.ZoneCheckers) {
+		_, _ = bot.Request(tgbotapi.NewCallbackWithAlert(callback.ID, "У Вас нет прав для проверки доступа."))
+		return
+	}
+
+	ticketId := strings.TrimPrefix(callback.Data, zoneCheckPrefix)
+	mh.sendZoneScan(ctx, callback.Message.Chat.ID, bot, actorFrom(callback.From), ticketId)
+	_, _ = bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+}
+
+func (mh *MessagesHandler) sendZoneScan(ctx context.Context, chatID int64, bot *tgbotapi.BotAPI, checker models.Actor, ticketId string) {
+	lgr := logger.New(ctx)
+
+	zone, ok := mh.zones[checker.ID]
+	if !ok {
+		msg := tgbotapi.NewMessage(chatID, "Сначала выберите зону: нажмите «Проверить доступ».")
+		_, _ = bot.Send(msg)
+		return
+	}
+
+	var text string
+	scan, err := mh.service.CheckZoneAccess(ctx, checker, zone, ticketId)
+	if err != nil {
+		lgr.Warn("HandleMessages:: CheckZoneAccess:: Error during CheckZoneAccess service method", zap.Error(err))
+		text = presenter.ErrorMessage(err)
+	} else {
+		text = presenter.ZoneScan(scan, mh.service.Cfg.LacesColor)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, _ = bot.Send(msg)
+}
+
+func zoneCheckKeyboard(tickets []models.TicketResponse) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, ticket := range tickets {
+		btn := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Проверить: %s (ID: %s)", ticket.Name, ticket.Id), zoneCheckPrefix+ticket.Id)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(btn))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
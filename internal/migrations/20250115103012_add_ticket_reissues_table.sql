@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ticket_reissues
+(
+    id          SERIAL PRIMARY KEY,
+    ticket_id   INTEGER      NOT NULL,
+    actor_tag   VARCHAR(255) NOT NULL,
+    actor_tg_id VARCHAR(255) NOT NULL,
+    reissued_at TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ticket_reissues;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ticket_links
+(
+    id          SERIAL PRIMARY KEY,
+    ticket_id   INTEGER     NOT NULL,
+    token       VARCHAR(64) NOT NULL UNIQUE,
+    buyer_tag   VARCHAR(255),
+    buyer_tg_id BIGINT,
+    chat_id     BIGINT,
+    created_at  TIMESTAMP   NOT NULL DEFAULT NOW(),
+    linked_at   TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ticket_links_chat_id_idx ON ticket_links (chat_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ticket_links;
+-- +goose StatementEnd
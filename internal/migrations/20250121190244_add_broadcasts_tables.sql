@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS bot_users
+(
+    chat_id    BIGINT PRIMARY KEY,
+    username   VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS broadcasts
+(
+    id             SERIAL PRIMARY KEY,
+    author_tag     VARCHAR(255) NOT NULL,
+    author_chat_id BIGINT       NOT NULL,
+    target         VARCHAR(64)  NOT NULL,
+    text           TEXT         NOT NULL,
+    created_at     TIMESTAMP    NOT NULL DEFAULT NOW(),
+    finished_at    TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS broadcast_deliveries
+(
+    broadcast_id INTEGER     NOT NULL REFERENCES broadcasts (id) ON DELETE CASCADE,
+    chat_id      BIGINT      NOT NULL,
+    status       VARCHAR(16) NOT NULL DEFAULT 'pending',
+    error        TEXT,
+    sent_at      TIMESTAMP,
+    PRIMARY KEY (broadcast_id, chat_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS broadcast_deliveries;
+DROP TABLE IF EXISTS broadcasts;
+DROP TABLE IF EXISTS bot_users;
+-- +goose StatementEnd
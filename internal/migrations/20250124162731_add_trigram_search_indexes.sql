@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS tickets_surname_trgm_idx ON tickets USING gin (surname gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS tickets_full_name_trgm_idx ON tickets USING gin ((REPLACE(LOWER(full_name), 'ё', 'е')) gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS tickets_full_name_trgm_idx;
+DROP INDEX IF EXISTS tickets_surname_trgm_idx;
+-- +goose StatementEnd
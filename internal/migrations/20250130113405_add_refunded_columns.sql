@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tickets
+    ADD COLUMN refunded BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN refunded_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tickets
+    DROP COLUMN IF EXISTS refunded_at,
+    DROP COLUMN IF EXISTS refunded;
+-- +goose StatementEnd
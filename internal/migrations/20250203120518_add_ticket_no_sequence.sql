@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE SEQUENCE IF NOT EXISTS tickets_ticketno_seq AS INTEGER OWNED BY tickets.ticketno;
+
+SELECT setval('tickets_ticketno_seq', COALESCE(MAX(ticketno), 0) + 1, false) FROM tickets;
+
+ALTER TABLE tickets
+    ALTER COLUMN ticketno SET DEFAULT nextval('tickets_ticketno_seq');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tickets
+    ALTER COLUMN ticketno DROP DEFAULT;
+
+DROP SEQUENCE IF EXISTS tickets_ticketno_seq;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tickets
+    DROP CONSTRAINT IF EXISTS full_name_unique,
+    ADD COLUMN buyer_tag   VARCHAR(64),
+    ADD COLUMN buyer_phone VARCHAR(32);
+
+CREATE INDEX IF NOT EXISTS tickets_full_name_normalized_idx ON tickets ((REPLACE(LOWER(full_name), 'ё', 'е')));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS tickets_full_name_normalized_idx;
+
+ALTER TABLE tickets
+    DROP COLUMN IF EXISTS buyer_phone,
+    DROP COLUMN IF EXISTS buyer_tag,
+    ADD CONSTRAINT full_name_unique UNIQUE (full_name);
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tickets
+    ADD COLUMN buyer_email       VARCHAR(255),
+    ADD COLUMN buyer_birth_date  DATE,
+    ADD COLUMN marketing_consent BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tickets
+    DROP COLUMN IF EXISTS marketing_consent,
+    DROP COLUMN IF EXISTS buyer_birth_date,
+    DROP COLUMN IF EXISTS buyer_email;
+-- +goose StatementEnd
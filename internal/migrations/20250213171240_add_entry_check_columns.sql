@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tickets
+    ADD COLUMN entered_at TIMESTAMP,
+    ADD COLUMN entered_by VARCHAR(64),
+    ADD COLUMN id_checked BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tickets
+    DROP COLUMN IF EXISTS id_checked,
+    DROP COLUMN IF EXISTS entered_by,
+    DROP COLUMN IF EXISTS entered_at;
+-- +goose StatementEnd
@@ -0,0 +1,49 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS entries
+(
+    id         SERIAL PRIMARY KEY,
+    ticket_id  INTEGER     NOT NULL,
+    direction  VARCHAR(3)  NOT NULL CHECK (direction IN ('in', 'out')),
+    checker    VARCHAR(64),
+    door       VARCHAR(64),
+    id_checked BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS entries_ticket_id_idx ON entries (ticket_id, id);
+
+INSERT INTO entries (ticket_id, direction, checker, id_checked, created_at)
+SELECT ticketno, 'in', entered_by, id_checked, COALESCE(entered_at, NOW())
+FROM tickets
+WHERE passed_control_zone
+ORDER BY ticketno;
+
+ALTER TABLE tickets
+    DROP COLUMN passed_control_zone,
+    DROP COLUMN entered_at,
+    DROP COLUMN entered_by,
+    DROP COLUMN id_checked;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tickets
+    ADD COLUMN passed_control_zone BOOLEAN DEFAULT FALSE,
+    ADD COLUMN entered_at TIMESTAMP,
+    ADD COLUMN entered_by VARCHAR(64),
+    ADD COLUMN id_checked BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE tickets t
+SET passed_control_zone = TRUE,
+    entered_at          = e.created_at,
+    entered_by          = e.checker,
+    id_checked          = e.id_checked
+FROM (SELECT DISTINCT ON (ticket_id) ticket_id, direction, checker, id_checked, created_at
+      FROM entries
+      ORDER BY ticket_id, id DESC) e
+WHERE e.ticket_id = t.ticketno
+  AND e.direction = 'in';
+
+DROP TABLE IF EXISTS entries;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS zone_scans
+(
+    id         SERIAL PRIMARY KEY,
+    ticket_id  INTEGER     NOT NULL,
+    zone       VARCHAR(64) NOT NULL,
+    allowed    BOOLEAN     NOT NULL,
+    checker    VARCHAR(64),
+    scanned_at TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS zone_scans_ticket_id_idx ON zone_scans (ticket_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS zone_scans;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS blacklist
+(
+    id            SERIAL PRIMARY KEY,
+    full_name     VARCHAR(255) NOT NULL,
+    photo_file_id VARCHAR(255),
+    reason        TEXT         NOT NULL,
+    added_by      VARCHAR(64),
+    created_at    TIMESTAMP    NOT NULL DEFAULT NOW(),
+    removed_by    VARCHAR(64),
+    removed_at    TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS blacklist_overrides
+(
+    id           SERIAL PRIMARY KEY,
+    blacklist_id INTEGER     NOT NULL REFERENCES blacklist (id),
+    ticket_id    INTEGER     NOT NULL,
+    action       VARCHAR(8)  NOT NULL CHECK (action IN ('sale', 'entry')),
+    actor        VARCHAR(64) NOT NULL,
+    created_at   TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS blacklist_overrides_ticket_id_idx ON blacklist_overrides (ticket_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS blacklist_overrides;
+DROP TABLE IF EXISTS blacklist;
+-- +goose StatementEnd
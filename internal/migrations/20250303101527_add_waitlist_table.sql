@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS waitlist
+(
+    id               SERIAL PRIMARY KEY,
+    full_name        VARCHAR(255) NOT NULL,
+    contact          VARCHAR(255) NOT NULL,
+    added_by         VARCHAR(64)  NOT NULL,
+    added_by_chat_id BIGINT       NOT NULL,
+    status           VARCHAR(16)  NOT NULL DEFAULT 'waiting'
+        CHECK (status IN ('waiting', 'offered', 'sold', 'declined', 'expired', 'removed')),
+    offered_until    TIMESTAMP,
+    created_at       TIMESTAMP    NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS waitlist_status_idx ON waitlist (status, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS waitlist;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ticket_history
+(
+    id                 SERIAL PRIMARY KEY,
+    ticket_id          INTEGER      NOT NULL,
+    action             VARCHAR(16)  NOT NULL CHECK (action IN ('transfer')),
+    full_name_before   VARCHAR(255) NOT NULL,
+    full_name_after    VARCHAR(255) NOT NULL,
+    buyer_tag_before   VARCHAR(255),
+    buyer_phone_before VARCHAR(32),
+    actor_tag          VARCHAR(255) NOT NULL,
+    actor_tg_id        BIGINT,
+    created_at         TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ticket_history_ticket_id_idx ON ticket_history (ticket_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ticket_history;
+-- +goose StatementEnd
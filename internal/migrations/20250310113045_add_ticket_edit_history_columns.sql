@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE ticket_history
+    DROP CONSTRAINT IF EXISTS ticket_history_action_check,
+    ADD CONSTRAINT ticket_history_action_check CHECK (action IN ('transfer', 'edit')),
+    ADD COLUMN ticket_type_before  VARCHAR(10),
+    ADD COLUMN ticket_type_after   VARCHAR(10),
+    ADD COLUMN price_before        INTEGER,
+    ADD COLUMN price_after         INTEGER,
+    ADD COLUMN actual_price_before INTEGER,
+    ADD COLUMN actual_price_after  INTEGER,
+    ADD COLUMN price_difference    INTEGER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM ticket_history WHERE action = 'edit';
+
+ALTER TABLE ticket_history
+    DROP COLUMN IF EXISTS price_difference,
+    DROP COLUMN IF EXISTS actual_price_after,
+    DROP COLUMN IF EXISTS actual_price_before,
+    DROP COLUMN IF EXISTS price_after,
+    DROP COLUMN IF EXISTS price_before,
+    DROP COLUMN IF EXISTS ticket_type_after,
+    DROP COLUMN IF EXISTS ticket_type_before,
+    DROP CONSTRAINT IF EXISTS ticket_history_action_check,
+    ADD CONSTRAINT ticket_history_action_check CHECK (action IN ('transfer'));
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ticket_payments
+(
+    id           BIGSERIAL PRIMARY KEY,
+    ticket_id    INTEGER      NOT NULL,
+    kind         VARCHAR(16)  NOT NULL CHECK (kind IN ('surcharge')),
+    amount       INTEGER      NOT NULL,
+    seller_tag   VARCHAR(255) NOT NULL,
+    seller_tg_id BIGINT,
+    created_at   TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ticket_payments_ticket_id_idx ON ticket_payments (ticket_id);
+
+ALTER TABLE ticket_history
+    DROP CONSTRAINT IF EXISTS ticket_history_action_check,
+    ADD CONSTRAINT ticket_history_action_check CHECK (action IN ('transfer', 'edit', 'upgrade'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM ticket_history WHERE action = 'upgrade';
+
+ALTER TABLE ticket_history
+    DROP CONSTRAINT IF EXISTS ticket_history_action_check,
+    ADD CONSTRAINT ticket_history_action_check CHECK (action IN ('transfer', 'edit'));
+
+DROP TABLE IF EXISTS ticket_payments;
+-- +goose StatementEnd
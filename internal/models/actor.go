@@ -0,0 +1,12 @@
+package models
+
+// Actor is the staff member or buyer on whose behalf a service method is called.
+type Actor struct {
+	ID       int64  `json:"id"`
+	UserName string `json:"username"`
+}
+
+// Tag returns the "@username" form stored in the seller and audit columns.
+func (a Actor) Tag() string {
+	return "@" + a.UserName
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	AuditActionSearch            = "search"
+	AuditActionLookup            = "lookup"
+	AuditActionSell              = "sell"
+	AuditActionRefund            = "refund"
+	AuditActionReissue           = "reissue"
+	AuditActionEnter             = "enter"
+	AuditActionExit              = "exit"
+	AuditActionOfflineEntry      = "offline_entry"
+	AuditActionZoneCheck         = "zone_check"
+	AuditActionBlacklistAdd      = "blacklist_add"
+	AuditActionBlacklistRemove   = "blacklist_remove"
+	AuditActionBlacklistOverride = "blacklist_override"
+	AuditActionWaitlistAdd       = "waitlist_add"
+	AuditActionWaitlistRemove    = "waitlist_remove"
+	AuditActionWaitlistDecline   = "waitlist_decline"
+	AuditActionTransfer          = "transfer"
+	AuditActionEdit              = "edit"
+	AuditActionUpgrade           = "upgrade"
+)
+
+// AuditRecord is a row of the append-only audit log: who did what to which ticket, with the
+// ticket's state before and after. Details hold what else identifies the action, such as the
+// search query, the checkpoint or the zone.
+type AuditRecord struct {
+	ActorId   int64           `json:"actor_id,omitempty"`
+	ActorName string          `json:"actor_name,omitempty"`
+	Action    string          `json:"action"`
+	TicketNo  string          `json:"ticket_no,omitempty"`
+	Details   string          `json:"details,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+const (
+	BlacklistOverrideSale  = "sale"
+	BlacklistOverrideEntry = "entry"
+)
+
+// BlacklistEntry is a person banned after an earlier incident.
+type BlacklistEntry struct {
+	Id          int64  `json:"id"`
+	FullName    string `json:"full_name"`
+	PhotoFileId string `json:"photo_file_id,omitempty"`
+	Reason      string `json:"reason"`
+	AddedBy     string `json:"added_by,omitempty"`
+}
+
+// BlacklistOverride is a privileged staff member's decision to sell a ticket to
+// or let in a guest who matches a blacklist entry.
+type BlacklistOverride struct {
+	BlacklistId int64
+	TicketId    string
+	Action      string
+	Actor       string
+}
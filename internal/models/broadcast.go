@@ -0,0 +1,28 @@
+package models
+
+const (
+	BroadcastTargetStaff      = "staff"
+	BroadcastTargetCheckers   = "checkers"
+	BroadcastTargetSellers    = "sellers"
+	BroadcastTargetBuyers     = "buyers"
+	BroadcastTargetTypePrefix = "type:"
+
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed"
+)
+
+type Broadcast struct {
+	Id           int64  `json:"id"`
+	AuthorTag    string `json:"author_tag"`
+	AuthorChatId int64  `json:"author_chat_id"`
+	Target       string `json:"target"`
+	Text         string `json:"text"`
+}
+
+type BroadcastSummary struct {
+	Id     int64 `json:"id"`
+	Total  int   `json:"total"`
+	Sent   int   `json:"sent"`
+	Failed int   `json:"failed"`
+}
@@ -0,0 +1,22 @@
+package models
+
+const (
+	EntryDirectionIn  = "in"
+	EntryDirectionOut = "out"
+)
+
+// Entry is a record of the entries log: the guest came in or went out through a door.
+type Entry struct {
+	TicketId  string
+	Direction string
+	Checker   string
+	Door      string
+	IDChecked bool
+}
+
+// EntryState is what the entries log says about a ticket: whether the guest is inside now
+// and how many times they have come in.
+type EntryState struct {
+	Inside  bool
+	Entries int
+}
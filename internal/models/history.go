@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+const (
+	TicketHistoryTransfer = "transfer"
+	TicketHistoryEdit     = "edit"
+	TicketHistoryUpgrade  = "upgrade"
+)
+
+// TicketTransfer passes the ticket to another holder under the same ticket number.
+type TicketTransfer struct {
+	TicketId          string `json:"ticket_id"`
+	FullName          string `json:"full_name"`
+	PreviousName      string `json:"previous_name,omitempty"`
+	BlacklistOverride bool   `json:"blacklist_override"`
+}
+
+// TicketEdit corrects the ticket after the sale. Fields left empty stay as they are.
+type TicketEdit struct {
+	TicketId   string `json:"ticket_id"`
+	FullName   string `json:"full_name,omitempty"`
+	TicketType string `json:"ticket_type,omitempty"`
+	Price      int    `json:"price,omitempty"`
+}
+
+// TicketDetails are the parts of a sold ticket an admin may edit, with what they depend on.
+type TicketDetails struct {
+	FullName    string `json:"full_name"`
+	Surname     string `json:"-"`
+	TicketType  string `json:"ticket_type"`
+	Price       int    `json:"price"`
+	ActualPrice int    `json:"actual_price"`
+	Seller      string `json:"seller"`
+}
+
+type TicketEditResult struct {
+	TicketId string        `json:"ticket_id"`
+	Before   TicketDetails `json:"before"`
+	After    TicketDetails `json:"after"`
+	// PriceDifference is how the list price (actual_ticket_price) changed with the ticket type.
+	PriceDifference int  `json:"price_difference"`
+	SheetSynced     bool `json:"sheet_synced"`
+}
+
+// TicketHistoryRecord is a row of ticket_history. A transfer fills the names and the previous
+// holder's contacts, an edit and an upgrade the names, types and prices.
+type TicketHistoryRecord struct {
+	Action            string    `json:"action"`
+	FullNameBefore    string    `json:"full_name_before"`
+	FullNameAfter     string    `json:"full_name_after"`
+	BuyerTagBefore    string    `json:"buyer_tag_before,omitempty"`
+	BuyerPhoneBefore  string    `json:"buyer_phone_before,omitempty"`
+	TicketTypeBefore  string    `json:"ticket_type_before,omitempty"`
+	TicketTypeAfter   string    `json:"ticket_type_after,omitempty"`
+	PriceBefore       int       `json:"price_before,omitempty"`
+	PriceAfter        int       `json:"price_after,omitempty"`
+	ActualPriceBefore int       `json:"actual_price_before,omitempty"`
+	ActualPriceAfter  int       `json:"actual_price_after,omitempty"`
+	PriceDifference   int       `json:"price_difference,omitempty"`
+	ActorTag          string    `json:"actor_tag"`
+	ActorId           int64     `json:"actor_id,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// TicketHistory is everything that happened to a ticket after the sale, oldest first.
+type TicketHistory struct {
+	TicketId string                `json:"ticket_id"`
+	Changes  []TicketHistoryRecord `json:"changes"`
+	Payments []Payment             `json:"payments"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// PaymentKindSurcharge is paid on top of the ticket price when the ticket is upgraded.
+const PaymentKindSurcharge = "surcharge"
+
+// Payment is a row of ticket_payments: money taken for the ticket after the sale.
+type Payment struct {
+	Kind      string    `json:"kind"`
+	Amount    int       `json:"amount"`
+	SellerTag string    `json:"seller_tag"`
+	SellerId  int64     `json:"seller_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TicketUpgrade moves a Базовый ticket to a VIP table.
+type TicketUpgrade struct {
+	TicketId   string `json:"ticket_id"`
+	TicketType string `json:"ticket_type"`
+}
+
+type TicketUpgradeResult struct {
+	TicketId string        `json:"ticket_id"`
+	Before   TicketDetails `json:"before"`
+	After    TicketDetails `json:"after"`
+	// Surcharge is the VIP price less the list price of the ticket before, credited to the seller of the upgrade.
+	Surcharge int `json:"surcharge"`
+	// SheetSynced reports the replaced sale row, SurchargeSynced the appended surcharge row (true without a surcharge).
+	SheetSynced     bool `json:"sheet_synced"`
+	SurchargeSynced bool `json:"surcharge_synced"`
+}
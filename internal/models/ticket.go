@@ -1,16 +1,65 @@
 package models
 
 type TicketResponse struct {
-	Id                string `json:"id"`
-	Name              string `json:"full_name"`
-	Surname           string `json:"surname"`
-	TicketType        string `json:"ticket_type"`
-	PassedControlZone bool   `json:"passed_control_zone]"`
+	Id                string  `json:"id"`
+	Name              string  `json:"full_name"`
+	Surname           string  `json:"surname"`
+	TicketType        string  `json:"ticket_type"`
+	PassedControlZone bool    `json:"passed_control_zone"`
+	BuyerTag          string  `json:"buyer_tag,omitempty"`
+	BuyerPhone        string  `json:"buyer_phone,omitempty"`
+	BirthDate         string  `json:"birth_date,omitempty"`
+	Score             float64 `json:"score,omitempty"`
+	// Blacklist holds the blacklist entries the holder's name matches, filled in by the service for the door.
+	Blacklist []BlacklistEntry `json:"blacklist,omitempty"`
 }
 
 type ClientData struct {
-	FIO          string `json:"fio"`
-	TicketType   string `json:"ticket_type"`
-	Price        int    `json:"price"`
-	RepostExists bool   `json:"repost_exists"`
+	FIO               string `json:"fio"`
+	TicketType        string `json:"ticket_type"`
+	Price             int    `json:"price"`
+	RepostExists      bool   `json:"repost_exists"`
+	BuyerTag          string `json:"buyer_tag"`
+	BuyerTgId         int64  `json:"buyer_tg_id"`
+	BuyerPhone        string `json:"buyer_phone"`
+	BuyerEmail        string `json:"buyer_email"`
+	BuyerBirthDate    string `json:"buyer_birth_date"`
+	MarketingConsent  bool   `json:"marketing_consent"`
+	NamesakeConfirmed bool   `json:"namesake_confirmed"`
+	BlacklistOverride bool   `json:"blacklist_override"`
+	// WaitlistId is the waitlist entry whose held spot the ticket is sold for, if any.
+	WaitlistId int64 `json:"waitlist_id,omitempty"`
+}
+
+// BuyerContact is what organizers use to reach a buyer if the event changes.
+type BuyerContact struct {
+	TicketNo         string `json:"ticket_no"`
+	FullName         string `json:"full_name"`
+	Tag              string `json:"buyer_tag,omitempty"`
+	Phone            string `json:"buyer_phone,omitempty"`
+	Email            string `json:"buyer_email,omitempty"`
+	MarketingConsent bool   `json:"marketing_consent"`
+}
+
+type Stats struct {
+	Sold     int            `json:"sold"`
+	Entered  int            `json:"entered"`
+	Inside   int            `json:"inside"`
+	Refunded int            `json:"refunded"`
+	Revenue  int            `json:"revenue"`
+	ByType   map[string]int `json:"by_type"`
+}
+
+type SaleResult struct {
+	TicketNo    int64  `json:"ticket_no"`
+	FIO         string `json:"fio"`
+	Image       []byte `json:"-"`
+	LinkToken   string `json:"link_token,omitempty"`
+	SheetSynced bool   `json:"sheet_synced"`
+	LinkFailed  bool   `json:"link_failed"`
+}
+
+type IssuedTicket struct {
+	Ticket TicketResponse `json:"ticket"`
+	Image  []byte         `json:"-"`
 }
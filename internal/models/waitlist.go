@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	WaitlistStatusWaiting  = "waiting"
+	WaitlistStatusOffered  = "offered"
+	WaitlistStatusSold     = "sold"
+	WaitlistStatusDeclined = "declined"
+	WaitlistStatusExpired  = "expired"
+	WaitlistStatusRemoved  = "removed"
+)
+
+// WaitlistEntry is a person who wants a ticket to a sold-out event. Entries are offered
+// a freed spot in the order they were added, through the seller who added them.
+type WaitlistEntry struct {
+	Id            int64      `json:"id"`
+	FullName      string     `json:"full_name"`
+	Contact       string     `json:"contact"`
+	AddedBy       string     `json:"added_by"`
+	AddedByChatId int64      `json:"-"`
+	Status        string     `json:"status"`
+	OfferedUntil  *time.Time `json:"offered_until,omitempty"`
+}
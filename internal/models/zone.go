@@ -0,0 +1,8 @@
+package models
+
+// ZoneScan is the answer to a zone checker: whether the ticket holder may enter the zone.
+type ZoneScan struct {
+	Zone    string          `json:"zone"`
+	Allowed bool            `json:"allowed"`
+	Ticket  *TicketResponse `json:"ticket"`
+}
@@ -0,0 +1,324 @@
+package offline
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+const minSearchScore = 0.3
+
+var (
+	metaBucket    = []byte("meta")
+	ticketsBucket = []byte("tickets")
+	entriesBucket = []byte("entries")
+
+	signatureKey = []byte("signature")
+	takenAtKey   = []byte("taken_at")
+
+	ErrNoSnapshot       = errors.New("snapshot is not downloaded")
+	ErrInvalidSignature = errors.New("snapshot signature is invalid")
+	ErrTicketNotFound   = errors.New("ticket is not in the snapshot")
+	ErrAlreadyEntered   = errors.New("ticket is already marked as entered")
+)
+
+// Entry is a check-in registered while the door was offline.
+type Entry struct {
+	Seq       uint64    `json:"seq"`
+	TicketId  string    `json:"ticket_id"`
+	Door      string    `json:"door"`
+	EnteredAt time.Time `json:"entered_at"`
+	Synced    bool      `json:"synced"`
+	Conflict  bool      `json:"conflict"`
+}
+
+// Store keeps a signed copy of the guest list and the queue of offline entries in a BoltDB file.
+type Store struct {
+	db     *bolt.DB
+	secret []byte
+}
+
+func Open(path, secret string) (*Store, error) {
+	if secret == "" {
+		return nil, errors.New("snapshot secret is empty")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("offline.Open(): failed to open snapshot file: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{metaBucket, ticketsBucket, entriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("offline.Open(): failed to init buckets: %w", err)
+	}
+
+	return &Store{db: db, secret: []byte(secret)}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SaveSnapshot replaces the stored guest list and signs it. Queued entries are kept.
+func (s *Store) SaveSnapshot(tickets []models.TicketResponse, takenAt time.Time) error {
+	sort.Slice(tickets, func(i, j int) bool { return tickets[i].Id < tickets[j].Id })
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(ticketsBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(ticketsBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, ticket := range tickets {
+			data, err := json.Marshal(ticket)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(ticket.Id), data); err != nil {
+				return err
+			}
+		}
+
+		meta := tx.Bucket(metaBucket)
+		takenAtData := []byte(takenAt.UTC().Format(time.RFC3339))
+		if err := meta.Put(takenAtKey, takenAtData); err != nil {
+			return err
+		}
+
+		return meta.Put(signatureKey, []byte(s.sign(bucket, takenAtData)))
+	})
+}
+
+// Verify checks that the guest list has not been changed since it was downloaded.
+func (s *Store) Verify() (time.Time, error) {
+	var takenAt time.Time
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		signature := meta.Get(signatureKey)
+		takenAtData := meta.Get(takenAtKey)
+		if signature == nil || takenAtData == nil {
+			return ErrNoSnapshot
+		}
+
+		expected := s.sign(tx.Bucket(ticketsBucket), takenAtData)
+		if !hmac.Equal([]byte(expected), signature) {
+			return ErrInvalidSignature
+		}
+
+		var err error
+		takenAt, err = time.Parse(time.RFC3339, string(takenAtData))
+		return err
+	})
+
+	return takenAt, err
+}
+
+// Search ranks the snapshot with the same trigram scoring as the online search.
+func (s *Store) Search(query string) ([]models.TicketResponse, error) {
+	if _, err := s.Verify(); err != nil {
+		return nil, err
+	}
+
+	entered, err := s.enteredTickets()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := utils.SearchTerms(query)
+	var found []models.TicketResponse
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ticketsBucket)
+
+		if ticket := bucket.Get([]byte(query)); ticket != nil {
+			var resp models.TicketResponse
+			if err := json.Unmarshal(ticket, &resp); err != nil {
+				return err
+			}
+			resp.PassedControlZone = resp.PassedControlZone || entered[resp.Id]
+			found = append(found, resp)
+			return nil
+		}
+
+		return bucket.ForEach(func(_, data []byte) error {
+			var resp models.TicketResponse
+			if err := json.Unmarshal(data, &resp); err != nil {
+				return err
+			}
+
+			resp.Score = utils.NameMatchScore(terms, resp.Surname, resp.Name)
+			if resp.Score >= minSearchScore {
+				resp.PassedControlZone = resp.PassedControlZone || entered[resp.Id]
+				found = append(found, resp)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Score > found[j].Score })
+	return found, nil
+}
+
+// MarkAsEntered queues an entry for the ticket, refusing tickets that are already marked
+// in the snapshot or in the local queue.
+func (s *Store) MarkAsEntered(ticketId, door string, now time.Time) (*models.TicketResponse, error) {
+	if _, err := s.Verify(); err != nil {
+		return nil, err
+	}
+
+	var resp models.TicketResponse
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(ticketsBucket).Get([]byte(ticketId))
+		if data == nil {
+			return ErrTicketNotFound
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return err
+		}
+
+		entries := tx.Bucket(entriesBucket)
+		alreadyQueued := false
+		err := entries.ForEach(func(_, value []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			alreadyQueued = alreadyQueued || entry.TicketId == ticketId
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if resp.PassedControlZone || alreadyQueued {
+			return ErrAlreadyEntered
+		}
+
+		seq, err := entries.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry := Entry{Seq: seq, TicketId: ticketId, Door: door, EnteredAt: now}
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		resp.PassedControlZone = true
+		return entries.Put(seqKey(seq), value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// PendingEntries returns the entries that have not been synced yet, oldest first.
+func (s *Store) PendingEntries() ([]Entry, error) {
+	var pending []Entry
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(_, value []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			if !entry.Synced {
+				pending = append(pending, entry)
+			}
+			return nil
+		})
+	})
+
+	return pending, err
+}
+
+func (s *Store) MarkSynced(seq uint64, conflict bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+
+		value := entries.Get(seqKey(seq))
+		if value == nil {
+			return fmt.Errorf("entry %d not found", seq)
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		entry.Synced = true
+		entry.Conflict = conflict
+
+		updated, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return entries.Put(seqKey(seq), updated)
+	})
+}
+
+func (s *Store) enteredTickets() (map[string]bool, error) {
+	entered := make(map[string]bool)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(_, value []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			entered[entry.TicketId] = true
+			return nil
+		})
+	})
+
+	return entered, err
+}
+
+// sign computes an HMAC over the download time and every ticket in key order.
+func (s *Store) sign(tickets *bolt.Bucket, takenAt []byte) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(takenAt)
+
+	_ = tickets.ForEach(func(key, value []byte) error {
+		mac.Write([]byte{'\n'})
+		mac.Write(key)
+		mac.Write([]byte{'='})
+		mac.Write(bytes.TrimSpace(value))
+		return nil
+	})
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
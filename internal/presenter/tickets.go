@@ -0,0 +1,452 @@
+package presenter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+)
+
+const (
+	ticketLinkTemplate = "https://t.me/%s?start=t_%s"
+	auditRecordsShown  = 30
+
+	NoClientsFound  = "Не удалось найти клиента с указанной фамилией, даже с учетом опечаток."
+	NoLinkedTickets = "К Вашему аккаунту не привязано ни одного билета. Откройте ссылку, которую Вам отправил продавец"
+)
+
+func ResponseMapper(resp *models.TicketResponse, cfg configs.LacesColors, minAge int) string {
+	successEmoji := "ДА ✅✅✅"
+	failEmoji := "НЕТ ❌❌❌"
+
+	laceColor, ok := utils.LaceColor(resp.TicketType, cfg)
+	if !ok {
+		return "Неизвестный тип билета"
+	}
+
+	controlStatus := failEmoji
+	if resp.PassedControlZone {
+		controlStatus = successEmoji
+	}
+
+	result := fmt.Sprintf("Номер билета: %s,\nФИО: %s,\nТип браслета: %s,\nЦвет браслета: %s,\nСейчас внутри? - %s",
+		resp.Id, resp.Name, resp.TicketType, laceColor, controlStatus)
+	if contact := Contact(resp); contact != "" {
+		result += ",\nКонтакт: " + contact
+	}
+	if warning := AgeWarning(resp, minAge); warning != "" {
+		result = warning + "\n\n" + result
+	}
+	if warning := BlacklistWarning(resp.Blacklist); warning != "" {
+		result = warning + "\n\n" + result
+	}
+
+	return result
+}
+
+// BlacklistWarning is shown on top of the door card and to the seller when the name matches the blacklist.
+func BlacklistWarning(entries []models.BlacklistEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+
+	var result strings.Builder
+	result.WriteString("⛔⛔⛔ ЧЁРНЫЙ СПИСОК ⛔⛔⛔\n")
+	for _, entry := range entries {
+		result.WriteString(fmt.Sprintf("%s — %s\n", entry.FullName, entry.Reason))
+	}
+	result.WriteString("Сверьте документ. Пропустить гостя или продать ему билет можно только с разрешения администратора.")
+
+	return result.String()
+}
+
+func Blacklist(entries []models.BlacklistEntry) string {
+	if len(entries) == 0 {
+		return "Чёрный список пуст."
+	}
+
+	var result strings.Builder
+	result.WriteString("Чёрный список:\n\n")
+	for _, entry := range entries {
+		photo := ""
+		if entry.PhotoFileId != "" {
+			photo = ", есть фото"
+		}
+		result.WriteString(fmt.Sprintf("%d. %s — %s (добавил %s%s)\n", entry.Id, entry.FullName, entry.Reason, entry.AddedBy, photo))
+	}
+
+	return strings.TrimSpace(result.String())
+}
+
+func Waitlist(entries []models.WaitlistEntry) string {
+	if len(entries) == 0 {
+		return "Лист ожидания пуст."
+	}
+
+	var result strings.Builder
+	result.WriteString("Лист ожидания:\n\n")
+	for i, entry := range entries {
+		offer := ""
+		if entry.Status == models.WaitlistStatusOffered && entry.OfferedUntil != nil {
+			offer = fmt.Sprintf(", место держится до %s", entry.OfferedUntil.Format("15:04"))
+		}
+		result.WriteString(fmt.Sprintf("%d. %s — %s (добавил %s%s)\n", i+1, entry.FullName, entry.Contact, entry.AddedBy, offer))
+	}
+
+	return strings.TrimSpace(result.String())
+}
+
+// WaitlistOffer tells the seller who added the person to the waitlist that a spot is held for them.
+func WaitlistOffer(entry models.WaitlistEntry) string {
+	until := ""
+	if entry.OfferedUntil != nil {
+		until = fmt.Sprintf(" до %s", entry.OfferedUntil.Format("15:04"))
+	}
+
+	return fmt.Sprintf("Освободилось место для %s из листа ожидания (контакт: %s).\n\nМесто держится%s. Свяжитесь с покупателем и продайте билет или отметьте отказ, чтобы место перешло следующему.",
+		entry.FullName, entry.Contact, until)
+}
+
+func WaitlistOfferExpired(entry models.WaitlistEntry) string {
+	return fmt.Sprintf("Время на покупку для %s из листа ожидания истекло, место предложено следующему.", entry.FullName)
+}
+
+// AgeWarning is shown on top of the door card when the checker has to see the guest's ID.
+func AgeWarning(resp *models.TicketResponse, minAge int) string {
+	now := time.Now()
+	if !utils.NeedsIDCheck(resp.BirthDate, minAge, now) {
+		return ""
+	}
+
+	age, ok := utils.Age(resp.BirthDate, now)
+	if !ok {
+		return fmt.Sprintf("⚠️⚠️⚠️ ДАТА РОЖДЕНИЯ НЕ УКАЗАНА — ПРОВЕРЬТЕ ДОКУМЕНТ (%d+) ⚠️⚠️⚠️", minAge)
+	}
+
+	return fmt.Sprintf("⚠️⚠️⚠️ ГОСТЮ %d — МЕНЬШЕ %d ЛЕТ! ПРОВЕРЬТЕ ДОКУМЕНТ ⚠️⚠️⚠️", age, minAge)
+}
+
+// CheckpointWarning is shown on top of the door card when the ticket type has to use another checkpoint.
+func CheckpointWarning(resp *models.TicketResponse, checkpoint string, cfg configs.DoorOptions) string {
+	if utils.CheckpointAllowed(resp.TicketType, checkpoint, cfg) {
+		return ""
+	}
+
+	return fmt.Sprintf("⚠️ НЕ ТОТ ВХОД: билет «%s» проходит через %s", resp.TicketType, strings.Join(utils.TicketCheckpoints(resp.TicketType, cfg), " или "))
+}
+
+func Checkpoint(checkpoint string) string {
+	return fmt.Sprintf("Ваш вход: %s. Сменить его можно командой /start", checkpoint)
+}
+
+func ZoneScan(scan *models.ZoneScan, cfg configs.LacesColors) string {
+	verdict := fmt.Sprintf("✅✅✅ ДОСТУП В «%s» РАЗРЕШЁН", scan.Zone)
+	if !scan.Allowed {
+		verdict = fmt.Sprintf("⛔⛔⛔ ДОСТУП В «%s» ЗАПРЕЩЁН", scan.Zone)
+	}
+
+	laceColor, ok := utils.LaceColor(scan.Ticket.TicketType, cfg)
+	if !ok {
+		laceColor = "?"
+	}
+
+	return fmt.Sprintf("%s\n\n%s (ID: %s)\nТип билета: %s, браслет: %s", verdict, scan.Ticket.Name, scan.Ticket.Id, scan.Ticket.TicketType, laceColor)
+}
+
+// IDCheckPrompt asks the checker to confirm they have seen the guest's ID before letting them in.
+func IDCheckPrompt(resp *models.TicketResponse, minAge int) string {
+	return fmt.Sprintf("%s\n\n%s (ID: %s). Подтвердите, что Вы проверили документ и гостю можно войти.",
+		AgeWarning(resp, minAge), resp.Name, resp.Id)
+}
+
+// Contact joins the buyer's Telegram and phone, which tell namesakes apart.
+func Contact(resp *models.TicketResponse) string {
+	var contacts []string
+	for _, contact := range []string{resp.BuyerTag, resp.BuyerPhone} {
+		if contact != "" {
+			contacts = append(contacts, contact)
+		}
+	}
+
+	return strings.Join(contacts, ", ")
+}
+
+func BuyerResponseMapper(resp *models.TicketResponse, cfg configs.LacesColors) string {
+	laceColor, ok := utils.LaceColor(resp.TicketType, cfg)
+	if !ok {
+		laceColor = "уточните у организаторов"
+	}
+
+	entranceStatus := "не отмечен"
+	if resp.PassedControlZone {
+		entranceStatus = "вход отмечен ✅"
+	}
+
+	return fmt.Sprintf("Номер билета: %s\nФИО: %s\nТип билета: %s\nЦвет браслета: %s\nВход: %s",
+		resp.Id, resp.Name, resp.TicketType, laceColor, entranceStatus)
+}
+
+func EventInfoMapper(cfg configs.EventInfo) string {
+	var result strings.Builder
+	if cfg.Title != "" {
+		result.WriteString(cfg.Title + "\n\n")
+	}
+	if cfg.StartsAt != "" {
+		result.WriteString("Начало: " + cfg.StartsAt + "\n")
+	}
+	if cfg.Address != "" {
+		result.WriteString("Адрес: " + cfg.Address + "\n")
+	}
+
+	if result.Len() == 0 {
+		return "Информация о мероприятии пока не опубликована"
+	}
+
+	return strings.TrimSpace(result.String())
+}
+
+func SearchResults(tickets []models.TicketResponse, cfg configs.LacesColors, minAge int) string {
+	if len(tickets) == 0 {
+		return NoClientsFound
+	}
+
+	var result strings.Builder
+	result.WriteString("Найдены следующие покупатели (с учетом возможных опечаток):\n\n")
+	for _, ticket := range tickets {
+		result.WriteString(ResponseMapper(&ticket, cfg, minAge) + "\n\n")
+	}
+
+	return strings.TrimSpace(result.String())
+}
+
+// Namesakes asks the seller to make sure the buyer is not the holder of one of the tickets.
+func Namesakes(tickets []models.TicketResponse) string {
+	var result strings.Builder
+	result.WriteString("Билет на такое ФИО уже продан:\n\n")
+	for _, ticket := range tickets {
+		contact := Contact(&ticket)
+		if contact == "" {
+			contact = "не указан"
+		}
+		result.WriteString(fmt.Sprintf("№%s, %s, %s, контакт: %s\n", ticket.Id, ticket.Name, ticket.TicketType, contact))
+	}
+	result.WriteString("\nЕсли покупатель — другой человек, подтвердите продажу. Чтобы отличить их на входе, укажите контакт покупателя на последнем шаге.")
+
+	return result.String()
+}
+
+func Entered(resp *models.TicketResponse) string {
+	return fmt.Sprintf("%s прошел контроль (ID: %s)", resp.Name, resp.Id)
+}
+
+func Exited(resp *models.TicketResponse) string {
+	return fmt.Sprintf("%s вышел (ID: %s)", resp.Name, resp.Id)
+}
+
+// Sale describes the sale to the seller, including the side effects that failed.
+// botName is the bot's username used to build the buyer's deep link.
+func Sale(result *models.SaleResult, botName string) string {
+	msg := fmt.Sprintf("Билет успешно продан!\nФИО покупателя: %s\nНомер билета: %d", result.FIO, result.TicketNo)
+
+	if !result.SheetSynced {
+		msg += fmt.Sprintf("\n\nНе удалось записать данные в гугл таблицу. Напишите @yahor_malinouski номер билета (%v), который не был записан в гугл таблицу", result.TicketNo)
+	}
+	if result.Image == nil {
+		msg += "\n\nНе удалось сгенерировать изображение билета. Получите его позже через «Перевыпустить билет»"
+	}
+
+	switch {
+	case result.LinkFailed:
+		msg += "\n\nНе удалось создать ссылку для покупателя. Перешлите ему билет вручную"
+	case result.LinkToken != "":
+		msg += fmt.Sprintf("\n\nОтправьте покупателю ссылку, по которой он получит билет в боте:\n%s", TicketLink(botName, result.LinkToken))
+	}
+
+	return msg
+}
+
+func TicketLink(botName, token string) string {
+	return fmt.Sprintf(ticketLinkTemplate, botName, token)
+}
+
+func Reissued(issued *models.IssuedTicket) string {
+	return fmt.Sprintf("Билет перевыпущен!\nФИО покупателя: %s\nНомер билета: %s", issued.Ticket.Name, issued.Ticket.Id)
+}
+
+func Linked(issued *models.IssuedTicket) string {
+	if issued.Image == nil {
+		return "Билет привязан к Вашему аккаунту, но не удалось сгенерировать его изображение. Попробуйте позже командой /myticket"
+	}
+
+	return fmt.Sprintf("Ваш билет!\nФИО: %s\nНомер билета: %s\n\nОткрыть его снова можно командой /myticket", issued.Ticket.Name, issued.Ticket.Id)
+}
+
+func Transferred(transfer *models.TicketTransfer) string {
+	return fmt.Sprintf("Билет №%s передан!\nПрежний владелец: %s\nНовый владелец: %s", transfer.TicketId, transfer.PreviousName, transfer.FullName)
+}
+
+// Edited lists what was changed on the ticket and the change of its list price, if the type changed.
+func Edited(result *models.TicketEditResult) string {
+	before, after := result.Before, result.After
+	msg := fmt.Sprintf("Билет №%s изменён!", result.TicketId)
+
+	if before.FullName != after.FullName {
+		msg += fmt.Sprintf("\nФИО: %s → %s", before.FullName, after.FullName)
+	}
+	if before.TicketType != after.TicketType {
+		msg += fmt.Sprintf("\nТип билета: %s → %s", before.TicketType, after.TicketType)
+	}
+	if before.Price != after.Price {
+		msg += fmt.Sprintf("\nЦена: %d → %d", before.Price, after.Price)
+	}
+	if result.PriceDifference != 0 {
+		msg += fmt.Sprintf("\nРазница в стоимости билета: %+d", result.PriceDifference)
+	}
+
+	if !result.SheetSynced {
+		msg += fmt.Sprintf("\n\nНе удалось обновить гугл таблицу. Напишите @yahor_malinouski номер билета (%s), который нужно исправить в гугл таблице", result.TicketId)
+	}
+
+	return msg
+}
+
+// UpgradeQuote asks the seller to take the surcharge before the ticket is upgraded.
+func UpgradeQuote(quote *models.TicketUpgradeResult, cfg configs.LacesColors) string {
+	return fmt.Sprintf("Билет №%s (%s): %s → %s\nСтоимость ВИП: %d, уже оплачено: %d\nДоплата: %d\n%s\n\nПримите доплату и подтвердите повышение:",
+		quote.TicketId, quote.Before.FullName, quote.Before.TicketType, quote.After.TicketType, quote.After.ActualPrice, quote.Before.ActualPrice, quote.Surcharge, laceChange(quote, cfg))
+}
+
+func Upgraded(result *models.TicketUpgradeResult, cfg configs.LacesColors) string {
+	msg := fmt.Sprintf("Билет №%s повышен до %s!\nДоплата %d записана на Вас.\n%s", result.TicketId, result.After.TicketType, result.Surcharge, laceChange(result, cfg))
+
+	if !result.SheetSynced {
+		msg += fmt.Sprintf("\n\nНе удалось обновить гугл таблицу. Напишите @yahor_malinouski номер билета (%s), который нужно исправить в гугл таблице", result.TicketId)
+	}
+	if !result.SurchargeSynced {
+		msg += fmt.Sprintf("\n\nНе удалось добавить доплату в гугл таблицу. Напишите @yahor_malinouski номер билета (%s) и сумму доплаты (%d)", result.TicketId, result.Surcharge)
+	}
+
+	return msg
+}
+
+func laceChange(upgrade *models.TicketUpgradeResult, cfg configs.LacesColors) string {
+	before, ok := utils.LaceColor(upgrade.Before.TicketType, cfg)
+	if !ok {
+		before = "?"
+	}
+	after, ok := utils.LaceColor(upgrade.After.TicketType, cfg)
+	if !ok {
+		after = "?"
+	}
+
+	return fmt.Sprintf("Цвет браслета: %s → %s", before, after)
+}
+
+func Refunded(resp *models.TicketResponse) string {
+	return fmt.Sprintf("Билет №%s (%s) возвращён", resp.Id, resp.Name)
+}
+
+var auditActions = map[string]string{
+	models.AuditActionSearch:            "поиск",
+	models.AuditActionLookup:            "просмотр",
+	models.AuditActionSell:              "продажа",
+	models.AuditActionRefund:            "возврат",
+	models.AuditActionReissue:           "перевыпуск",
+	models.AuditActionEnter:             "вход",
+	models.AuditActionExit:              "выход",
+	models.AuditActionOfflineEntry:      "вход без связи",
+	models.AuditActionZoneCheck:         "проверка зоны",
+	models.AuditActionBlacklistAdd:      "добавление в чёрный список",
+	models.AuditActionBlacklistRemove:   "удаление из чёрного списка",
+	models.AuditActionBlacklistOverride: "вход вопреки чёрному списку",
+	models.AuditActionWaitlistAdd:       "добавление в лист ожидания",
+	models.AuditActionWaitlistRemove:    "удаление из листа ожидания",
+	models.AuditActionWaitlistDecline:   "отказ от места из листа ожидания",
+	models.AuditActionTransfer:          "передача билета",
+	models.AuditActionEdit:              "изменение билета",
+	models.AuditActionUpgrade:           "повышение до ВИП",
+}
+
+// AuditLog is the history of the ticket for an admin, the latest records if there are too many for one message.
+func AuditLog(ticketNo string, records []models.AuditRecord) string {
+	if len(records) == 0 {
+		return fmt.Sprintf("По билету №%s действий не записано", ticketNo)
+	}
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("История билета №%s:\n\n", ticketNo))
+	if len(records) > auditRecordsShown {
+		result.WriteString(fmt.Sprintf("(показаны последние %d из %d)\n", auditRecordsShown, len(records)))
+		records = records[len(records)-auditRecordsShown:]
+	}
+
+	for _, record := range records {
+		action, ok := auditActions[record.Action]
+		if !ok {
+			action = record.Action
+		}
+		actor := "doorctl"
+		if record.ActorName != "" {
+			actor = "@" + record.ActorName
+		}
+
+		result.WriteString(fmt.Sprintf("%s %s — %s", record.CreatedAt.Format("02.01 15:04:05"), actor, action))
+		if record.Details != "" {
+			result.WriteString(fmt.Sprintf(" (%s)", record.Details))
+		}
+		result.WriteString("\n")
+	}
+
+	return strings.TrimSpace(result.String())
+}
+
+// ErrorMessage turns an error returned by the ticket service into a message for staff and buyers.
+func ErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errs.ErrTicketNotFound):
+		return "Не найдено билета с указанным номером"
+	case errors.Is(err, errs.ErrPossibleNamesake):
+		return "Билет на такое ФИО уже продан. Если это другой человек, подтвердите это при продаже"
+	case errors.Is(err, errs.ErrTicketNoTaken):
+		return "Не удалось выдать номер билета: он уже занят. Попробуйте продать билет ещё раз и сообщите администратору"
+	case errors.Is(err, errs.ErrInvalidTicketLink):
+		return "Ссылка на билет недействительна или уже привязана к другому аккаунту"
+	case errors.Is(err, errs.ErrTicketNotRefundable):
+		return "Билет не найден, уже возвращён или гость уже прошёл контроль"
+	case errors.Is(err, errs.ErrImageGeneration):
+		return "Не удалось сгенерировать изображение билета. Попробуйте ещё раз позже"
+	case errors.Is(err, errs.ErrIDCheckRequired):
+		return "Гость может быть младше допустимого возраста. Проверьте документ и подтвердите вход"
+	case errors.Is(err, errs.ErrAlreadyInside):
+		return "Гость уже внутри. Если он выходил, сначала отметьте выход"
+	case errors.Is(err, errs.ErrNotInside):
+		return "Гость сейчас не внутри, выход не отмечен"
+	case errors.Is(err, errs.ErrReentryNotAllowed):
+		return "Повторный вход по этому билету не разрешён"
+	case errors.Is(err, errs.ErrBlacklisted):
+		return "Гость в чёрном списке. Впустить его или продать ему билет можно только с разрешения администратора"
+	case errors.Is(err, errs.ErrOverrideNotAllowed):
+		return "Снять запрет чёрного списка может только администратор"
+	case errors.Is(err, errs.ErrBlacklistNotFound):
+		return "Запись чёрного списка не найдена"
+	case errors.Is(err, errs.ErrTicketNotTransferable):
+		return "Билет не найден, возвращён или по нему уже был вход — передать его нельзя"
+	case errors.Is(err, errs.ErrSoldOut):
+		return "Билеты распроданы. Добавьте покупателя в лист ожидания"
+	case errors.Is(err, errs.ErrTicketNotUpgradable):
+		return "Билет не найден, возвращён или уже не Базовый — повысить его до ВИП нельзя"
+	case errors.Is(err, errs.ErrUpgradeNotAllowed):
+		return "Повысить билет до ВИП может только продавец ВИП-билетов или администратор"
+	case errors.Is(err, errs.ErrWaitlistNotFound):
+		return "Запись листа ожидания не найдена или место уже не держится"
+	case errors.Is(err, errs.ErrCheckingBaseParameters):
+		return "Проверьте введённые данные"
+	default:
+		return "Ошибка при получении данных из базы"
+	}
+}
@@ -0,0 +1,53 @@
+package ticket_repository
+
+import (
+	"context"
+
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+const (
+	addAuditRecord        = "INSERT INTO audit_log (actor_tg_id, actor_username, action, ticket_no, details, before_state, after_state) VALUES (NULLIF($1::bigint, 0), NULLIF($2, ''), $3, NULLIF($4, '')::integer, NULLIF($5, ''), $6, $7)"
+	searchAuditByTicketNo = "SELECT COALESCE(actor_tg_id, 0), COALESCE(actor_username, ''), action, ticket_no, COALESCE(details, ''), before_state, after_state, created_at FROM audit_log WHERE ticket_no = $1 ORDER BY id"
+)
+
+func (tr *TicketsRepo) AddAuditRecord(ctx context.Context, record models.AuditRecord) error {
+	_, err := tr.db.ExecContext(ctx, addAuditRecord, record.ActorId, record.ActorName, record.Action, record.TicketNo, record.Details,
+		nullableJSON(record.Before), nullableJSON(record.After))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tr *TicketsRepo) SearchAuditByTicketNo(ctx context.Context, ticketNo string) ([]models.AuditRecord, error) {
+	rows, err := tr.db.QueryContext(ctx, searchAuditByTicketNo, ticketNo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []models.AuditRecord
+	for rows.Next() {
+		var record models.AuditRecord
+		var before, after []byte
+		err := rows.Scan(&record.ActorId, &record.ActorName, &record.Action, &record.TicketNo, &record.Details, &before, &after, &record.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		record.Before, record.After = before, after
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// nullableJSON stores a missing state as SQL NULL rather than the JSON literal null.
+func nullableJSON(state []byte) interface{} {
+	if len(state) == 0 {
+		return nil
+	}
+
+	return string(state)
+}
@@ -0,0 +1,64 @@
+package ticket_repository
+
+import (
+	"context"
+
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+const (
+	searchBlacklist          = "SELECT id, full_name, COALESCE(photo_file_id, ''), reason, COALESCE(added_by, '') FROM blacklist WHERE removed_at IS NULL ORDER BY id"
+	addToBlacklist           = "INSERT INTO blacklist (full_name, photo_file_id, reason, added_by) VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, '')) RETURNING id"
+	removeFromBlacklist      = "UPDATE blacklist SET removed_at = NOW(), removed_by = NULLIF($2, '') WHERE id = $1 AND removed_at IS NULL RETURNING id"
+	addBlacklistOverride     = "INSERT INTO blacklist_overrides (blacklist_id, ticket_id, action, actor) VALUES ($1, $2, $3, $4)"
+	searchBlacklistOverrides = "SELECT DISTINCT blacklist_id FROM blacklist_overrides WHERE ticket_id = $1 AND action = $2"
+)
+
+func (tr *TicketsRepo) SearchBlacklist(ctx context.Context) ([]models.BlacklistEntry, error) {
+	rows, err := tr.db.QueryContext(ctx, searchBlacklist)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blacklist []models.BlacklistEntry
+	for rows.Next() {
+		var entry models.BlacklistEntry
+		err := rows.Scan(&entry.Id, &entry.FullName, &entry.PhotoFileId, &entry.Reason, &entry.AddedBy)
+		if err != nil {
+			return nil, err
+		}
+		blacklist = append(blacklist, entry)
+	}
+
+	return blacklist, rows.Err()
+}
+
+func (tr *TicketsRepo) AddToBlacklist(ctx context.Context, entry models.BlacklistEntry) (int64, error) {
+	var id int64
+	err := tr.db.QueryRowContext(ctx, addToBlacklist, entry.FullName, entry.PhotoFileId, entry.Reason, entry.AddedBy).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// RemoveFromBlacklist keeps the row, so that the overrides logged against it still make sense.
+func (tr *TicketsRepo) RemoveFromBlacklist(ctx context.Context, id int64, actor string) error {
+	return tr.db.QueryRowContext(ctx, removeFromBlacklist, id, actor).Scan(&id)
+}
+
+func (tr *TicketsRepo) AddBlacklistOverride(ctx context.Context, override models.BlacklistOverride) error {
+	_, err := tr.db.ExecContext(ctx, addBlacklistOverride, override.BlacklistId, override.TicketId, override.Action, override.Actor)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SearchBlacklistOverrides returns the ids of the blacklist entries overridden for the ticket and action.
+func (tr *TicketsRepo) SearchBlacklistOverrides(ctx context.Context, ticketId, action string) ([]int64, error) {
+	return tr.selectIds(ctx, searchBlacklistOverrides, ticketId, action)
+}
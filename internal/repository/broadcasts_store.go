@@ -0,0 +1,139 @@
+package ticket_repository
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+const (
+	upsertBotUser          = "INSERT INTO bot_users (chat_id, username) VALUES ($1, $2) ON CONFLICT (chat_id) DO UPDATE SET username = EXCLUDED.username, updated_at = NOW()"
+	searchChatIdsByUsers   = "SELECT chat_id FROM bot_users WHERE username = ANY($1)"
+	searchBuyersChatIds    = "SELECT DISTINCT l.chat_id FROM ticket_links l JOIN tickets t ON t.ticketno = l.ticket_id WHERE l.chat_id IS NOT NULL AND NOT t.refunded"
+	searchChatIdsByType    = "SELECT DISTINCT l.chat_id FROM ticket_links l JOIN tickets t ON t.ticketno = l.ticket_id WHERE l.chat_id IS NOT NULL AND NOT t.refunded AND LOWER(t.ticket_type) = LOWER($1)"
+	createBroadcast        = "INSERT INTO broadcasts (author_tag, author_chat_id, target, text) VALUES ($1, $2, $3, $4) RETURNING id"
+	createDelivery         = "INSERT INTO broadcast_deliveries (broadcast_id, chat_id) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+	searchBroadcastById    = "SELECT id, author_tag, author_chat_id, target, text FROM broadcasts WHERE id = $1"
+	searchPendingDelivery  = "SELECT chat_id FROM broadcast_deliveries WHERE broadcast_id = $1 AND status = 'pending' ORDER BY chat_id"
+	updateDelivery         = "UPDATE broadcast_deliveries SET status = $3, error = NULLIF($4, ''), sent_at = NOW() WHERE broadcast_id = $1 AND chat_id = $2"
+	finishBroadcast        = "UPDATE broadcasts SET finished_at = NOW() WHERE id = $1"
+	searchUnfinishedIds    = "SELECT id FROM broadcasts WHERE finished_at IS NULL ORDER BY id"
+	searchBroadcastSummary = "SELECT COUNT(*), COUNT(*) FILTER (WHERE status = 'sent'), COUNT(*) FILTER (WHERE status = 'failed') FROM broadcast_deliveries WHERE broadcast_id = $1"
+)
+
+func (tr *TicketsRepo) UpsertBotUser(ctx context.Context, chatId int64, username string) error {
+	_, err := tr.db.ExecContext(ctx, upsertBotUser, chatId, username)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tr *TicketsRepo) SearchChatIdsByUsernames(ctx context.Context, usernames []string) ([]int64, error) {
+	return tr.selectIds(ctx, searchChatIdsByUsers, pq.Array(usernames))
+}
+
+func (tr *TicketsRepo) SearchBuyersChatIds(ctx context.Context) ([]int64, error) {
+	return tr.selectIds(ctx, searchBuyersChatIds)
+}
+
+func (tr *TicketsRepo) SearchChatIdsByTicketType(ctx context.Context, ticketType string) ([]int64, error) {
+	return tr.selectIds(ctx, searchChatIdsByType, ticketType)
+}
+
+func (tr *TicketsRepo) CreateBroadcast(ctx context.Context, broadcast models.Broadcast, chatIds []int64) (int64, error) {
+	tx, err := tr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, createBroadcast, broadcast.AuthorTag, broadcast.AuthorChatId, broadcast.Target, broadcast.Text).Scan(&id)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	for _, chatId := range chatIds {
+		_, err = tx.ExecContext(ctx, createDelivery, id, chatId)
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (tr *TicketsRepo) SearchBroadcastById(ctx context.Context, id int64) (*models.Broadcast, error) {
+	var broadcast models.Broadcast
+	err := tr.db.QueryRowContext(ctx, searchBroadcastById, id).Scan(&broadcast.Id, &broadcast.AuthorTag, &broadcast.AuthorChatId, &broadcast.Target, &broadcast.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &broadcast, nil
+}
+
+func (tr *TicketsRepo) SearchPendingDeliveries(ctx context.Context, broadcastId int64) ([]int64, error) {
+	return tr.selectIds(ctx, searchPendingDelivery, broadcastId)
+}
+
+func (tr *TicketsRepo) UpdateDelivery(ctx context.Context, broadcastId, chatId int64, status, errText string) error {
+	_, err := tr.db.ExecContext(ctx, updateDelivery, broadcastId, chatId, status, errText)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tr *TicketsRepo) FinishBroadcast(ctx context.Context, broadcastId int64) (*models.BroadcastSummary, error) {
+	_, err := tr.db.ExecContext(ctx, finishBroadcast, broadcastId)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := models.BroadcastSummary{Id: broadcastId}
+	err = tr.db.QueryRowContext(ctx, searchBroadcastSummary, broadcastId).Scan(&summary.Total, &summary.Sent, &summary.Failed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+func (tr *TicketsRepo) SearchUnfinishedBroadcasts(ctx context.Context) ([]int64, error) {
+	return tr.selectIds(ctx, searchUnfinishedIds)
+}
+
+func (tr *TicketsRepo) selectIds(ctx context.Context, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
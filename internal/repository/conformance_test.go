@@ -0,0 +1,450 @@
+package ticket_repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	migrator "github.com/qRe0/afterparty-bot/internal/migrations"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	ticket_service "github.com/qRe0/afterparty-bot/internal/service"
+)
+
+// testDatabaseURL points the suite at a Postgres database it may wipe. Without it only MemoryRepo is checked.
+const testDatabaseURL = "TEST_DATABASE_URL"
+
+// conformanceRepo is what both repositories offer to main and to the service.
+type conformanceRepo interface {
+	ticket_service.TicketsRepo
+	ticket_service.BroadcastRepo
+	MoveTicketNoStart(ctx context.Context, start int64) error
+}
+
+var (
+	admin  = models.Actor{ID: 1, UserName: "admin"}
+	seller = models.Actor{ID: 2, UserName: "seller"}
+)
+
+// backends returns a constructor of an empty repository for every backend under test.
+func backends(t testing.TB) map[string]func(testing.TB) conformanceRepo {
+	t.Helper()
+
+	repos := map[string]func(testing.TB) conformanceRepo{
+		"memory": func(testing.TB) conformanceRepo { return NewMemory() },
+	}
+
+	url := os.Getenv(testDatabaseURL)
+	if url == "" {
+		return repos
+	}
+
+	db, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		t.Fatalf("connect to %s: %v", testDatabaseURL, err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repos["postgres"] = func(t testing.TB) conformanceRepo {
+		t.Helper()
+		// audit_log refuses UPDATE, DELETE and TRUNCATE, so the schema is recreated instead of emptied.
+		_, err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public;")
+		if err != nil {
+			t.Fatalf("reset schema: %v", err)
+		}
+		m, err := migrator.New(db)
+		if err != nil {
+			t.Fatalf("migrator.New() error = %v", err)
+		}
+		if err = m.Up(); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+
+		return New(db, configs.DBConfig{})
+	}
+
+	return repos
+}
+
+func sell(t testing.TB, repo conformanceRepo, fio, ticketType string, price int) string {
+	t.Helper()
+
+	client := models.ClientData{FIO: fio, TicketType: ticketType, Price: price, BuyerTag: "@buyer", BuyerPhone: "+375291234567"}
+	ticketNo, err := repo.SellTicket(context.Background(), client, seller.Tag(), strings.Fields(fio)[0], price)
+	if err != nil {
+		t.Fatalf("SellTicket(%q) error = %v", fio, err)
+	}
+
+	return strconv.FormatInt(ticketNo, 10)
+}
+
+func allowAll(models.EntryState) error { return nil }
+
+func setType(ticketType string, actualPrice int) func(models.TicketDetails) (models.TicketDetails, error) {
+	return func(details models.TicketDetails) (models.TicketDetails, error) {
+		details.TicketType = ticketType
+		details.ActualPrice = actualPrice
+		return details, nil
+	}
+}
+
+func TestRepositoryConformance(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo conformanceRepo)
+	}{
+		{
+			name: "ticket numbers start at 1 and follow each other",
+			run: func(t *testing.T, repo conformanceRepo) {
+				for want := 1; want <= 3; want++ {
+					if got := sell(t, repo, "Иванов Иван", "Базовый", 17); got != strconv.Itoa(want) {
+						t.Fatalf("ticket number = %s, want %d", got, want)
+					}
+				}
+			},
+		},
+		{
+			name: "ticket number start moves forward only",
+			run: func(t *testing.T, repo conformanceRepo) {
+				if err := repo.MoveTicketNoStart(ctx, 100); err != nil {
+					t.Fatalf("MoveTicketNoStart(100) error = %v", err)
+				}
+				if got := sell(t, repo, "Иванов Иван", "Базовый", 17); got != "100" {
+					t.Fatalf("ticket number = %s, want 100", got)
+				}
+				if err := repo.MoveTicketNoStart(ctx, 5); err != nil {
+					t.Fatalf("MoveTicketNoStart(5) error = %v", err)
+				}
+				if got := sell(t, repo, "Петров Пётр", "Базовый", 17); got != "101" {
+					t.Fatalf("ticket number = %s, want 101", got)
+				}
+			},
+		},
+		{
+			name: "refunded ticket is hidden and counted apart",
+			run: func(t *testing.T, repo conformanceRepo) {
+				id := sell(t, repo, "Иванов Иван", "Базовый", 17)
+				if _, err := repo.RefundTicket(ctx, id); err != nil {
+					t.Fatalf("RefundTicket() error = %v", err)
+				}
+				if _, err := repo.RefundTicket(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+					t.Fatalf("second RefundTicket() error = %v, want sql.ErrNoRows", err)
+				}
+				if _, err := repo.SearchById(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+					t.Fatalf("SearchById() of a refunded ticket error = %v, want sql.ErrNoRows", err)
+				}
+				stats, err := repo.Stats(ctx)
+				if err != nil {
+					t.Fatalf("Stats() error = %v", err)
+				}
+				if stats.Sold != 0 || stats.Refunded != 1 || stats.Revenue != 0 {
+					t.Fatalf("Stats() = %+v, want 0 sold, 1 refunded, no revenue", stats)
+				}
+			},
+		},
+		{
+			name: "entries log decides whether the guest is inside",
+			run: func(t *testing.T, repo conformanceRepo) {
+				id := sell(t, repo, "Иванов Иван", "Базовый", 17)
+				for _, step := range []struct {
+					direction string
+					inside    bool
+				}{
+					{models.EntryDirectionIn, true},
+					{models.EntryDirectionOut, false},
+					{models.EntryDirectionIn, true},
+				} {
+					resp, err := repo.AddEntry(ctx, models.Entry{TicketId: id, Direction: step.direction, Checker: "@checker"}, allowAll)
+					if err != nil {
+						t.Fatalf("AddEntry(%s) error = %v", step.direction, err)
+					}
+					if resp.PassedControlZone != step.inside {
+						t.Fatalf("after %s inside = %v, want %v", step.direction, resp.PassedControlZone, step.inside)
+					}
+				}
+
+				var seen models.EntryState
+				errRefused := errors.New("refused")
+				_, err := repo.AddEntry(ctx, models.Entry{TicketId: id, Direction: models.EntryDirectionIn, Checker: "@checker"}, func(state models.EntryState) error {
+					seen = state
+					return errRefused
+				})
+				if !errors.Is(err, errRefused) {
+					t.Fatalf("AddEntry() error = %v, want the check's error", err)
+				}
+				if seen != (models.EntryState{Inside: true, Entries: 2}) {
+					t.Fatalf("check got %+v, want inside after 2 entries", seen)
+				}
+				if _, err = repo.RefundTicket(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+					t.Fatalf("RefundTicket() of an entered ticket error = %v, want sql.ErrNoRows", err)
+				}
+			},
+		},
+		{
+			name: "transfer records the previous holder and drops the links",
+			run: func(t *testing.T, repo conformanceRepo) {
+				id := sell(t, repo, "Иванов Иван", "Базовый", 17)
+				ticketNo, _ := strconv.ParseInt(id, 10, 64)
+				if err := repo.CreateTicketLink(ctx, ticketNo, "token", "", 0); err != nil {
+					t.Fatalf("CreateTicketLink() error = %v", err)
+				}
+
+				previous, err := repo.TransferTicket(ctx, models.TicketTransfer{TicketId: id, FullName: "Петров Пётр"}, "Петров", admin)
+				if err != nil {
+					t.Fatalf("TransferTicket() error = %v", err)
+				}
+				if previous != "Иванов Иван" {
+					t.Fatalf("TransferTicket() previous = %q, want Иванов Иван", previous)
+				}
+				if _, err = repo.BindTicketLink(ctx, "token", 100, 100, "@buyer"); !errors.Is(err, sql.ErrNoRows) {
+					t.Fatalf("BindTicketLink() after transfer error = %v, want sql.ErrNoRows", err)
+				}
+
+				history, err := repo.SearchTicketHistory(ctx, id)
+				if err != nil {
+					t.Fatalf("SearchTicketHistory() error = %v", err)
+				}
+				if len(history) != 1 {
+					t.Fatalf("SearchTicketHistory() = %+v, want one record", history)
+				}
+				got := history[0]
+				if got.Action != models.TicketHistoryTransfer || got.FullNameBefore != "Иванов Иван" || got.FullNameAfter != "Петров Пётр" ||
+					got.BuyerTagBefore != "@buyer" || got.BuyerPhoneBefore != "+375291234567" || got.ActorTag != admin.Tag() || got.ActorId != admin.ID {
+					t.Fatalf("transfer record = %+v", got)
+				}
+			},
+		},
+		{
+			name: "entered ticket cannot be transferred",
+			run: func(t *testing.T, repo conformanceRepo) {
+				id := sell(t, repo, "Иванов Иван", "Базовый", 17)
+				if _, err := repo.AddEntry(ctx, models.Entry{TicketId: id, Direction: models.EntryDirectionIn, Checker: "@checker"}, allowAll); err != nil {
+					t.Fatalf("AddEntry() error = %v", err)
+				}
+				_, err := repo.TransferTicket(ctx, models.TicketTransfer{TicketId: id, FullName: "Петров Пётр"}, "Петров", admin)
+				if !errors.Is(err, sql.ErrNoRows) {
+					t.Fatalf("TransferTicket() error = %v, want sql.ErrNoRows", err)
+				}
+			},
+		},
+		{
+			name: "edit records the details before and after",
+			run: func(t *testing.T, repo conformanceRepo) {
+				id := sell(t, repo, "Иванов Иван", "Базовый", 17)
+				_, _, err := repo.EditTicket(ctx, id, func(details models.TicketDetails) (models.TicketDetails, error) {
+					details.FullName, details.Surname, details.Price = "Иванов Иван Иванович", "Иванов", 15
+					return details, nil
+				}, admin)
+				if err != nil {
+					t.Fatalf("EditTicket() error = %v", err)
+				}
+
+				history, err := repo.SearchTicketHistory(ctx, id)
+				if err != nil {
+					t.Fatalf("SearchTicketHistory() error = %v", err)
+				}
+				if len(history) != 1 {
+					t.Fatalf("SearchTicketHistory() = %+v, want one record", history)
+				}
+				got := history[0]
+				if got.Action != models.TicketHistoryEdit || got.FullNameBefore != "Иванов Иван" || got.FullNameAfter != "Иванов Иван Иванович" ||
+					got.PriceBefore != 17 || got.PriceAfter != 15 || got.ActorTag != admin.Tag() {
+					t.Fatalf("edit record = %+v", got)
+				}
+				payments, err := repo.SearchPayments(ctx, id)
+				if err != nil {
+					t.Fatalf("SearchPayments() error = %v", err)
+				}
+				if len(payments) != 0 {
+					t.Fatalf("SearchPayments() after an edit = %+v, want none", payments)
+				}
+			},
+		},
+		{
+			name: "upgrade records a surcharge paid to the seller",
+			run: func(t *testing.T, repo conformanceRepo) {
+				id := sell(t, repo, "Иванов Иван", "Базовый", 17)
+				_, after, err := repo.UpgradeTicket(ctx, id, setType("VIP", 50), seller)
+				if err != nil {
+					t.Fatalf("UpgradeTicket() error = %v", err)
+				}
+				if after.TicketType != "VIP" || after.ActualPrice != 50 {
+					t.Fatalf("UpgradeTicket() after = %+v", after)
+				}
+
+				history, err := repo.SearchTicketHistory(ctx, id)
+				if err != nil {
+					t.Fatalf("SearchTicketHistory() error = %v", err)
+				}
+				if len(history) != 1 || history[0].Action != models.TicketHistoryUpgrade || history[0].PriceDifference != 33 {
+					t.Fatalf("SearchTicketHistory() = %+v, want one upgrade by 33", history)
+				}
+				payments, err := repo.SearchPayments(ctx, id)
+				if err != nil {
+					t.Fatalf("SearchPayments() error = %v", err)
+				}
+				if len(payments) != 1 {
+					t.Fatalf("SearchPayments() = %+v, want one payment", payments)
+				}
+				got := payments[0]
+				if got.Kind != models.PaymentKindSurcharge || got.Amount != 33 || got.SellerTag != seller.Tag() || got.SellerId != seller.ID {
+					t.Fatalf("payment = %+v", got)
+				}
+
+				stats, err := repo.Stats(ctx)
+				if err != nil {
+					t.Fatalf("Stats() error = %v", err)
+				}
+				if stats.Revenue != 17+33 {
+					t.Fatalf("Stats().Revenue = %d, want the price and the surcharge", stats.Revenue)
+				}
+			},
+		},
+		{
+			name: "upgrade without a price rise takes no payment",
+			run: func(t *testing.T, repo conformanceRepo) {
+				id := sell(t, repo, "Иванов Иван", "Базовый", 17)
+				if _, _, err := repo.UpgradeTicket(ctx, id, setType("VIP", 17), seller); err != nil {
+					t.Fatalf("UpgradeTicket() error = %v", err)
+				}
+				payments, err := repo.SearchPayments(ctx, id)
+				if err != nil {
+					t.Fatalf("SearchPayments() error = %v", err)
+				}
+				if len(payments) != 0 {
+					t.Fatalf("SearchPayments() = %+v, want none", payments)
+				}
+			},
+		},
+		{
+			name: "broadcasts skip buyers of refunded tickets",
+			run: func(t *testing.T, repo conformanceRepo) {
+				kept := sell(t, repo, "Иванов Иван", "Базовый", 17)
+				refunded := sell(t, repo, "Петров Пётр", "Базовый", 17)
+				for chatId, id := range map[int64]string{100: kept, 200: refunded} {
+					ticketNo, _ := strconv.ParseInt(id, 10, 64)
+					token := "token" + id
+					if err := repo.CreateTicketLink(ctx, ticketNo, token, "", 0); err != nil {
+						t.Fatalf("CreateTicketLink() error = %v", err)
+					}
+					if _, err := repo.BindTicketLink(ctx, token, chatId, chatId, "@buyer"); err != nil {
+						t.Fatalf("BindTicketLink() error = %v", err)
+					}
+				}
+				if _, err := repo.RefundTicket(ctx, refunded); err != nil {
+					t.Fatalf("RefundTicket() error = %v", err)
+				}
+
+				buyers, err := repo.SearchBuyersChatIds(ctx)
+				if err != nil {
+					t.Fatalf("SearchBuyersChatIds() error = %v", err)
+				}
+				if len(buyers) != 1 || buyers[0] != 100 {
+					t.Fatalf("SearchBuyersChatIds() = %v, want [100]", buyers)
+				}
+				byType, err := repo.SearchChatIdsByTicketType(ctx, "базовый")
+				if err != nil {
+					t.Fatalf("SearchChatIdsByTicketType() error = %v", err)
+				}
+				if len(byType) != 1 || byType[0] != 100 {
+					t.Fatalf("SearchChatIdsByTicketType() = %v, want [100]", byType)
+				}
+			},
+		},
+		{
+			name: "search by name finds the surname only",
+			run: func(t *testing.T, repo conformanceRepo) {
+				sell(t, repo, "Иванов Иван", "Базовый", 17)
+				sell(t, repo, "Петров Пётр", "Базовый", 17)
+
+				found, err := repo.SearchByName(ctx, []string{"иванов"}, 10)
+				if err != nil {
+					t.Fatalf("SearchByName() error = %v", err)
+				}
+				if len(found) != 1 || found[0].Name != "Иванов Иван" {
+					t.Fatalf("SearchByName(иванов) = %+v, want Иванов Иван only", found)
+				}
+			},
+		},
+	}
+
+	for name, newRepo := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.run(t, newRepo(t))
+				})
+			}
+		})
+	}
+}
+
+// TestParallelSales sells from many goroutines at once, like several sellers and bot instances do:
+// every sale gets its own number and the numbers have no gaps.
+func TestParallelSales(t *testing.T) {
+	const sales = 50
+
+	for name, newRepo := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo(t)
+
+			var (
+				wg       sync.WaitGroup
+				numbers  = make([]int64, sales)
+				failures = make([]error, sales)
+			)
+			for i := 0; i < sales; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					client := models.ClientData{FIO: "Покупатель " + strconv.Itoa(i), TicketType: "Базовый", Price: 17}
+					numbers[i], failures[i] = repo.SellTicket(context.Background(), client, seller.Tag(), "покупатель", 17)
+				}()
+			}
+			wg.Wait()
+
+			seen := make(map[int64]bool, sales)
+			for i, ticketNo := range numbers {
+				if failures[i] != nil {
+					t.Fatalf("SellTicket() #%d error = %v", i, failures[i])
+				}
+				if ticketNo < 1 || ticketNo > sales || seen[ticketNo] {
+					t.Fatalf("ticket numbers = %v, want each of 1..%d once", numbers, sales)
+				}
+				seen[ticketNo] = true
+			}
+		})
+	}
+}
+
+// TestTakenTicketNumber moves the Postgres sequence back over a sold ticket: the sale fails on
+// tickets_ticketno_key, and the service reports it as a taken number, not as a duplicate buyer.
+func TestTakenTicketNumber(t *testing.T) {
+	newRepo, ok := backends(t)["postgres"]
+	if !ok {
+		t.Skipf("%s is not set", testDatabaseURL)
+	}
+	repo := newRepo(t).(*TicketsRepo)
+	ctx := context.Background()
+
+	sell(t, repo, "Иванов Иван", "Базовый", 17)
+	if _, err := repo.db.ExecContext(ctx, "SELECT setval('tickets_ticketno_seq', 1, false)"); err != nil {
+		t.Fatalf("move the sequence back: %v", err)
+	}
+
+	ts := ticket_service.New(repo, configs.Config{})
+	_, err := ts.SellTicket(ctx, seller, models.ClientData{FIO: "Петров Пётр", TicketType: "Базовый", Price: 17})
+	if !errors.Is(err, errs.ErrTicketNoTaken) {
+		t.Fatalf("SellTicket() error = %v, want ErrTicketNoTaken", err)
+	}
+}
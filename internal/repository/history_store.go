@@ -0,0 +1,141 @@
+package ticket_repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+const (
+	// lockTransferable locks a ticket that may change hands: not refunded and never used to enter.
+	lockTransferable  = "SELECT t.ticketno, t.full_name, COALESCE(t.buyer_tag, ''), COALESCE(t.buyer_phone, '') FROM tickets t WHERE t.ticketno = $1 AND NOT t.refunded AND NOT EXISTS (SELECT 1 FROM entries e WHERE e.ticket_id = t.ticketno) FOR UPDATE"
+	transferTicket    = "UPDATE tickets SET full_name = $2, surname = $3, buyer_tag = NULL, buyer_phone = NULL, buyer_email = NULL, buyer_birth_date = NULL, marketing_consent = FALSE WHERE ticketno = $1"
+	unlinkTicket      = "DELETE FROM ticket_links WHERE ticket_id = $1"
+	addTransferRecord = "INSERT INTO ticket_history (ticket_id, action, full_name_before, full_name_after, buyer_tag_before, buyer_phone_before, actor_tag, actor_tg_id) VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, NULLIF($8::bigint, 0))"
+
+	searchTicketDetails = "SELECT ticketno, full_name, COALESCE(surname, ''), ticket_type, COALESCE(ticket_price, 0), COALESCE(actual_ticket_price, 0), COALESCE(seller_name, '') FROM tickets WHERE ticketno = $1 AND NOT refunded"
+	lockEditable        = searchTicketDetails + " FOR UPDATE"
+	editTicket          = "UPDATE tickets SET full_name = $2, surname = $3, ticket_type = $4, ticket_price = $5, actual_ticket_price = $6 WHERE ticketno = $1"
+	addEditRecord       = "INSERT INTO ticket_history (ticket_id, action, full_name_before, full_name_after, ticket_type_before, ticket_type_after, price_before, price_after, actual_price_before, actual_price_after, price_difference, actor_tag, actor_tg_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13::bigint, 0))"
+
+	searchTicketHistory = "SELECT action, full_name_before, full_name_after, COALESCE(buyer_tag_before, ''), COALESCE(buyer_phone_before, ''), COALESCE(ticket_type_before, ''), COALESCE(ticket_type_after, ''), COALESCE(price_before, 0), COALESCE(price_after, 0), COALESCE(actual_price_before, 0), COALESCE(actual_price_after, 0), COALESCE(price_difference, 0), actor_tag, COALESCE(actor_tg_id, 0), created_at FROM ticket_history WHERE ticket_id = $1 ORDER BY id"
+)
+
+// TransferTicket gives the ticket to the new holder and returns the previous holder's name.
+// The previous holder's contacts and bot links go with them, and are kept in ticket_history.
+func (tr *TicketsRepo) TransferTicket(ctx context.Context, transfer models.TicketTransfer, surname string, actor models.Actor) (string, error) {
+	tx, err := tr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		ticketNo                 int64
+		previousName, tag, phone string
+	)
+	err = tx.QueryRowContext(ctx, lockTransferable, transfer.TicketId).Scan(&ticketNo, &previousName, &tag, &phone)
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, transferTicket, ticketNo, transfer.FullName, surname)
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, unlinkTicket, ticketNo)
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, addTransferRecord, ticketNo, models.TicketHistoryTransfer, previousName, transfer.FullName, tag, phone, actor.Tag(), actor.ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return previousName, nil
+}
+
+// EditTicket applies edit to the current details of the ticket and writes the change to ticket_history.
+// The ticket row stays locked until commit, so concurrent edits see each other's result.
+func (tr *TicketsRepo) EditTicket(ctx context.Context, ticketId string, edit func(models.TicketDetails) (models.TicketDetails, error), actor models.Actor) (*models.TicketDetails, *models.TicketDetails, error) {
+	tx, err := tr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, before, after, err := changeTicket(ctx, tx, ticketId, models.TicketHistoryEdit, edit, actor)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return before, after, nil
+}
+
+// changeTicket locks the ticket, applies change to its details and records it in ticket_history under action.
+func changeTicket(ctx context.Context, tx *sql.Tx, ticketId, action string, change func(models.TicketDetails) (models.TicketDetails, error), actor models.Actor) (int64, *models.TicketDetails, *models.TicketDetails, error) {
+	var (
+		ticketNo int64
+		before   models.TicketDetails
+	)
+	err := tx.QueryRowContext(ctx, lockEditable, ticketId).Scan(&ticketNo, &before.FullName, &before.Surname, &before.TicketType, &before.Price, &before.ActualPrice, &before.Seller)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	after, err := change(before)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, editTicket, ticketNo, after.FullName, after.Surname, after.TicketType, after.Price, after.ActualPrice)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, addEditRecord, ticketNo, action, before.FullName, after.FullName, before.TicketType, after.TicketType,
+		before.Price, after.Price, before.ActualPrice, after.ActualPrice, after.ActualPrice-before.ActualPrice, actor.Tag(), actor.ID)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	return ticketNo, &before, &after, nil
+}
+
+func (tr *TicketsRepo) SearchTicketHistory(ctx context.Context, ticketId string) ([]models.TicketHistoryRecord, error) {
+	rows, err := tr.db.QueryContext(ctx, searchTicketHistory, ticketId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []models.TicketHistoryRecord
+	for rows.Next() {
+		var record models.TicketHistoryRecord
+		err := rows.Scan(&record.Action, &record.FullNameBefore, &record.FullNameAfter, &record.BuyerTagBefore, &record.BuyerPhoneBefore,
+			&record.TicketTypeBefore, &record.TicketTypeAfter, &record.PriceBefore, &record.PriceAfter, &record.ActualPriceBefore,
+			&record.ActualPriceAfter, &record.PriceDifference, &record.ActorTag, &record.ActorId, &record.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,930 @@
+package ticket_repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+)
+
+// minNameScore mirrors the default pg_trgm similarity threshold used by the % operators.
+const minNameScore = 0.3
+
+type memoryTicket struct {
+	models.TicketResponse
+	email       string
+	consent     bool
+	ticketNo    int64
+	seller      string
+	price       int
+	actualPrice int
+	refunded    bool
+}
+
+type memoryHistoryRecord struct {
+	models.TicketHistoryRecord
+	ticketNo int64
+}
+
+type memoryPayment struct {
+	models.Payment
+	ticketNo int64
+}
+
+type memoryLink struct {
+	ticketNo  int64
+	buyerTag  string
+	buyerTgId int64
+	chatId    int64
+}
+
+type memoryBroadcast struct {
+	models.Broadcast
+	deliveries map[int64]string
+	finished   bool
+}
+
+type memoryBlacklistEntry struct {
+	models.BlacklistEntry
+	removed bool
+}
+
+// MemoryRepo keeps everything in process memory with the same semantics as the Postgres
+// repository: ticket numbers from a counter, refunded tickets hidden from lookups and the
+// entry status kept in step with the entries log. It backs the demo mode, where the bot
+// runs without a database.
+type MemoryRepo struct {
+	mu sync.Mutex
+
+	tickets      []*memoryTicket
+	nextTicketNo int64
+	entries      []models.Entry
+	zoneScans    []models.ZoneScan
+	blacklist    []memoryBlacklistEntry
+	overrides    []models.BlacklistOverride
+	audit        []models.AuditRecord
+	history      []memoryHistoryRecord
+	payments     []memoryPayment
+	waitlist     []models.WaitlistEntry
+	links        map[string]*memoryLink
+	botUsers     map[int64]string
+	broadcasts   map[int64]*memoryBroadcast
+	lastCastId   int64
+}
+
+func NewMemory() *MemoryRepo {
+	return &MemoryRepo{
+		nextTicketNo: 1,
+		links:        make(map[string]*memoryLink),
+		botUsers:     make(map[int64]string),
+		broadcasts:   make(map[int64]*memoryBroadcast),
+	}
+}
+
+func (mr *MemoryRepo) MoveTicketNoStart(ctx context.Context, start int64) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.nextTicketNo = max(mr.nextTicketNo, start)
+	return nil
+}
+
+func (mr *MemoryRepo) SearchByName(ctx context.Context, terms []string, limit int) ([]models.TicketResponse, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var users []models.TicketResponse
+	for _, ticket := range mr.tickets {
+		if ticket.refunded {
+			continue
+		}
+
+		user := ticket.TicketResponse
+		user.Score = utils.NameMatchScore(terms, user.Surname, user.Name)
+		if user.Score >= minNameScore {
+			users = append(users, user)
+		}
+	}
+
+	sort.SliceStable(users, func(i, j int) bool { return users[i].Score > users[j].Score })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+
+	return users, nil
+}
+
+func (mr *MemoryRepo) AddEntry(ctx context.Context, entry models.Entry, check func(models.EntryState) error) (*models.TicketResponse, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticket, err := mr.activeTicket(entry.TicketId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = check(mr.entryState(ticket.Id))
+	if err != nil {
+		return nil, err
+	}
+
+	entry.TicketId = ticket.Id
+	mr.entries = append(mr.entries, entry)
+	ticket.PassedControlZone = mr.entryState(ticket.Id).Inside
+
+	return ticket.response(), nil
+}
+
+func (mr *MemoryRepo) CheckCountOfSurnames(ctx context.Context, surname string) (int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var count int64
+	for _, ticket := range mr.tickets {
+		if ticket.Surname == surname {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (mr *MemoryRepo) SearchById(ctx context.Context, id string) (*models.TicketResponse, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticket, err := mr.activeTicket(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ticket.response(), nil
+}
+
+func (mr *MemoryRepo) SellTicket(ctx context.Context, client models.ClientData, seller string, clientSurname string, actualPrice int) (int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticketNo := mr.nextTicketNo
+	mr.nextTicketNo++
+	mr.tickets = append(mr.tickets, &memoryTicket{
+		TicketResponse: models.TicketResponse{
+			Id:         strconv.FormatInt(ticketNo, 10),
+			Name:       client.FIO,
+			Surname:    clientSurname,
+			TicketType: client.TicketType,
+			BuyerTag:   client.BuyerTag,
+			BuyerPhone: client.BuyerPhone,
+			BirthDate:  client.BuyerBirthDate,
+		},
+		email:       client.BuyerEmail,
+		consent:     client.MarketingConsent,
+		ticketNo:    ticketNo,
+		seller:      seller,
+		price:       client.Price,
+		actualPrice: actualPrice,
+	})
+
+	return ticketNo, nil
+}
+
+func (mr *MemoryRepo) SearchByFullName(ctx context.Context, normalizedName string) ([]models.TicketResponse, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var tickets []models.TicketResponse
+	for _, ticket := range mr.tickets {
+		if !ticket.refunded && utils.NormalizeName(ticket.Name) == normalizedName {
+			tickets = append(tickets, *ticket.response())
+		}
+	}
+
+	return tickets, nil
+}
+
+// UpdateSellersTable only checks the ticket: the seller is already stored with it.
+func (mr *MemoryRepo) UpdateSellersTable(ctx context.Context, ticketId, sellerId int64, seller string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	_, err := mr.ticketByNo(ticketId)
+	return err
+}
+
+// AddReissueRecord only checks the ticket: reissues are not reported anywhere yet.
+func (mr *MemoryRepo) AddReissueRecord(ctx context.Context, ticketId, actorId int64, actor string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	_, err := mr.ticketByNo(ticketId)
+	return err
+}
+
+func (mr *MemoryRepo) TransferTicket(ctx context.Context, transfer models.TicketTransfer, surname string, actor models.Actor) (string, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticket, err := mr.activeTicket(transfer.TicketId)
+	if err != nil {
+		return "", err
+	}
+	if mr.entryState(ticket.Id).Entries > 0 {
+		return "", sql.ErrNoRows
+	}
+
+	previousName := ticket.Name
+	mr.history = append(mr.history, memoryHistoryRecord{
+		TicketHistoryRecord: models.TicketHistoryRecord{
+			Action:           models.TicketHistoryTransfer,
+			FullNameBefore:   previousName,
+			FullNameAfter:    transfer.FullName,
+			BuyerTagBefore:   ticket.BuyerTag,
+			BuyerPhoneBefore: ticket.BuyerPhone,
+			ActorTag:         actor.Tag(),
+			ActorId:          actor.ID,
+			CreatedAt:        time.Now(),
+		},
+		ticketNo: ticket.ticketNo,
+	})
+	ticket.Name = transfer.FullName
+	ticket.Surname = surname
+	ticket.BuyerTag, ticket.BuyerPhone, ticket.BirthDate = "", "", ""
+	ticket.email, ticket.consent = "", false
+	for token, link := range mr.links {
+		if link.ticketNo == ticket.ticketNo {
+			delete(mr.links, token)
+		}
+	}
+
+	return previousName, nil
+}
+
+func (mr *MemoryRepo) EditTicket(ctx context.Context, ticketId string, edit func(models.TicketDetails) (models.TicketDetails, error), actor models.Actor) (*models.TicketDetails, *models.TicketDetails, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticket, err := mr.activeTicket(ticketId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return mr.changeTicket(ticket, models.TicketHistoryEdit, edit, actor)
+}
+
+func (mr *MemoryRepo) SearchTicketDetails(ctx context.Context, ticketId string) (*models.TicketDetails, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticket, err := mr.activeTicket(ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	details := ticketDetails(ticket)
+	return &details, nil
+}
+
+// SearchTicketHistory, like the query on ticket_history, finds refunded tickets too.
+func (mr *MemoryRepo) SearchTicketHistory(ctx context.Context, ticketId string) ([]models.TicketHistoryRecord, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticketNo, err := strconv.ParseInt(ticketId, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ticket number %q: %w", ticketId, err)
+	}
+
+	var records []models.TicketHistoryRecord
+	for _, record := range mr.history {
+		if record.ticketNo == ticketNo {
+			records = append(records, record.TicketHistoryRecord)
+		}
+	}
+
+	return records, nil
+}
+
+func (mr *MemoryRepo) SearchPayments(ctx context.Context, ticketId string) ([]models.Payment, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticketNo, err := strconv.ParseInt(ticketId, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ticket number %q: %w", ticketId, err)
+	}
+
+	var payments []models.Payment
+	for _, payment := range mr.payments {
+		if payment.ticketNo == ticketNo {
+			payments = append(payments, payment.Payment)
+		}
+	}
+
+	return payments, nil
+}
+
+func (mr *MemoryRepo) UpgradeTicket(ctx context.Context, ticketId string, upgrade func(models.TicketDetails) (models.TicketDetails, error), seller models.Actor) (*models.TicketDetails, *models.TicketDetails, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticket, err := mr.activeTicket(ticketId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	before, after, err := mr.changeTicket(ticket, models.TicketHistoryUpgrade, upgrade, seller)
+	if err != nil {
+		return nil, nil, err
+	}
+	if amount := after.ActualPrice - before.ActualPrice; amount > 0 {
+		mr.payments = append(mr.payments, memoryPayment{
+			Payment: models.Payment{
+				Kind:      models.PaymentKindSurcharge,
+				Amount:    amount,
+				SellerTag: seller.Tag(),
+				SellerId:  seller.ID,
+				CreatedAt: time.Now(),
+			},
+			ticketNo: ticket.ticketNo,
+		})
+	}
+
+	return before, after, nil
+}
+
+func (mr *MemoryRepo) CreateTicketLink(ctx context.Context, ticketId int64, token, buyerTag string, buyerTgId int64) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if _, err := mr.ticketByNo(ticketId); err != nil {
+		return err
+	}
+	if _, ok := mr.links[token]; ok {
+		return &pq.Error{Code: "23505", Table: "ticket_links", Constraint: "ticket_links_token_key"}
+	}
+
+	mr.links[token] = &memoryLink{ticketNo: ticketId, buyerTag: buyerTag, buyerTgId: buyerTgId}
+	return nil
+}
+
+func (mr *MemoryRepo) BindTicketLink(ctx context.Context, token string, chatId, userId int64, userTag string) (*models.TicketResponse, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	link, ok := mr.links[token]
+	if !ok ||
+		(link.chatId != 0 && link.chatId != chatId) ||
+		(link.buyerTgId != 0 && link.buyerTgId != userId) ||
+		(link.buyerTag != "" && !strings.EqualFold(link.buyerTag, userTag)) {
+		return nil, sql.ErrNoRows
+	}
+	link.chatId = chatId
+	link.buyerTgId = userId
+
+	ticket, err := mr.ticketByNo(link.ticketNo)
+	if err != nil || ticket.refunded {
+		return nil, sql.ErrNoRows
+	}
+
+	return ticket.response(), nil
+}
+
+func (mr *MemoryRepo) SearchByChatId(ctx context.Context, chatId int64) ([]models.TicketResponse, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	linked := make(map[int64]bool)
+	for _, link := range mr.links {
+		if link.chatId == chatId {
+			linked[link.ticketNo] = true
+		}
+	}
+
+	var tickets []models.TicketResponse
+	for _, ticket := range mr.tickets {
+		if linked[ticket.ticketNo] && !ticket.refunded {
+			tickets = append(tickets, *ticket.response())
+		}
+	}
+
+	return tickets, nil
+}
+
+func (mr *MemoryRepo) SearchAllTickets(ctx context.Context) ([]models.TicketResponse, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var tickets []models.TicketResponse
+	for _, ticket := range mr.tickets {
+		if !ticket.refunded {
+			tickets = append(tickets, ticket.TicketResponse)
+		}
+	}
+
+	return tickets, nil
+}
+
+func (mr *MemoryRepo) SearchContacts(ctx context.Context) ([]models.BuyerContact, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var contacts []models.BuyerContact
+	for _, ticket := range mr.tickets {
+		if ticket.refunded || (ticket.BuyerTag == "" && ticket.BuyerPhone == "" && ticket.email == "") {
+			continue
+		}
+		contacts = append(contacts, models.BuyerContact{
+			TicketNo:         ticket.Id,
+			FullName:         ticket.Name,
+			Tag:              ticket.BuyerTag,
+			Phone:            ticket.BuyerPhone,
+			Email:            ticket.email,
+			MarketingConsent: ticket.consent,
+		})
+	}
+
+	return contacts, nil
+}
+
+func (mr *MemoryRepo) RefundTicket(ctx context.Context, id string) (*models.TicketResponse, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	ticket, err := mr.activeTicket(id)
+	if err != nil {
+		return nil, err
+	}
+	if mr.entryState(ticket.Id).Entries > 0 {
+		return nil, sql.ErrNoRows
+	}
+	ticket.refunded = true
+
+	return ticket.response(), nil
+}
+
+func (mr *MemoryRepo) Stats(ctx context.Context) (*models.Stats, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	stats := models.Stats{ByType: make(map[string]int)}
+	for _, ticket := range mr.tickets {
+		if ticket.refunded {
+			stats.Refunded++
+			continue
+		}
+
+		stats.Sold++
+		stats.Revenue += ticket.price + mr.paidSurcharges(ticket.ticketNo)
+		stats.ByType[ticket.TicketType]++
+		state := mr.entryState(ticket.Id)
+		if state.Entries > 0 {
+			stats.Entered++
+		}
+		if state.Inside {
+			stats.Inside++
+		}
+	}
+
+	return &stats, nil
+}
+
+func (mr *MemoryRepo) SearchBlacklist(ctx context.Context) ([]models.BlacklistEntry, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var blacklist []models.BlacklistEntry
+	for _, entry := range mr.blacklist {
+		if !entry.removed {
+			blacklist = append(blacklist, entry.BlacklistEntry)
+		}
+	}
+
+	return blacklist, nil
+}
+
+func (mr *MemoryRepo) AddToBlacklist(ctx context.Context, entry models.BlacklistEntry) (int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	entry.Id = int64(len(mr.blacklist) + 1)
+	mr.blacklist = append(mr.blacklist, memoryBlacklistEntry{BlacklistEntry: entry})
+	return entry.Id, nil
+}
+
+func (mr *MemoryRepo) RemoveFromBlacklist(ctx context.Context, id int64, actor string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	for i := range mr.blacklist {
+		if mr.blacklist[i].Id == id && !mr.blacklist[i].removed {
+			mr.blacklist[i].removed = true
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (mr *MemoryRepo) AddBlacklistOverride(ctx context.Context, override models.BlacklistOverride) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.overrides = append(mr.overrides, override)
+	return nil
+}
+
+func (mr *MemoryRepo) SearchBlacklistOverrides(ctx context.Context, ticketId, action string) ([]int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var ids []int64
+	for _, override := range mr.overrides {
+		if override.TicketId == ticketId && override.Action == action {
+			ids = append(ids, override.BlacklistId)
+		}
+	}
+
+	return ids, nil
+}
+
+func (mr *MemoryRepo) AddAuditRecord(ctx context.Context, record models.AuditRecord) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	record.CreatedAt = time.Now()
+	mr.audit = append(mr.audit, record)
+	return nil
+}
+
+func (mr *MemoryRepo) SearchAuditByTicketNo(ctx context.Context, ticketNo string) ([]models.AuditRecord, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var records []models.AuditRecord
+	for _, record := range mr.audit {
+		if record.TicketNo == ticketNo {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+func (mr *MemoryRepo) AddToWaitlist(ctx context.Context, entry models.WaitlistEntry) (int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	entry.Id = int64(len(mr.waitlist) + 1)
+	entry.Status = models.WaitlistStatusWaiting
+	mr.waitlist = append(mr.waitlist, entry)
+	return entry.Id, nil
+}
+
+func (mr *MemoryRepo) SearchWaitlist(ctx context.Context) ([]models.WaitlistEntry, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var entries []models.WaitlistEntry
+	for _, entry := range mr.waitlist {
+		if entry.Status == models.WaitlistStatusWaiting || entry.Status == models.WaitlistStatusOffered {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (mr *MemoryRepo) OfferNextWaitlisted(ctx context.Context, until time.Time) (*models.WaitlistEntry, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	for i := range mr.waitlist {
+		if mr.waitlist[i].Status == models.WaitlistStatusWaiting {
+			mr.waitlist[i].Status = models.WaitlistStatusOffered
+			mr.waitlist[i].OfferedUntil = &until
+			entry := mr.waitlist[i]
+			return &entry, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (mr *MemoryRepo) ExpireWaitlistOffers(ctx context.Context, now time.Time) ([]models.WaitlistEntry, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var expired []models.WaitlistEntry
+	for i := range mr.waitlist {
+		if mr.waitlist[i].Status == models.WaitlistStatusOffered && !mr.waitlist[i].OfferedUntil.After(now) {
+			mr.waitlist[i].Status = models.WaitlistStatusExpired
+			expired = append(expired, mr.waitlist[i])
+		}
+	}
+
+	return expired, nil
+}
+
+func (mr *MemoryRepo) UpdateWaitlistStatus(ctx context.Context, id int64, from []string, to string) (*models.WaitlistEntry, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	for i := range mr.waitlist {
+		if mr.waitlist[i].Id == id && slices.Contains(from, mr.waitlist[i].Status) {
+			mr.waitlist[i].Status = to
+			entry := mr.waitlist[i]
+			return &entry, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// AddZoneScan keeps the scan only to mirror the Postgres log: scans are not reported anywhere yet.
+func (mr *MemoryRepo) AddZoneScan(ctx context.Context, scan models.ZoneScan, checker string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.zoneScans = append(mr.zoneScans, scan)
+	return nil
+}
+
+func (mr *MemoryRepo) UpsertBotUser(ctx context.Context, chatId int64, username string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.botUsers[chatId] = username
+	return nil
+}
+
+func (mr *MemoryRepo) SearchChatIdsByUsernames(ctx context.Context, usernames []string) ([]int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	wanted := make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		wanted[username] = true
+	}
+
+	var chatIds []int64
+	for chatId, username := range mr.botUsers {
+		if wanted[username] {
+			chatIds = append(chatIds, chatId)
+		}
+	}
+
+	return sortedIds(chatIds), nil
+}
+
+func (mr *MemoryRepo) SearchBuyersChatIds(ctx context.Context) ([]int64, error) {
+	return mr.linkedChatIds(func(*memoryTicket) bool { return true }), nil
+}
+
+func (mr *MemoryRepo) SearchChatIdsByTicketType(ctx context.Context, ticketType string) ([]int64, error) {
+	return mr.linkedChatIds(func(ticket *memoryTicket) bool {
+		return strings.EqualFold(ticket.TicketType, ticketType)
+	}), nil
+}
+
+func (mr *MemoryRepo) CreateBroadcast(ctx context.Context, broadcast models.Broadcast, chatIds []int64) (int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.lastCastId++
+	broadcast.Id = mr.lastCastId
+
+	stored := &memoryBroadcast{Broadcast: broadcast, deliveries: make(map[int64]string)}
+	for _, chatId := range chatIds {
+		stored.deliveries[chatId] = models.DeliveryStatusPending
+	}
+	mr.broadcasts[broadcast.Id] = stored
+
+	return broadcast.Id, nil
+}
+
+func (mr *MemoryRepo) SearchBroadcastById(ctx context.Context, id int64) (*models.Broadcast, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	broadcast, ok := mr.broadcasts[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	resp := broadcast.Broadcast
+	return &resp, nil
+}
+
+func (mr *MemoryRepo) SearchPendingDeliveries(ctx context.Context, broadcastId int64) ([]int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var chatIds []int64
+	if broadcast, ok := mr.broadcasts[broadcastId]; ok {
+		for chatId, status := range broadcast.deliveries {
+			if status == models.DeliveryStatusPending {
+				chatIds = append(chatIds, chatId)
+			}
+		}
+	}
+
+	return sortedIds(chatIds), nil
+}
+
+func (mr *MemoryRepo) UpdateDelivery(ctx context.Context, broadcastId, chatId int64, status, errText string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if broadcast, ok := mr.broadcasts[broadcastId]; ok {
+		if _, ok := broadcast.deliveries[chatId]; ok {
+			broadcast.deliveries[chatId] = status
+		}
+	}
+
+	return nil
+}
+
+func (mr *MemoryRepo) FinishBroadcast(ctx context.Context, broadcastId int64) (*models.BroadcastSummary, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	summary := models.BroadcastSummary{Id: broadcastId}
+	broadcast, ok := mr.broadcasts[broadcastId]
+	if !ok {
+		return &summary, nil
+	}
+	broadcast.finished = true
+
+	for _, status := range broadcast.deliveries {
+		summary.Total++
+		switch status {
+		case models.DeliveryStatusSent:
+			summary.Sent++
+		case models.DeliveryStatusFailed:
+			summary.Failed++
+		}
+	}
+
+	return &summary, nil
+}
+
+func (mr *MemoryRepo) SearchUnfinishedBroadcasts(ctx context.Context) ([]int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var ids []int64
+	for id, broadcast := range mr.broadcasts {
+		if !broadcast.finished {
+			ids = append(ids, id)
+		}
+	}
+
+	return sortedIds(ids), nil
+}
+
+// changeTicket applies change to the details of the ticket and records it in the history under action.
+// Must be called with mr.mu held.
+func (mr *MemoryRepo) changeTicket(ticket *memoryTicket, action string, change func(models.TicketDetails) (models.TicketDetails, error), actor models.Actor) (*models.TicketDetails, *models.TicketDetails, error) {
+	before := ticketDetails(ticket)
+	after, err := change(before)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ticket.Name, ticket.Surname, ticket.TicketType = after.FullName, after.Surname, after.TicketType
+	ticket.price, ticket.actualPrice = after.Price, after.ActualPrice
+
+	mr.history = append(mr.history, memoryHistoryRecord{
+		TicketHistoryRecord: models.TicketHistoryRecord{
+			Action:            action,
+			FullNameBefore:    before.FullName,
+			FullNameAfter:     after.FullName,
+			TicketTypeBefore:  before.TicketType,
+			TicketTypeAfter:   after.TicketType,
+			PriceBefore:       before.Price,
+			PriceAfter:        after.Price,
+			ActualPriceBefore: before.ActualPrice,
+			ActualPriceAfter:  after.ActualPrice,
+			PriceDifference:   after.ActualPrice - before.ActualPrice,
+			ActorTag:          actor.Tag(),
+			ActorId:           actor.ID,
+			CreatedAt:         time.Now(),
+		},
+		ticketNo: ticket.ticketNo,
+	})
+
+	return &before, &after, nil
+}
+
+// paidSurcharges is what was paid on top of the ticket price. Must be called with mr.mu held.
+func (mr *MemoryRepo) paidSurcharges(ticketNo int64) int {
+	var paid int
+	for _, payment := range mr.payments {
+		if payment.ticketNo == ticketNo {
+			paid += payment.Amount
+		}
+	}
+
+	return paid
+}
+
+func ticketDetails(ticket *memoryTicket) models.TicketDetails {
+	return models.TicketDetails{
+		FullName:    ticket.Name,
+		Surname:     ticket.Surname,
+		TicketType:  ticket.TicketType,
+		Price:       ticket.price,
+		ActualPrice: ticket.actualPrice,
+		Seller:      ticket.seller,
+	}
+}
+
+// activeTicket looks up a ticket that is not refunded, like the WHERE NOT refunded queries do.
+// Must be called with mr.mu held.
+func (mr *MemoryRepo) activeTicket(id string) (*memoryTicket, error) {
+	ticketNo, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ticket number %q: %w", id, err)
+	}
+
+	ticket, err := mr.ticketByNo(ticketNo)
+	if err != nil || ticket.refunded {
+		return nil, sql.ErrNoRows
+	}
+
+	return ticket, nil
+}
+
+// entryState replays the entries log of the ticket. Must be called with mr.mu held.
+func (mr *MemoryRepo) entryState(ticketId string) models.EntryState {
+	var state models.EntryState
+	for _, entry := range mr.entries {
+		if entry.TicketId != ticketId {
+			continue
+		}
+
+		state.Inside = entry.Direction == models.EntryDirectionIn
+		if state.Inside {
+			state.Entries++
+		}
+	}
+
+	return state
+}
+
+// ticketByNo must be called with mr.mu held.
+func (mr *MemoryRepo) ticketByNo(ticketNo int64) (*memoryTicket, error) {
+	for _, ticket := range mr.tickets {
+		if ticket.ticketNo == ticketNo {
+			return ticket, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (mr *MemoryRepo) linkedChatIds(match func(*memoryTicket) bool) []int64 {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	seen := make(map[int64]bool)
+	var chatIds []int64
+	for _, link := range mr.links {
+		if link.chatId == 0 || seen[link.chatId] {
+			continue
+		}
+		ticket, err := mr.ticketByNo(link.ticketNo)
+		if err == nil && !ticket.refunded && match(ticket) {
+			seen[link.chatId] = true
+			chatIds = append(chatIds, link.chatId)
+		}
+	}
+
+	return sortedIds(chatIds)
+}
+
+func (t *memoryTicket) response() *models.TicketResponse {
+	return &models.TicketResponse{
+		Id:                t.Id,
+		Name:              t.Name,
+		TicketType:        t.TicketType,
+		PassedControlZone: t.PassedControlZone,
+		BuyerTag:          t.BuyerTag,
+		BuyerPhone:        t.BuyerPhone,
+		BirthDate:         t.BirthDate,
+	}
+}
+
+func sortedIds(ids []int64) []int64 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,78 @@
+package ticket_repository
+
+import (
+	"context"
+
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+const (
+	addPayment     = "INSERT INTO ticket_payments (ticket_id, kind, amount, seller_tag, seller_tg_id) VALUES ($1, $2, $3, $4, NULLIF($5::bigint, 0))"
+	searchPayments = "SELECT kind, amount, seller_tag, COALESCE(seller_tg_id, 0), created_at FROM ticket_payments WHERE ticket_id = $1 ORDER BY id"
+
+	// paidSurcharges is what was paid on top of the ticket price of t.
+	paidSurcharges = "(SELECT COALESCE(SUM(p.amount), 0) FROM ticket_payments p WHERE p.ticket_id = t.ticketno)"
+)
+
+func (tr *TicketsRepo) SearchTicketDetails(ctx context.Context, ticketId string) (*models.TicketDetails, error) {
+	var (
+		ticketNo int64
+		details  models.TicketDetails
+	)
+	err := tr.db.QueryRowContext(ctx, searchTicketDetails, ticketId).Scan(&ticketNo, &details.FullName, &details.Surname, &details.TicketType, &details.Price, &details.ActualPrice, &details.Seller)
+	if err != nil {
+		return nil, err
+	}
+
+	return &details, nil
+}
+
+// UpgradeTicket applies upgrade to the ticket like EditTicket and records the rise of its list price
+// (actual_ticket_price) as a surcharge credited to the seller. A price that didn't rise is not recorded.
+func (tr *TicketsRepo) UpgradeTicket(ctx context.Context, ticketId string, upgrade func(models.TicketDetails) (models.TicketDetails, error), seller models.Actor) (*models.TicketDetails, *models.TicketDetails, error) {
+	tx, err := tr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ticketNo, before, after, err := changeTicket(ctx, tx, ticketId, models.TicketHistoryUpgrade, upgrade, seller)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, nil, err
+	}
+
+	if amount := after.ActualPrice - before.ActualPrice; amount > 0 {
+		_, err = tx.ExecContext(ctx, addPayment, ticketNo, models.PaymentKindSurcharge, amount, seller.Tag(), seller.ID)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return before, after, nil
+}
+
+func (tr *TicketsRepo) SearchPayments(ctx context.Context, ticketId string) ([]models.Payment, error) {
+	rows, err := tr.db.QueryContext(ctx, searchPayments, ticketId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []models.Payment
+	for rows.Next() {
+		var payment models.Payment
+		err := rows.Scan(&payment.Kind, &payment.Amount, &payment.SellerTag, &payment.SellerId, &payment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
@@ -0,0 +1,61 @@
+package ticket_repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+)
+
+const benchTickets = 5000
+
+var (
+	benchSurnames   = []string{"Иванов", "Петров", "Сидоров", "Кузнецов", "Смирнов", "Попов", "Васильев", "Соколов", "Михайлов", "Новиков", "Фёдоров", "Морозов", "Волков", "Алексеев", "Лебедев", "Семёнов", "Егоров", "Павлов", "Козлов", "Степанов"}
+	benchFirstNames = []string{"Иван", "Пётр", "Алексей", "Дмитрий", "Сергей", "Андрей", "Мария", "Анна", "Елена", "Ольга"}
+)
+
+// seedTickets sells benchTickets tickets with every surname and first name combination repeated.
+func seedTickets(b *testing.B, repo conformanceRepo) {
+	b.Helper()
+
+	ctx := context.Background()
+	for i := 0; i < benchTickets; i++ {
+		surname := benchSurnames[i%len(benchSurnames)]
+		firstName := benchFirstNames[i/len(benchSurnames)%len(benchFirstNames)]
+		client := models.ClientData{FIO: surname + " " + firstName, TicketType: "Базовый", Price: 17}
+		_, err := repo.SellTicket(ctx, client, seller.Tag(), strings.ToLower(surname), 17)
+		if err != nil {
+			b.Fatalf("SellTicket() error = %v", err)
+		}
+	}
+}
+
+// BenchmarkSearchByName searches 5k tickets the way the door does: a surname with a typo,
+// a Latin-script name and a full name.
+func BenchmarkSearchByName(b *testing.B) {
+	queries := []string{"иваноф", "Petrov", "Сидоров Алексей"}
+
+	for name, newRepo := range backends(b) {
+		b.Run(name, func(b *testing.B) {
+			repo := newRepo(b)
+			seedTickets(b, repo)
+
+			for _, query := range queries {
+				terms := utils.SearchTerms(query)
+				b.Run(query, func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						found, err := repo.SearchByName(context.Background(), terms, 20)
+						if err != nil {
+							b.Fatalf("SearchByName(%q) error = %v", query, err)
+						}
+						if len(found) == 0 {
+							b.Fatalf("SearchByName(%q) found nothing", query)
+						}
+					}
+				})
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/qRe0/afterparty-bot/internal/configs"
 	"github.com/qRe0/afterparty-bot/internal/models"
 )
@@ -25,12 +26,55 @@ func New(db *sqlx.DB, cfg configs.DBConfig) *TicketsRepo {
 const (
 	connectingStringTemplate = "postgres://%s:%s@%s:%s/%s?sslmode=disable"
 
-	findClientByFullSurname = "SELECT ticketno, full_name, ticket_type, passed_control_zone FROM tickets WHERE surname=$1"
-	findClientBySurname     = "SELECT ticketno, full_name, ticket_type, passed_control_zone, surname FROM tickets WHERE surname LIKE $1"
-	updateQuery             = "UPDATE tickets SET passed_control_zone = true WHERE ticketno = $1 RETURNING ticketno, full_name, ticket_type, passed_control_zone"
-	searchById              = "SELECT ticketno, full_name, ticket_type, passed_control_zone FROM tickets WHERE ticketno=$1"
-	sellTicket              = "INSERT INTO tickets (surname, full_name, ticket_type, seller_name, ticket_price, actual_ticket_price, ticketno) VALUES ($1, $2, $3, $4, $5, $6, (SELECT COALESCE(MAX(ticketNo), 0) + 1 FROM tickets)) RETURNING ticketNo"
+	// isInside and entriesCount derive the guest's state of ticket t from the entries log:
+	// inside if the last entry is "in", and how many times they came in.
+	isInside     = "COALESCE((SELECT e.direction = 'in' FROM entries e WHERE e.ticket_id = t.ticketno ORDER BY e.id DESC LIMIT 1), FALSE)"
+	entriesCount = "(SELECT COUNT(*) FROM entries e WHERE e.ticket_id = t.ticketno AND e.direction = 'in')"
+
+	findClientByFullSurname = "SELECT ticketno, full_name, ticket_type FROM tickets WHERE surname=$1"
+	lockTicket              = "SELECT ticketno FROM tickets WHERE ticketno = $1 AND NOT refunded FOR UPDATE"
+	searchEntryState        = "SELECT " + isInside + ", " + entriesCount + " FROM tickets t WHERE t.ticketno = $1"
+	addEntry                = "INSERT INTO entries (ticket_id, direction, checker, door, id_checked) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)"
+	searchById              = "SELECT t.ticketno, t.full_name, t.ticket_type, " + isInside + ", COALESCE(t.buyer_tag, ''), COALESCE(t.buyer_phone, ''), COALESCE(TO_CHAR(t.buyer_birth_date, 'YYYY-MM-DD'), '') FROM tickets t WHERE t.ticketno=$1 AND NOT t.refunded"
+	searchByFullName        = "SELECT t.ticketno, t.full_name, t.ticket_type, " + isInside + ", COALESCE(t.buyer_tag, ''), COALESCE(t.buyer_phone, ''), COALESCE(TO_CHAR(t.buyer_birth_date, 'YYYY-MM-DD'), '') FROM tickets t WHERE REPLACE(LOWER(t.full_name), 'ё', 'е') = $1 AND NOT t.refunded ORDER BY t.ticketno"
+	sellTicket              = "INSERT INTO tickets (surname, full_name, ticket_type, seller_name, ticket_price, actual_ticket_price, buyer_tag, buyer_phone, buyer_email, buyer_birth_date, marketing_consent) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, '')::date, $11) RETURNING ticketno"
+	searchContacts          = "SELECT ticketno, full_name, COALESCE(buyer_tag, ''), COALESCE(buyer_phone, ''), COALESCE(buyer_email, ''), marketing_consent FROM tickets WHERE NOT refunded AND (buyer_tag IS NOT NULL OR buyer_phone IS NOT NULL OR buyer_email IS NOT NULL) ORDER BY ticketno"
 	updateSellersTable      = "INSERT INTO ticket_sellers (ticket_id, seller_tag, seller_tg_id) VALUES ($1, $2, $3)"
+	addReissueRecord        = "INSERT INTO ticket_reissues (ticket_id, actor_tag, actor_tg_id) VALUES ($1, $2, $3)"
+	createTicketLink        = "INSERT INTO ticket_links (ticket_id, token, buyer_tag, buyer_tg_id) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, 0))"
+	searchAllTickets        = "SELECT t.ticketno, t.full_name, t.ticket_type, " + isInside + ", t.surname, COALESCE(t.buyer_tag, ''), COALESCE(t.buyer_phone, ''), COALESCE(TO_CHAR(t.buyer_birth_date, 'YYYY-MM-DD'), '') FROM tickets t WHERE NOT t.refunded ORDER BY t.ticketno"
+	refundTicket            = "UPDATE tickets t SET refunded = true, refunded_at = NOW() WHERE t.ticketno = $1 AND NOT t.refunded AND NOT EXISTS (SELECT 1 FROM entries e WHERE e.ticket_id = t.ticketno) RETURNING t.ticketno, t.full_name, t.ticket_type, FALSE"
+	searchStats             = "SELECT COUNT(*) FILTER (WHERE NOT refunded), COUNT(*) FILTER (WHERE NOT refunded AND entries > 0), COUNT(*) FILTER (WHERE NOT refunded AND inside), COUNT(*) FILTER (WHERE refunded), COALESCE(SUM(ticket_price + surcharges) FILTER (WHERE NOT refunded), 0) FROM (SELECT t.refunded, t.ticket_price, " + paidSurcharges + " AS surcharges, " + isInside + " AS inside, " + entriesCount + " AS entries FROM tickets t) s"
+	searchStatsByType       = "SELECT ticket_type, COUNT(*) FROM tickets WHERE NOT refunded GROUP BY ticket_type ORDER BY ticket_type"
+	searchByChatId          = "SELECT DISTINCT t.ticketno, t.full_name, t.ticket_type, " + isInside + " FROM tickets t JOIN ticket_links l ON t.ticketno = l.ticket_id WHERE l.chat_id = $1 AND NOT t.refunded ORDER BY t.ticketno"
+
+	// findClientByName matches every search term (the query itself and its transliteration)
+	// against the surname and the whole full name via pg_trgm, keeping the best score per ticket.
+	findClientByName = `SELECT ticketno, full_name, ticket_type, passed_control_zone, surname, buyer_tag, buyer_phone, birth_date, score FROM (
+		SELECT DISTINCT ON (t.ticketno) t.ticketno, t.full_name, t.ticket_type, ` + isInside + ` AS passed_control_zone, t.surname,
+			COALESCE(t.buyer_tag, '') AS buyer_tag, COALESCE(t.buyer_phone, '') AS buyer_phone,
+			COALESCE(TO_CHAR(t.buyer_birth_date, 'YYYY-MM-DD'), '') AS birth_date,
+			GREATEST(similarity(t.surname, q.term), word_similarity(q.term, REPLACE(LOWER(t.full_name), 'ё', 'е'))) AS score
+		FROM tickets t, UNNEST($1::text[]) AS q(term)
+		WHERE NOT t.refunded AND (t.surname % q.term OR q.term <% REPLACE(LOWER(t.full_name), 'ё', 'е'))
+		ORDER BY t.ticketno, score DESC
+	) found ORDER BY score DESC, ticketno LIMIT $2`
+
+	// moveTicketNoStart moves the ticket number sequence forward to $1, never backwards,
+	// so restarting with the same TICKET_NO_START does not reuse numbers.
+	moveTicketNoStart = `SELECT setval('tickets_ticketno_seq', $1, false)
+		FROM tickets_ticketno_seq
+		WHERE (CASE WHEN is_called THEN last_value + 1 ELSE last_value END) < $1`
+
+	bindTicketLink = `WITH bound AS (
+		UPDATE ticket_links SET chat_id = $2, buyer_tg_id = $3, linked_at = NOW()
+		WHERE token = $1
+		  AND (chat_id IS NULL OR chat_id = $2)
+		  AND (buyer_tg_id IS NULL OR buyer_tg_id = $3)
+		  AND (buyer_tag IS NULL OR LOWER(buyer_tag) = LOWER($4))
+		RETURNING ticket_id
+	)
+	SELECT t.ticketno, t.full_name, t.ticket_type, ` + isInside + ` FROM tickets t JOIN bound b ON t.ticketno = b.ticket_id WHERE NOT t.refunded`
 )
 
 func NewDatabaseConnection(cfg configs.DBConfig) (*sqlx.DB, error) {
@@ -55,8 +99,17 @@ func NewDatabaseConnection(cfg configs.DBConfig) (*sqlx.DB, error) {
 	return db, nil
 }
 
-func (tr *TicketsRepo) SearchBySurname(ctx context.Context, surname string) ([]models.TicketResponse, error) {
-	rows, err := tr.db.QueryContext(ctx, findClientBySurname, surname)
+func (tr *TicketsRepo) MoveTicketNoStart(ctx context.Context, start int64) error {
+	_, err := tr.db.ExecContext(ctx, moveTicketNoStart, start)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tr *TicketsRepo) SearchByName(ctx context.Context, terms []string, limit int) ([]models.TicketResponse, error) {
+	rows, err := tr.db.QueryContext(ctx, findClientByName, pq.Array(terms), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +118,7 @@ func (tr *TicketsRepo) SearchBySurname(ctx context.Context, surname string) ([]m
 	var users []models.TicketResponse
 	for rows.Next() {
 		var user models.TicketResponse
-		err := rows.Scan(&user.Id, &user.Name, &user.TicketType, &user.PassedControlZone, &user.Surname)
+		err := rows.Scan(&user.Id, &user.Name, &user.TicketType, &user.PassedControlZone, &user.Surname, &user.BuyerTag, &user.BuyerPhone, &user.BirthDate, &user.Score)
 		if err != nil {
 			return nil, err
 		}
@@ -79,14 +132,42 @@ func (tr *TicketsRepo) SearchBySurname(ctx context.Context, surname string) ([]m
 	return users, nil
 }
 
-func (tr *TicketsRepo) MarkAsEntered(ctx context.Context, id string) (*models.TicketResponse, error) {
+// AddEntry writes the entry to the log if check accepts the current state of the ticket.
+// The ticket row stays locked until commit, so concurrent entries are checked one after another.
+func (tr *TicketsRepo) AddEntry(ctx context.Context, entry models.Entry, check func(models.EntryState) error) (*models.TicketResponse, error) {
 	tx, err := tr.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	var ticketNo int64
+	err = tx.QueryRowContext(ctx, lockTicket, entry.TicketId).Scan(&ticketNo)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	var state models.EntryState
+	err = tx.QueryRowContext(ctx, searchEntryState, ticketNo).Scan(&state.Inside, &state.Entries)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	err = check(state)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, addEntry, ticketNo, entry.Direction, entry.Checker, entry.Door, entry.IDChecked)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
 	var resp models.TicketResponse
-	err = tx.QueryRowContext(ctx, updateQuery, id).Scan(&resp.Id, &resp.Name, &resp.TicketType, &resp.PassedControlZone)
+	err = tx.QueryRowContext(ctx, searchById, ticketNo).Scan(&resp.Id, &resp.Name, &resp.TicketType, &resp.PassedControlZone, &resp.BuyerTag, &resp.BuyerPhone, &resp.BirthDate)
 	if err != nil {
 		_ = tx.Rollback()
 		return nil, err
@@ -116,7 +197,7 @@ func (tr *TicketsRepo) CheckCountOfSurnames(ctx context.Context, surname string)
 
 func (tr *TicketsRepo) SearchById(ctx context.Context, id string) (*models.TicketResponse, error) {
 	var resp models.TicketResponse
-	err := tr.db.QueryRowContext(ctx, searchById, id).Scan(&resp.Id, &resp.Name, &resp.TicketType, &resp.PassedControlZone)
+	err := tr.db.QueryRowContext(ctx, searchById, id).Scan(&resp.Id, &resp.Name, &resp.TicketType, &resp.PassedControlZone, &resp.BuyerTag, &resp.BuyerPhone, &resp.BirthDate)
 	if err != nil {
 		return nil, err
 	}
@@ -124,13 +205,38 @@ func (tr *TicketsRepo) SearchById(ctx context.Context, id string) (*models.Ticke
 	return &resp, nil
 }
 
+func (tr *TicketsRepo) SearchByFullName(ctx context.Context, normalizedName string) ([]models.TicketResponse, error) {
+	rows, err := tr.db.QueryContext(ctx, searchByFullName, normalizedName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []models.TicketResponse
+	for rows.Next() {
+		var ticket models.TicketResponse
+		err := rows.Scan(&ticket.Id, &ticket.Name, &ticket.TicketType, &ticket.PassedControlZone, &ticket.BuyerTag, &ticket.BuyerPhone, &ticket.BirthDate)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tickets, nil
+}
+
 func (tr *TicketsRepo) SellTicket(ctx context.Context, client models.ClientData, seller string, clientSurname string, actualPrice int) (int64, error) {
 	tx, err := tr.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
 	var id int64
-	err = tx.QueryRowContext(ctx, sellTicket, clientSurname, client.FIO, client.TicketType, seller, client.Price, actualPrice).Scan(&id)
+	err = tx.QueryRowContext(ctx, sellTicket, clientSurname, client.FIO, client.TicketType, seller, client.Price, actualPrice,
+		client.BuyerTag, client.BuyerPhone, client.BuyerEmail, client.BuyerBirthDate, client.MarketingConsent).Scan(&id)
 	if err != nil {
 		_ = tx.Rollback()
 		return 0, err
@@ -163,3 +269,145 @@ func (tr *TicketsRepo) UpdateSellersTable(ctx context.Context, ticketId, sellerI
 
 	return nil
 }
+
+func (tr *TicketsRepo) AddReissueRecord(ctx context.Context, ticketId, actorId int64, actor string) error {
+	_, err := tr.db.ExecContext(ctx, addReissueRecord, ticketId, actor, actorId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tr *TicketsRepo) CreateTicketLink(ctx context.Context, ticketId int64, token, buyerTag string, buyerTgId int64) error {
+	_, err := tr.db.ExecContext(ctx, createTicketLink, ticketId, token, buyerTag, buyerTgId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tr *TicketsRepo) BindTicketLink(ctx context.Context, token string, chatId, userId int64, userTag string) (*models.TicketResponse, error) {
+	var resp models.TicketResponse
+	err := tr.db.QueryRowContext(ctx, bindTicketLink, token, chatId, userId, userTag).Scan(&resp.Id, &resp.Name, &resp.TicketType, &resp.PassedControlZone)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (tr *TicketsRepo) SearchByChatId(ctx context.Context, chatId int64) ([]models.TicketResponse, error) {
+	rows, err := tr.db.QueryContext(ctx, searchByChatId, chatId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []models.TicketResponse
+	for rows.Next() {
+		var ticket models.TicketResponse
+		err := rows.Scan(&ticket.Id, &ticket.Name, &ticket.TicketType, &ticket.PassedControlZone)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tickets, nil
+}
+
+func (tr *TicketsRepo) SearchAllTickets(ctx context.Context) ([]models.TicketResponse, error) {
+	rows, err := tr.db.QueryContext(ctx, searchAllTickets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []models.TicketResponse
+	for rows.Next() {
+		var ticket models.TicketResponse
+		err := rows.Scan(&ticket.Id, &ticket.Name, &ticket.TicketType, &ticket.PassedControlZone, &ticket.Surname, &ticket.BuyerTag, &ticket.BuyerPhone, &ticket.BirthDate)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tickets, nil
+}
+
+func (tr *TicketsRepo) SearchContacts(ctx context.Context) ([]models.BuyerContact, error) {
+	rows, err := tr.db.QueryContext(ctx, searchContacts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []models.BuyerContact
+	for rows.Next() {
+		var contact models.BuyerContact
+		err := rows.Scan(&contact.TicketNo, &contact.FullName, &contact.Tag, &contact.Phone, &contact.Email, &contact.MarketingConsent)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+func (tr *TicketsRepo) RefundTicket(ctx context.Context, id string) (*models.TicketResponse, error) {
+	var resp models.TicketResponse
+	err := tr.db.QueryRowContext(ctx, refundTicket, id).Scan(&resp.Id, &resp.Name, &resp.TicketType, &resp.PassedControlZone)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (tr *TicketsRepo) Stats(ctx context.Context) (*models.Stats, error) {
+	stats := models.Stats{ByType: make(map[string]int)}
+	err := tr.db.QueryRowContext(ctx, searchStats).Scan(&stats.Sold, &stats.Entered, &stats.Inside, &stats.Refunded, &stats.Revenue)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tr.db.QueryContext(ctx, searchStatsByType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			ticketType string
+			count      int
+		)
+		err := rows.Scan(&ticketType, &count)
+		if err != nil {
+			return nil, err
+		}
+		stats.ByType[ticketType] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
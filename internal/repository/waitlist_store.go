@@ -0,0 +1,95 @@
+package ticket_repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+const (
+	waitlistColumns = "id, full_name, contact, added_by, added_by_chat_id, status, offered_until"
+
+	addToWaitlist        = "INSERT INTO waitlist (full_name, contact, added_by, added_by_chat_id) VALUES ($1, $2, $3, $4) RETURNING id"
+	searchWaitlist       = "SELECT " + waitlistColumns + " FROM waitlist WHERE status IN ('waiting', 'offered') ORDER BY id"
+	expireWaitlistOffers = "UPDATE waitlist SET status = 'expired', updated_at = NOW() WHERE status = 'offered' AND offered_until <= $1 RETURNING " + waitlistColumns
+	updateWaitlistStatus = "UPDATE waitlist SET status = $2, updated_at = NOW() WHERE id = $1 AND status = ANY($3) RETURNING " + waitlistColumns
+
+	// offerNextWaitlisted holds a spot for the first person still waiting. SKIP LOCKED keeps
+	// two concurrent calls from offering the same spot to the same person.
+	offerNextWaitlisted = `UPDATE waitlist SET status = 'offered', offered_until = $1, updated_at = NOW()
+		WHERE id = (SELECT id FROM waitlist WHERE status = 'waiting' ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING ` + waitlistColumns
+)
+
+func (tr *TicketsRepo) AddToWaitlist(ctx context.Context, entry models.WaitlistEntry) (int64, error) {
+	var id int64
+	err := tr.db.QueryRowContext(ctx, addToWaitlist, entry.FullName, entry.Contact, entry.AddedBy, entry.AddedByChatId).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// SearchWaitlist returns the people still waiting and those holding an offer, in the order they were added.
+func (tr *TicketsRepo) SearchWaitlist(ctx context.Context) ([]models.WaitlistEntry, error) {
+	return tr.selectWaitlist(ctx, searchWaitlist)
+}
+
+// OfferNextWaitlisted holds a spot until the given time for the first person waiting, or returns sql.ErrNoRows if there's nobody.
+func (tr *TicketsRepo) OfferNextWaitlisted(ctx context.Context, until time.Time) (*models.WaitlistEntry, error) {
+	return scanWaitlistEntry(tr.db.QueryRowContext(ctx, offerNextWaitlisted, until))
+}
+
+// ExpireWaitlistOffers ends the offers held past the given time and returns them.
+func (tr *TicketsRepo) ExpireWaitlistOffers(ctx context.Context, now time.Time) ([]models.WaitlistEntry, error) {
+	return tr.selectWaitlist(ctx, expireWaitlistOffers, now)
+}
+
+// UpdateWaitlistStatus moves the entry to the status if it is in one of the given ones, or returns sql.ErrNoRows.
+func (tr *TicketsRepo) UpdateWaitlistStatus(ctx context.Context, id int64, from []string, to string) (*models.WaitlistEntry, error) {
+	return scanWaitlistEntry(tr.db.QueryRowContext(ctx, updateWaitlistStatus, id, to, pq.Array(from)))
+}
+
+func (tr *TicketsRepo) selectWaitlist(ctx context.Context, query string, args ...interface{}) ([]models.WaitlistEntry, error) {
+	rows, err := tr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.WaitlistEntry
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// rowScanner is either *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWaitlistEntry(row rowScanner) (*models.WaitlistEntry, error) {
+	var (
+		entry        models.WaitlistEntry
+		offeredUntil sql.NullTime
+	)
+	err := row.Scan(&entry.Id, &entry.FullName, &entry.Contact, &entry.AddedBy, &entry.AddedByChatId, &entry.Status, &offeredUntil)
+	if err != nil {
+		return nil, err
+	}
+	if offeredUntil.Valid {
+		entry.OfferedUntil = &offeredUntil.Time
+	}
+
+	return &entry, nil
+}
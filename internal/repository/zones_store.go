@@ -0,0 +1,20 @@
+package ticket_repository
+
+import (
+	"context"
+
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+const (
+	addZoneScan = "INSERT INTO zone_scans (ticket_id, zone, allowed, checker) VALUES ($1, $2, $3, NULLIF($4, ''))"
+)
+
+func (tr *TicketsRepo) AddZoneScan(ctx context.Context, scan models.ZoneScan, checker string) error {
+	_, err := tr.db.ExecContext(ctx, addZoneScan, scan.Ticket.Id, scan.Zone, scan.Allowed, checker)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package ticket_service
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"go.uber.org/zap"
+)
+
+type AuditRepo interface {
+	AddAuditRecord(ctx context.Context, record models.AuditRecord) error
+	SearchAuditByTicketNo(ctx context.Context, ticketNo string) ([]models.AuditRecord, error)
+}
+
+// AuditedTicketsService decorates TicketsService: every staff action it overrides is written
+// to the audit log once it has succeeded. Searches take the staff member as well, so that
+// lookups are attributed too. The rest of TicketsService is passed through unaudited.
+type AuditedTicketsService struct {
+	*TicketsService
+	audit AuditRepo
+}
+
+func NewAudited(service *TicketsService, audit AuditRepo) *AuditedTicketsService {
+	return &AuditedTicketsService{
+		TicketsService: service,
+		audit:          audit,
+	}
+}
+
+// SearchBySurname records the query only, not every ticket found: the inline mode searches on each keystroke.
+func (as *AuditedTicketsService) SearchBySurname(ctx context.Context, staff models.Actor, surname string) ([]models.TicketResponse, error) {
+	tickets, err := as.TicketsService.SearchBySurname(ctx, surname)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, staff, models.AuditActionSearch, "", surname, nil, nil)
+	return tickets, nil
+}
+
+func (as *AuditedTicketsService) SearchById(ctx context.Context, staff models.Actor, ticketId string) (*models.TicketResponse, error) {
+	ticket, err := as.TicketsService.SearchById(ctx, ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, staff, models.AuditActionLookup, ticket.Id, "", nil, nil)
+	return ticket, nil
+}
+
+func (as *AuditedTicketsService) SellTicket(ctx context.Context, seller models.Actor, client models.ClientData) (*models.SaleResult, error) {
+	result, err := as.TicketsService.SellTicket(ctx, seller, client)
+	if err != nil {
+		return nil, err
+	}
+
+	sold := soldTicket{TicketNo: result.TicketNo, TicketType: client.TicketType, Price: client.Price, FullName: result.FIO}
+	as.record(ctx, seller, models.AuditActionSell, strconv.FormatInt(result.TicketNo, 10), "", nil, auditState(sold))
+	return result, nil
+}
+
+// soldTicket is what the audit log keeps of a sale. The buyer's contacts, birth date and consent
+// stay in the tickets table, where they can be corrected or erased; the audit log is append-only.
+type soldTicket struct {
+	TicketNo   int64  `json:"ticket_no"`
+	TicketType string `json:"ticket_type"`
+	Price      int    `json:"price"`
+	FullName   string `json:"full_name"`
+}
+
+func (as *AuditedTicketsService) RefundTicket(ctx context.Context, admin models.Actor, ticketId string) (*models.TicketResponse, error) {
+	before := as.ticketState(ctx, ticketId)
+
+	resp, err := as.TicketsService.RefundTicket(ctx, ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, admin, models.AuditActionRefund, resp.Id, "", before, auditState(resp))
+	return resp, nil
+}
+
+func (as *AuditedTicketsService) ReissueTicket(ctx context.Context, actor models.Actor, ticketId string) (*models.IssuedTicket, error) {
+	issued, err := as.TicketsService.ReissueTicket(ctx, actor, ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, actor, models.AuditActionReissue, issued.Ticket.Id, "", nil, nil)
+	return issued, nil
+}
+
+// MarkAsEntered returns the ticket along with ErrBlacklisted and ErrIDCheckRequired, like TicketsService does.
+func (as *AuditedTicketsService) MarkAsEntered(ctx context.Context, checker models.Actor, ticketId, checkpoint string, idChecked bool) (*models.TicketResponse, error) {
+	before := as.ticketState(ctx, ticketId)
+
+	resp, err := as.TicketsService.MarkAsEntered(ctx, checker, ticketId, checkpoint, idChecked)
+	if err != nil {
+		return resp, err
+	}
+
+	details := []string{checkpoint}
+	if idChecked {
+		details = append(details, "id_checked")
+	}
+	as.record(ctx, checker, models.AuditActionEnter, resp.Id, auditDetails(details...), before, auditState(resp))
+	return resp, nil
+}
+
+func (as *AuditedTicketsService) MarkAsExited(ctx context.Context, checker models.Actor, ticketId, checkpoint string) (*models.TicketResponse, error) {
+	before := as.ticketState(ctx, ticketId)
+
+	resp, err := as.TicketsService.MarkAsExited(ctx, checker, ticketId, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, checker, models.AuditActionExit, resp.Id, checkpoint, before, auditState(resp))
+	return resp, nil
+}
+
+// SyncOfflineEntry records the entries actually added; the door stands in for the checker, who is unknown.
+func (as *AuditedTicketsService) SyncOfflineEntry(ctx context.Context, ticketId, door string) (*models.TicketResponse, bool, error) {
+	before := as.ticketState(ctx, ticketId)
+
+	resp, conflict, err := as.TicketsService.SyncOfflineEntry(ctx, ticketId, door)
+	if err != nil || conflict {
+		return resp, conflict, err
+	}
+
+	as.record(ctx, models.Actor{}, models.AuditActionOfflineEntry, resp.Id, door, before, auditState(resp))
+	return resp, false, nil
+}
+
+func (as *AuditedTicketsService) CheckZoneAccess(ctx context.Context, checker models.Actor, zone, ticketId string) (*models.ZoneScan, error) {
+	scan, err := as.TicketsService.CheckZoneAccess(ctx, checker, zone, ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	verdict := "denied"
+	if scan.Allowed {
+		verdict = "allowed"
+	}
+	as.record(ctx, checker, models.AuditActionZoneCheck, scan.Ticket.Id, auditDetails(zone, verdict), nil, nil)
+	return scan, nil
+}
+
+func (as *AuditedTicketsService) AddToBlacklist(ctx context.Context, admin models.Actor, entry models.BlacklistEntry) (*models.BlacklistEntry, error) {
+	added, err := as.TicketsService.AddToBlacklist(ctx, admin, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, admin, models.AuditActionBlacklistAdd, "", strconv.FormatInt(added.Id, 10), nil, auditState(added))
+	return added, nil
+}
+
+func (as *AuditedTicketsService) RemoveFromBlacklist(ctx context.Context, admin models.Actor, id int64) error {
+	err := as.TicketsService.RemoveFromBlacklist(ctx, admin, id)
+	if err != nil {
+		return err
+	}
+
+	as.record(ctx, admin, models.AuditActionBlacklistRemove, "", strconv.FormatInt(id, 10), nil, nil)
+	return nil
+}
+
+func (as *AuditedTicketsService) OverrideBlacklist(ctx context.Context, admin models.Actor, ticketId string) (*models.TicketResponse, error) {
+	ticket, err := as.TicketsService.OverrideBlacklist(ctx, admin, ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, admin, models.AuditActionBlacklistOverride, ticket.Id, "", nil, auditState(ticket))
+	return ticket, nil
+}
+
+func (as *AuditedTicketsService) TransferTicket(ctx context.Context, actor models.Actor, transfer models.TicketTransfer) (*models.TicketTransfer, error) {
+	before := as.ticketState(ctx, transfer.TicketId)
+
+	transferred, err := as.TicketsService.TransferTicket(ctx, actor, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, actor, models.AuditActionTransfer, transferred.TicketId, "", before, as.ticketState(ctx, transferred.TicketId))
+	return transferred, nil
+}
+
+func (as *AuditedTicketsService) EditTicket(ctx context.Context, admin models.Actor, edit models.TicketEdit) (*models.TicketEditResult, error) {
+	edited, err := as.TicketsService.EditTicket(ctx, admin, edit)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, admin, models.AuditActionEdit, edited.TicketId, "", auditState(edited.Before), auditState(edited.After))
+	return edited, nil
+}
+
+func (as *AuditedTicketsService) UpgradeTicket(ctx context.Context, seller models.Actor, upgrade models.TicketUpgrade) (*models.TicketUpgradeResult, error) {
+	upgraded, err := as.TicketsService.UpgradeTicket(ctx, seller, upgrade)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, seller, models.AuditActionUpgrade, upgraded.TicketId, "surcharge "+strconv.Itoa(upgraded.Surcharge), auditState(upgraded.Before), auditState(upgraded.After))
+	return upgraded, nil
+}
+
+func (as *AuditedTicketsService) AddToWaitlist(ctx context.Context, seller models.Actor, chatID int64, entry models.WaitlistEntry) (*models.WaitlistEntry, error) {
+	added, err := as.TicketsService.AddToWaitlist(ctx, seller, chatID, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, seller, models.AuditActionWaitlistAdd, "", strconv.FormatInt(added.Id, 10), nil, auditState(added))
+	return added, nil
+}
+
+func (as *AuditedTicketsService) RemoveFromWaitlist(ctx context.Context, actor models.Actor, id int64) error {
+	err := as.TicketsService.RemoveFromWaitlist(ctx, actor, id)
+	if err != nil {
+		return err
+	}
+
+	as.record(ctx, actor, models.AuditActionWaitlistRemove, "", strconv.FormatInt(id, 10), nil, nil)
+	return nil
+}
+
+func (as *AuditedTicketsService) DeclineWaitlistOffer(ctx context.Context, seller models.Actor, id int64) (*models.WaitlistEntry, error) {
+	entry, err := as.TicketsService.DeclineWaitlistOffer(ctx, seller, id)
+	if err != nil {
+		return nil, err
+	}
+
+	as.record(ctx, seller, models.AuditActionWaitlistDecline, "", strconv.FormatInt(id, 10), nil, auditState(entry))
+	return entry, nil
+}
+
+// TicketAudit returns the history of the ticket from the audit log, oldest first.
+func (as *AuditedTicketsService) TicketAudit(ctx context.Context, ticketNo string) ([]models.AuditRecord, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started TicketAudit method call")
+
+	number, err := strconv.Atoi(ticketNo)
+	if err != nil {
+		lgr.Error("TicketService:: TicketAudit:: Ticket number is not a number", zap.String("ticket_no", ticketNo))
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticketNo")
+	}
+
+	records, err := as.audit.SearchAuditByTicketNo(ctx, strconv.Itoa(number))
+	if err != nil {
+		lgr.Error("TicketService:: TicketAudit:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished TicketAudit method call", zap.Int("records", len(records)))
+	return records, nil
+}
+
+// record writes the action to the audit log. The action has already happened by then,
+// so a failed write is only logged.
+func (as *AuditedTicketsService) record(ctx context.Context, actor models.Actor, action, ticketNo, details string, before, after json.RawMessage) {
+	record := models.AuditRecord{
+		ActorId:   actor.ID,
+		ActorName: actor.UserName,
+		Action:    action,
+		TicketNo:  ticketNo,
+		Details:   details,
+		Before:    before,
+		After:     after,
+	}
+
+	err := as.audit.AddAuditRecord(ctx, record)
+	if err != nil {
+		logger.New(ctx).Error("TicketService:: Can't write audit record", zap.String("action", action), zap.String("ticket_no", ticketNo),
+			zap.String("actor", actor.Tag()), zap.Error(err))
+	}
+}
+
+// ticketState is the ticket as it is before an action, or nil if it can't be found.
+func (as *AuditedTicketsService) ticketState(ctx context.Context, ticketId string) json.RawMessage {
+	ticket, err := as.repo.SearchById(ctx, ticketId)
+	if err != nil {
+		return nil
+	}
+
+	return auditState(ticket)
+}
+
+func auditState(state interface{}) json.RawMessage {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil
+	}
+
+	return raw
+}
+
+func auditDetails(parts ...string) string {
+	var details []string
+	for _, part := range parts {
+		if part != "" {
+			details = append(details, part)
+		}
+	}
+
+	return strings.Join(details, ", ")
+}
@@ -0,0 +1,63 @@
+package ticket_service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+type fakeAuditRepo struct {
+	AuditRepo
+	records []models.AuditRecord
+}
+
+func (fa *fakeAuditRepo) AddAuditRecord(ctx context.Context, record models.AuditRecord) error {
+	fa.records = append(fa.records, record)
+	return nil
+}
+
+func TestAuditedSellTicketKeepsNoPersonalData(t *testing.T) {
+	ts := New(&fakeSalesRepo{}, configs.Config{Sheet: configs.GoogleSheets{DeploymentURL: "https://sheet.example"}})
+	ts.httpPostFn = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+	ts.generateTicketImageFn = func(ticketNo int64) (*bytes.Buffer, error) {
+		return bytes.NewBufferString("png"), nil
+	}
+	audit := &fakeAuditRepo{}
+	as := NewAudited(ts, audit)
+
+	client := models.ClientData{
+		FIO:              "иванов иван",
+		TicketType:       "базовый",
+		Price:            17,
+		BuyerPhone:       "+375291234567",
+		BuyerEmail:       "ivanov@example.com",
+		BuyerBirthDate:   "2000-01-02",
+		MarketingConsent: true,
+	}
+	_, err := as.SellTicket(context.Background(), models.Actor{ID: 1, UserName: "seller"}, client)
+	if err != nil {
+		t.Fatalf("SellTicket() error = %v", err)
+	}
+
+	if len(audit.records) != 1 {
+		t.Fatalf("got %d audit records, want 1", len(audit.records))
+	}
+	var after map[string]interface{}
+	if err := json.Unmarshal(audit.records[0].After, &after); err != nil {
+		t.Fatalf("audit state %s: %v", audit.records[0].After, err)
+	}
+	want := map[string]interface{}{"ticket_no": 1.0, "ticket_type": "базовый", "price": 17.0, "full_name": "Иванов Иван"}
+	if !reflect.DeepEqual(after, want) {
+		t.Fatalf("audit state = %v, want %v", after, want)
+	}
+}
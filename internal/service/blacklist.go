@@ -0,0 +1,174 @@
+package ticket_service
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+func (ts *TicketsService) Blacklist(ctx context.Context) ([]models.BlacklistEntry, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started Blacklist method call")
+
+	blacklist, err := ts.repo.SearchBlacklist(ctx)
+	if err != nil {
+		lgr.Error("TicketService:: Blacklist:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished Blacklist method call", zap.Int("entries", len(blacklist)))
+	return blacklist, nil
+}
+
+func (ts *TicketsService) AddToBlacklist(ctx context.Context, admin models.Actor, entry models.BlacklistEntry) (*models.BlacklistEntry, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started AddToBlacklist method call")
+
+	entry.FullName = strings.Title(strings.Join(strings.Fields(entry.FullName), " "))
+	entry.Reason = strings.TrimSpace(entry.Reason)
+	if entry.FullName == "" || entry.Reason == "" {
+		lgr.Error("TicketService:: AddToBlacklist:: Empty name or reason passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "blacklist entry")
+	}
+	// A single word would flag every guest who shares it, so the name needs a surname and a first name.
+	if len(strings.Fields(entry.FullName)) < 2 {
+		lgr.Error("TicketService:: AddToBlacklist:: Single-word name passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "blacklist entry needs a surname and a first name")
+	}
+	entry.AddedBy = admin.Tag()
+
+	id, err := ts.repo.AddToBlacklist(ctx, entry)
+	if err != nil {
+		lgr.Error("TicketService:: AddToBlacklist:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+	entry.Id = id
+
+	lgr.Info("TicketsService:: Finished AddToBlacklist method call", zap.Int64("blacklist_id", id), zap.String("admin", entry.AddedBy))
+	return &entry, nil
+}
+
+func (ts *TicketsService) RemoveFromBlacklist(ctx context.Context, admin models.Actor, id int64) error {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started RemoveFromBlacklist method call")
+
+	err := ts.repo.RemoveFromBlacklist(ctx, id, admin.Tag())
+	if err != nil {
+		lgr.Warn("TicketService:: RemoveFromBlacklist:: Repository method returned error", zap.Error(err))
+		return notFoundAs(err, errs.ErrBlacklistNotFound)
+	}
+
+	lgr.Info("TicketsService:: Finished RemoveFromBlacklist method call", zap.Int64("blacklist_id", id), zap.String("admin", admin.Tag()))
+	return nil
+}
+
+// BlacklistMatches returns the blacklist entries the full name matches.
+func (ts *TicketsService) BlacklistMatches(ctx context.Context, fullName string) ([]models.BlacklistEntry, error) {
+	blacklist, err := ts.repo.SearchBlacklist(ctx)
+	if err != nil {
+		logger.New(ctx).Error("TicketService:: BlacklistMatches:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+
+	return utils.BlacklistMatches(fullName, blacklist, ts.Cfg.Search.BlacklistMinScore), nil
+}
+
+// OverrideBlacklist records an admin's decision to let in a guest whose name matches the blacklist,
+// after which MarkAsEntered no longer refuses the ticket.
+func (ts *TicketsService) OverrideBlacklist(ctx context.Context, admin models.Actor, ticketId string) (*models.TicketResponse, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started OverrideBlacklist method call")
+
+	if !ts.canOverrideBlacklist(admin) {
+		lgr.Warn("TicketService:: OverrideBlacklist:: Override attempted without admin rights", zap.String("actor", admin.Tag()))
+		return nil, errs.ErrOverrideNotAllowed
+	}
+
+	ticket, err := ts.SearchById(ctx, ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ts.logBlacklistOverrides(ctx, admin, ticket.Id, ticket.Blacklist, models.BlacklistOverrideEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished OverrideBlacklist method call")
+	return ticket, nil
+}
+
+func (ts *TicketsService) canOverrideBlacklist(actor models.Actor) bool {
+	return utils.UserInList(actor.UserName, ts.Cfg.AllowList.Admins)
+}
+
+// logBlacklistOverrides writes down who decided to ignore which blacklist entries for the ticket.
+func (ts *TicketsService) logBlacklistOverrides(ctx context.Context, actor models.Actor, ticketId string, entries []models.BlacklistEntry, action string) error {
+	lgr := logger.New(ctx)
+
+	for _, entry := range entries {
+		lgr.Warn("TicketService:: Blacklist overridden", zap.String("action", action), zap.String("ticket_id", ticketId),
+			zap.Int64("blacklist_id", entry.Id), zap.String("reason", entry.Reason), zap.String("actor", actor.Tag()))
+
+		override := models.BlacklistOverride{
+			BlacklistId: entry.Id,
+			TicketId:    ticketId,
+			Action:      action,
+			Actor:       actor.Tag(),
+		}
+		err := ts.repo.AddBlacklistOverride(ctx, override)
+		if err != nil {
+			lgr.Error("TicketService:: Can't log blacklist override", zap.Error(err))
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blacklistedAtEntry returns the blacklist entries of the ticket no admin has overridden for entry yet.
+func (ts *TicketsService) blacklistedAtEntry(ctx context.Context, ticket *models.TicketResponse) ([]models.BlacklistEntry, error) {
+	if len(ticket.Blacklist) == 0 {
+		return nil, nil
+	}
+
+	overridden, err := ts.repo.SearchBlacklistOverrides(ctx, ticket.Id, models.BlacklistOverrideEntry)
+	if err != nil {
+		logger.New(ctx).Error("TicketService:: Repository SearchBlacklistOverrides returned error", zap.Error(err))
+		return nil, err
+	}
+
+	var blacklisted []models.BlacklistEntry
+	for _, entry := range ticket.Blacklist {
+		if !slices.Contains(overridden, entry.Id) {
+			blacklisted = append(blacklisted, entry)
+		}
+	}
+
+	return blacklisted, nil
+}
+
+// attachBlacklist fills in the blacklist entries each ticket holder matches. The door search
+// must keep working without it, so a failure is only logged.
+func (ts *TicketsService) attachBlacklist(ctx context.Context, tickets []models.TicketResponse) {
+	blacklist, err := ts.repo.SearchBlacklist(ctx)
+	if err != nil {
+		logger.New(ctx).Error("TicketService:: Can't load blacklist, tickets are shown without it", zap.Error(err))
+		return
+	}
+
+	for i := range tickets {
+		tickets[i].Blacklist = utils.BlacklistMatches(tickets[i].Name, blacklist, ts.Cfg.Search.BlacklistMinScore)
+	}
+}
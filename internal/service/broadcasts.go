@@ -0,0 +1,267 @@
+package ticket_service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	broadcastQueueSize  = 64
+	broadcastMaxRetries = 3
+)
+
+type BroadcastRepo interface {
+	UpsertBotUser(ctx context.Context, chatId int64, username string) error
+	SearchChatIdsByUsernames(ctx context.Context, usernames []string) ([]int64, error)
+	SearchBuyersChatIds(ctx context.Context) ([]int64, error)
+	SearchChatIdsByTicketType(ctx context.Context, ticketType string) ([]int64, error)
+	CreateBroadcast(ctx context.Context, broadcast models.Broadcast, chatIds []int64) (int64, error)
+	SearchBroadcastById(ctx context.Context, id int64) (*models.Broadcast, error)
+	SearchPendingDeliveries(ctx context.Context, broadcastId int64) ([]int64, error)
+	UpdateDelivery(ctx context.Context, broadcastId, chatId int64, status, errText string) error
+	FinishBroadcast(ctx context.Context, broadcastId int64) (*models.BroadcastSummary, error)
+	SearchUnfinishedBroadcasts(ctx context.Context) ([]int64, error)
+}
+
+type BroadcastService struct {
+	repo    BroadcastRepo
+	Cfg     configs.Config
+	queue   chan int64
+	sleepFn func(d time.Duration)
+}
+
+func NewBroadcastService(repo BroadcastRepo, cfg configs.Config) *BroadcastService {
+	return &BroadcastService{
+		repo:    repo,
+		Cfg:     cfg,
+		queue:   make(chan int64, broadcastQueueSize),
+		sleepFn: time.Sleep,
+	}
+}
+
+func (bs *BroadcastService) RememberUser(ctx context.Context, chatID int64, userName string) {
+	lgr := logger.New(ctx)
+
+	err := bs.repo.UpsertBotUser(ctx, chatID, userName)
+	if err != nil {
+		lgr.Warn("BroadcastService:: RememberUser:: Can't save user's chat", zap.Error(err))
+	}
+}
+
+func (bs *BroadcastService) PreviewBroadcast(ctx context.Context, draft *models.Broadcast) (string, int, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("BroadcastService:: Started PreviewBroadcast method call")
+
+	if draft == nil || strings.TrimSpace(draft.Text) == "" {
+		msg := "Текст рассылки не может быть пустым"
+		lgr.Error("BroadcastService:: PreviewBroadcast:: Empty broadcast passed")
+		return msg, 0, errors.Wrap(errs.ErrCheckingBaseParameters, "broadcast")
+	}
+
+	chatIds, err := bs.resolveRecipients(ctx, draft.Target)
+	if err != nil {
+		lgr.Error("BroadcastService:: PreviewBroadcast:: Can't resolve recipients", zap.Error(err))
+		msg := "Ошибка при получении списка получателей"
+		return msg, 0, err
+	}
+
+	lgr.Info("BroadcastService:: Finished PreviewBroadcast method call")
+
+	msg := fmt.Sprintf("Предпросмотр рассылки\nПолучатели: %s (%d)\n\n%s", BroadcastTargetLabel(draft.Target), len(chatIds), draft.Text)
+	return msg, len(chatIds), nil
+}
+
+func (bs *BroadcastService) StartBroadcast(ctx context.Context, draft *models.Broadcast) (string, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("BroadcastService:: Started StartBroadcast method call")
+
+	if draft == nil || strings.TrimSpace(draft.Text) == "" {
+		msg := "Текст рассылки не может быть пустым"
+		lgr.Error("BroadcastService:: StartBroadcast:: Empty broadcast passed")
+		return msg, errors.Wrap(errs.ErrCheckingBaseParameters, "broadcast")
+	}
+
+	chatIds, err := bs.resolveRecipients(ctx, draft.Target)
+	if err != nil {
+		lgr.Error("BroadcastService:: StartBroadcast:: Can't resolve recipients", zap.Error(err))
+		msg := "Ошибка при получении списка получателей"
+		return msg, err
+	}
+
+	if len(chatIds) == 0 {
+		lgr.Info("BroadcastService:: StartBroadcast:: No recipients found")
+		msg := "Нет ни одного получателя для рассылки"
+		return msg, nil
+	}
+
+	id, err := bs.repo.CreateBroadcast(ctx, *draft, chatIds)
+	if err != nil {
+		lgr.Error("BroadcastService:: StartBroadcast:: Repository method returned error", zap.Error(err))
+		msg := "Ошибка при сохранении рассылки"
+		return msg, err
+	}
+
+	select {
+	case bs.queue <- id:
+	default:
+		// The broadcast is saved, so Run resumes it as unfinished after the next restart.
+		lgr.Warn("BroadcastService:: StartBroadcast:: Queue is full, broadcast will be sent after restart", zap.Int64("broadcast_id", id))
+		msg := fmt.Sprintf("Очередь рассылок переполнена. Рассылка #%d (%d получателей) сохранена и будет отправлена после перезапуска бота", id, len(chatIds))
+		return msg, nil
+	}
+
+	lgr.Info("BroadcastService:: Finished StartBroadcast method call")
+
+	msg := fmt.Sprintf("Рассылка #%d поставлена в очередь (%d получателей). Итоги придут сюда после отправки", id, len(chatIds))
+	return msg, nil
+}
+
+// Run delivers queued broadcasts one by one, pacing messages so the bot stays
+// within Telegram's global limit. Broadcasts left unfinished by a previous run are resumed first.
+func (bs *BroadcastService) Run(ctx context.Context, bot *tgbotapi.BotAPI) {
+	lgr := logger.New(ctx)
+
+	unfinished, err := bs.repo.SearchUnfinishedBroadcasts(ctx)
+	if err != nil {
+		lgr.Error("BroadcastService:: Run:: Can't load unfinished broadcasts", zap.Error(err))
+	}
+
+	for _, id := range unfinished {
+		bs.deliver(ctx, bot, id)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-bs.queue:
+			bs.deliver(ctx, bot, id)
+		}
+	}
+}
+
+func (bs *BroadcastService) deliver(ctx context.Context, bot *tgbotapi.BotAPI, id int64) {
+	lgr := logger.New(ctx).With(zap.Int64("broadcast_id", id))
+
+	broadcast, err := bs.repo.SearchBroadcastById(ctx, id)
+	if err != nil {
+		lgr.Error("BroadcastService:: deliver:: Can't load broadcast", zap.Error(err))
+		return
+	}
+
+	chatIds, err := bs.repo.SearchPendingDeliveries(ctx, id)
+	if err != nil {
+		lgr.Error("BroadcastService:: deliver:: Can't load pending deliveries", zap.Error(err))
+		return
+	}
+
+	rate := bs.Cfg.Broadcast.RatePerSecond
+	if rate <= 0 {
+		rate = 1
+	}
+	interval := time.Second / time.Duration(rate)
+
+	for _, chatId := range chatIds {
+		status, errText := models.DeliveryStatusSent, ""
+		for attempt := 0; attempt < broadcastMaxRetries; attempt++ {
+			_, err = bot.Send(tgbotapi.NewMessage(chatId, broadcast.Text))
+			if err == nil {
+				break
+			}
+
+			var tgErr *tgbotapi.Error
+			if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+				bs.sleepFn(time.Duration(tgErr.RetryAfter) * time.Second)
+				continue
+			}
+			break
+		}
+		if err != nil {
+			status, errText = models.DeliveryStatusFailed, err.Error()
+		}
+
+		err = bs.repo.UpdateDelivery(ctx, id, chatId, status, errText)
+		if err != nil {
+			lgr.Error("BroadcastService:: deliver:: Can't save delivery result", zap.Error(err))
+		}
+
+		bs.sleepFn(interval)
+	}
+
+	summary, err := bs.repo.FinishBroadcast(ctx, id)
+	if err != nil {
+		lgr.Error("BroadcastService:: deliver:: Can't finish broadcast", zap.Error(err))
+		return
+	}
+	lgr.Info("BroadcastService:: deliver:: Broadcast finished", zap.Int("sent", summary.Sent), zap.Int("failed", summary.Failed))
+
+	report := fmt.Sprintf("Рассылка #%d завершена\nВсего получателей: %d\nДоставлено: %d\nНе доставлено: %d",
+		summary.Id, summary.Total, summary.Sent, summary.Failed)
+	_, _ = bot.Send(tgbotapi.NewMessage(broadcast.AuthorChatId, report))
+}
+
+func (bs *BroadcastService) resolveRecipients(ctx context.Context, target string) ([]int64, error) {
+	allowList := bs.Cfg.AllowList
+
+	switch {
+	case target == models.BroadcastTargetStaff:
+		return bs.repo.SearchChatIdsByUsernames(ctx, mapKeys(allowList.AllowedCheckers, allowList.AllowedSellers, allowList.Admins))
+	case target == models.BroadcastTargetCheckers:
+		return bs.repo.SearchChatIdsByUsernames(ctx, mapKeys(allowList.AllowedCheckers))
+	case target == models.BroadcastTargetSellers:
+		return bs.repo.SearchChatIdsByUsernames(ctx, mapKeys(allowList.AllowedSellers))
+	case target == models.BroadcastTargetBuyers:
+		return bs.repo.SearchBuyersChatIds(ctx)
+	case strings.HasPrefix(target, models.BroadcastTargetTypePrefix):
+		return bs.repo.SearchChatIdsByTicketType(ctx, strings.TrimPrefix(target, models.BroadcastTargetTypePrefix))
+	default:
+		return nil, fmt.Errorf("unknown broadcast target: %s", target)
+	}
+}
+
+func BroadcastTargetLabel(target string) string {
+	switch {
+	case target == models.BroadcastTargetStaff:
+		return "весь персонал"
+	case target == models.BroadcastTargetCheckers:
+		return "контролёры"
+	case target == models.BroadcastTargetSellers:
+		return "продавцы"
+	case target == models.BroadcastTargetBuyers:
+		return "покупатели"
+	case strings.HasPrefix(target, models.BroadcastTargetTypePrefix):
+		return "покупатели с билетом " + strings.ToUpper(strings.TrimPrefix(target, models.BroadcastTargetTypePrefix))
+	default:
+		return target
+	}
+}
+
+func mapKeys(lists ...map[string]bool) []string {
+	uniq := make(map[string]bool)
+	for _, list := range lists {
+		for key := range list {
+			uniq[key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(uniq))
+	for key := range uniq {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,188 @@
+package ticket_service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+// TransferTicket gives the ticket to another person under the same number, with the surname
+// recomputed for the search. The new holder is checked against the blacklist like a buyer.
+func (ts *TicketsService) TransferTicket(ctx context.Context, actor models.Actor, transfer models.TicketTransfer) (*models.TicketTransfer, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started TransferTicket method call")
+
+	transfer.FullName = strings.Title(strings.Join(strings.Fields(transfer.FullName), " "))
+	if transfer.TicketId == "" || transfer.FullName == "" {
+		lgr.Error("TicketService:: TransferTicket:: Empty ticketId or name passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "transfer")
+	}
+	lgr.Debug("TicketsService:: TransferTicket:: transfer checked")
+
+	blacklisted, err := ts.BlacklistMatches(ctx, transfer.FullName)
+	if err != nil {
+		return nil, err
+	}
+	if len(blacklisted) > 0 {
+		if !transfer.BlacklistOverride {
+			lgr.Info("TicketService:: TransferTicket:: New holder matches the blacklist, admin's override required", zap.Int("matches", len(blacklisted)))
+			return nil, errs.ErrBlacklisted
+		}
+		if !ts.canOverrideBlacklist(actor) {
+			lgr.Warn("TicketService:: TransferTicket:: Blacklist override attempted without admin rights", zap.String("actor", actor.Tag()))
+			return nil, errs.ErrOverrideNotAllowed
+		}
+	}
+
+	surname := utils.GetSurnameLowercase(transfer.FullName)
+	previousName, err := ts.repo.TransferTicket(ctx, transfer, surname, actor)
+	if err != nil {
+		lgr.Warn("TicketService:: TransferTicket:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrTicketNotTransferable)
+	}
+	transfer.PreviousName = previousName
+
+	err = ts.logBlacklistOverrides(ctx, actor, transfer.TicketId, blacklisted, models.BlacklistOverrideSale)
+	if err != nil {
+		lgr.Error("TicketService:: TransferTicket:: Override is only in the application log", zap.Error(err))
+	}
+
+	lgr.Info("TicketsService:: Finished TransferTicket method call", zap.String("ticket_id", transfer.TicketId), zap.String("actor", actor.Tag()))
+	return &transfer, nil
+}
+
+// EditTicket corrects the name, the type or the price of a sold ticket, with the same checks as
+// the sale; the price is checked against the ticket's seller, not the admin. A new type recomputes actual_ticket_price as if the ticket were sold now without
+// a repost (the repost isn't stored), and the difference is recorded in ticket_history.
+// The row in the Google Sheet is replaced; a failed update is only reported through SheetSynced.
+func (ts *TicketsService) EditTicket(ctx context.Context, admin models.Actor, edit models.TicketEdit) (*models.TicketEditResult, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started EditTicket method call")
+
+	ticketNo, err := strconv.ParseInt(edit.TicketId, 10, 64)
+	if err != nil {
+		lgr.Error("TicketService:: EditTicket:: Invalid ticketId passed", zap.Error(err))
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticket id")
+	}
+	if edit.FullName == "" && edit.TicketType == "" && edit.Price == 0 {
+		lgr.Error("TicketService:: EditTicket:: Nothing to edit")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "edit")
+	}
+
+	if edit.FullName != "" {
+		fio, err := utils.FormatFIO(edit.FullName)
+		if err != nil {
+			lgr.Error("TicketService:: EditTicket:: Invalid full name passed", zap.Error(err))
+			return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "full name")
+		}
+		edit.FullName = strings.Title(fio)
+	}
+	if edit.TicketType != "" {
+		ticketType, ok := utils.ValidateTicketType(strings.ReplaceAll(edit.TicketType, " ", ""), ts.Cfg.SalesOption)
+		if !ok {
+			lgr.Error("TicketService:: EditTicket:: Invalid ticket type passed", zap.String("ticket_type", edit.TicketType))
+			return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticket type")
+		}
+		edit.TicketType = strings.ToUpper(ticketType)
+	}
+	lgr.Debug("TicketsService:: EditTicket:: edit checked")
+
+	now := ts.nowFn()
+	apply := func(details models.TicketDetails) (models.TicketDetails, error) {
+		if edit.FullName != "" {
+			details.FullName = edit.FullName
+			details.Surname = utils.GetSurnameLowercase(edit.FullName)
+		}
+		if edit.TicketType != "" && edit.TicketType != details.TicketType {
+			details.TicketType = edit.TicketType
+			details.ActualPrice = utils.CalculateActualTicketPrice(now, ts.Cfg.SalesOption, models.ClientData{TicketType: edit.TicketType})
+		}
+		if edit.Price != 0 {
+			// The price is one the ticket's seller could have sold at, whichever admin corrects it.
+			price, err := utils.ParseTicketPrice(strconv.Itoa(edit.Price), strings.TrimPrefix(details.Seller, "@"), ts.Cfg.AllowList)
+			if err != nil {
+				lgr.Error("TicketService:: EditTicket:: Invalid price passed", zap.Int("price", edit.Price), zap.String("seller", details.Seller), zap.Error(err))
+				return details, errors.Wrap(errs.ErrCheckingBaseParameters, "price")
+			}
+			details.Price = price
+		}
+
+		return details, nil
+	}
+
+	before, after, err := ts.repo.EditTicket(ctx, edit.TicketId, apply, admin)
+	if err != nil {
+		lgr.Warn("TicketService:: EditTicket:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrTicketNotFound)
+	}
+
+	result := &models.TicketEditResult{
+		TicketId:        edit.TicketId,
+		Before:          *before,
+		After:           *after,
+		PriceDifference: after.ActualPrice - before.ActualPrice,
+	}
+
+	lgr.Debug("TicketsService:: EditTicket:: Trying to update row in Google Sheet")
+	ts.mu.Lock()
+	err = ts.updateGoogleSheetRow(*after, ticketNo)
+	ts.mu.Unlock()
+	if err != nil {
+		lgr.Error("TicketService:: EditTicket:: Can't update Google Sheet with error: ", zap.Error(err))
+	} else {
+		result.SheetSynced = true
+	}
+
+	lgr.Info("TicketsService:: Finished EditTicket method call", zap.String("ticket_id", edit.TicketId), zap.String("admin", admin.Tag()))
+	return result, nil
+}
+
+// TicketHistory returns the transfers, edits and upgrades of the ticket and the payments taken for it after the sale.
+func (ts *TicketsService) TicketHistory(ctx context.Context, ticketId string) (*models.TicketHistory, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started TicketHistory method call")
+
+	ticketNo, err := strconv.ParseInt(ticketId, 10, 64)
+	if err != nil {
+		lgr.Error("TicketService:: TicketHistory:: Invalid ticketId passed", zap.Error(err))
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticket id")
+	}
+	ticketId = strconv.FormatInt(ticketNo, 10)
+
+	changes, err := ts.repo.SearchTicketHistory(ctx, ticketId)
+	if err != nil {
+		lgr.Error("TicketService:: TicketHistory:: Repository SearchTicketHistory returned error", zap.Error(err))
+		return nil, err
+	}
+
+	payments, err := ts.repo.SearchPayments(ctx, ticketId)
+	if err != nil {
+		lgr.Error("TicketService:: TicketHistory:: Repository SearchPayments returned error", zap.Error(err))
+		return nil, err
+	}
+
+	history := &models.TicketHistory{
+		TicketId: ticketId,
+		Changes:  changes,
+		Payments: payments,
+	}
+	if history.Changes == nil {
+		history.Changes = []models.TicketHistoryRecord{}
+	}
+	if history.Payments == nil {
+		history.Payments = []models.Payment{}
+	}
+
+	lgr.Info("TicketsService:: Finished TicketHistory method call", zap.Int("changes", len(changes)), zap.Int("payments", len(payments)))
+	return history, nil
+}
@@ -0,0 +1,62 @@
+package ticket_service
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	ticket_repository "github.com/qRe0/afterparty-bot/internal/repository"
+)
+
+// TestEditTicketPrice checks the corrected price against the ticket's seller: the admin
+// correcting it is not an SS seller.
+func TestEditTicketPrice(t *testing.T) {
+	ctx := context.Background()
+	repo := ticket_repository.NewMemory()
+	ts := New(repo, configs.Config{
+		AllowList: configs.AllowList{
+			SSSellers: map[string]bool{"ss_seller": true},
+			Admins:    map[string]bool{"admin": true},
+		},
+		Demo: configs.DemoOptions{Enabled: true},
+	})
+
+	tests := []struct {
+		name    string
+		seller  string
+		price   int
+		wantErr error
+	}{
+		{name: "SS price of an SS seller's ticket", seller: "@ss_seller", price: 15},
+		{name: "SS price of another seller's ticket", seller: "@seller", price: 15, wantErr: errs.ErrCheckingBaseParameters},
+		{name: "regular price", seller: "@seller", price: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticketNo, err := repo.SellTicket(ctx, models.ClientData{FIO: "Иванов Иван", TicketType: "БАЗОВЫЙ", Price: 17}, tt.seller, "иванов", 17)
+			if err != nil {
+				t.Fatalf("SellTicket() error = %v", err)
+			}
+
+			edit := models.TicketEdit{TicketId: strconv.FormatInt(ticketNo, 10), Price: tt.price}
+			result, err := ts.EditTicket(ctx, models.Actor{ID: 1, UserName: "admin"}, edit)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("EditTicket() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EditTicket() error = %v", err)
+			}
+			if result.After.Price != tt.price {
+				t.Fatalf("EditTicket() price = %d, want %d", result.After.Price, tt.price)
+			}
+		})
+	}
+}
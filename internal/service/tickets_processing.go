@@ -3,41 +3,78 @@ package ticket_service
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/agnivade/levenshtein"
 	"github.com/pkg/errors"
 	"github.com/qRe0/afterparty-bot/internal/shared/logger"
 	"github.com/qRe0/afterparty-bot/internal/shared/utils"
 	"go.uber.org/zap"
 
 	"github.com/fogleman/gg"
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/lib/pq"
 	"github.com/qRe0/afterparty-bot/internal/configs"
 	errs "github.com/qRe0/afterparty-bot/internal/errors"
 	"github.com/qRe0/afterparty-bot/internal/models"
 )
 
+const (
+	uniqueViolation    = "23505"
+	ticketNoConstraint = "tickets_ticketno_key"
+)
+
 type TicketsRepo interface {
-	SearchBySurname(ctx context.Context, surname string) ([]models.TicketResponse, error)
-	MarkAsEntered(ctx context.Context, id string) (*models.TicketResponse, error)
+	SearchByName(ctx context.Context, terms []string, limit int) ([]models.TicketResponse, error)
+	AddEntry(ctx context.Context, entry models.Entry, check func(models.EntryState) error) (*models.TicketResponse, error)
 	CheckCountOfSurnames(ctx context.Context, surname string) (int64, error)
+	SearchByFullName(ctx context.Context, normalizedName string) ([]models.TicketResponse, error)
 	SearchById(ctx context.Context, id string) (*models.TicketResponse, error)
 	SellTicket(ctx context.Context, client models.ClientData, seller string, clientSurname string, actualPrice int) (int64, error)
 	UpdateSellersTable(ctx context.Context, ticketId, sellerId int64, seller string) error
+	AddReissueRecord(ctx context.Context, ticketId, actorId int64, actor string) error
+	CreateTicketLink(ctx context.Context, ticketId int64, token, buyerTag string, buyerTgId int64) error
+	BindTicketLink(ctx context.Context, token string, chatId, userId int64, userTag string) (*models.TicketResponse, error)
+	SearchByChatId(ctx context.Context, chatId int64) ([]models.TicketResponse, error)
+	SearchAllTickets(ctx context.Context) ([]models.TicketResponse, error)
+	SearchContacts(ctx context.Context) ([]models.BuyerContact, error)
+	RefundTicket(ctx context.Context, id string) (*models.TicketResponse, error)
+	Stats(ctx context.Context) (*models.Stats, error)
+	AddZoneScan(ctx context.Context, scan models.ZoneScan, checker string) error
+	SearchBlacklist(ctx context.Context) ([]models.BlacklistEntry, error)
+	AddToBlacklist(ctx context.Context, entry models.BlacklistEntry) (int64, error)
+	RemoveFromBlacklist(ctx context.Context, id int64, actor string) error
+	AddBlacklistOverride(ctx context.Context, override models.BlacklistOverride) error
+	SearchBlacklistOverrides(ctx context.Context, ticketId, action string) ([]int64, error)
+	AddToWaitlist(ctx context.Context, entry models.WaitlistEntry) (int64, error)
+	SearchWaitlist(ctx context.Context) ([]models.WaitlistEntry, error)
+	OfferNextWaitlisted(ctx context.Context, until time.Time) (*models.WaitlistEntry, error)
+	ExpireWaitlistOffers(ctx context.Context, now time.Time) ([]models.WaitlistEntry, error)
+	UpdateWaitlistStatus(ctx context.Context, id int64, from []string, to string) (*models.WaitlistEntry, error)
+	TransferTicket(ctx context.Context, transfer models.TicketTransfer, surname string, actor models.Actor) (string, error)
+	EditTicket(ctx context.Context, ticketId string, edit func(models.TicketDetails) (models.TicketDetails, error), actor models.Actor) (*models.TicketDetails, *models.TicketDetails, error)
+	SearchTicketDetails(ctx context.Context, ticketId string) (*models.TicketDetails, error)
+	UpgradeTicket(ctx context.Context, ticketId string, upgrade func(models.TicketDetails) (models.TicketDetails, error), seller models.Actor) (*models.TicketDetails, *models.TicketDetails, error)
+	SearchTicketHistory(ctx context.Context, ticketId string) ([]models.TicketHistoryRecord, error)
+	SearchPayments(ctx context.Context, ticketId string) ([]models.Payment, error)
 }
 
 type TicketsService struct {
-	repo                  TicketsRepo
-	Cfg                   configs.Config
-	mu                    sync.Mutex
+	repo TicketsRepo
+	Cfg  configs.Config
+	mu   sync.Mutex
+	// capacityMu is held by sales and by AdvanceWaitlist from counting the free spots to taking
+	// one, so the last spot is neither sold twice nor sold and offered at the same time.
+	capacityMu            sync.Mutex
+	waitlistWake          chan struct{}
 	nowFn                 func() time.Time
 	httpPostFn            func(url, contentType string, body io.Reader) (*http.Response, error)
 	generateTicketImageFn func(ticketNo int64) (*bytes.Buffer, error)
@@ -45,238 +82,579 @@ type TicketsService struct {
 
 func New(repo TicketsRepo, cfg configs.Config) *TicketsService {
 	service := &TicketsService{
-		repo:       repo,
-		Cfg:        cfg,
-		nowFn:      time.Now,
-		httpPostFn: http.Post,
+		repo:         repo,
+		Cfg:          cfg,
+		waitlistWake: make(chan struct{}, 1),
+		nowFn:        time.Now,
+		httpPostFn:   http.Post,
 	}
 	service.generateTicketImageFn = service.generateTicketImage
 	return service
 }
 
-func (ts *TicketsService) SearchBySurname(ctx context.Context, surname *string, chatID *int64, bot *tgbotapi.BotAPI) ([]models.TicketResponse, string, error) {
+func (ts *TicketsService) SearchBySurname(ctx context.Context, surname string) ([]models.TicketResponse, error) {
 	lgr := logger.New(ctx)
 
 	lgr.Info("TicketService:: Started SearchBySurname method call")
 
-	if surname == nil || *surname == "" {
-		msg := "Предоставлена пустая фамилия пользователя"
+	terms := utils.SearchTerms(surname)
+	if len(terms) == 0 {
 		lgr.Error("TicketService:: SearchBySurname:: Empty surname passed")
-		return nil, msg, errors.Wrap(errs.ErrCheckingBaseParameters, "surname")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "surname")
 	}
 	lgr.Debug("TicketsService:: SearchBySurname:: surname checked")
 
-	if chatID == nil {
-		msg := "Предоставлен пустая ID чата"
-		lgr.Error("TicketService:: SearchBySurname:: Empty chatId passed")
-		return nil, msg, errors.Wrap(errs.ErrCheckingBaseParameters, "chatId")
-	}
-	lgr.Debug("TicketsService:: SearchBySurname:: chatId checked")
-
-	if bot == nil {
-		lgr.Panic("TicketsService:: SearchBySurname:: Bot instance is empty (nil)")
+	foundTickets, err := ts.repo.SearchByName(ctx, terms, ts.Cfg.Search.ResultsLimit)
+	if err != nil {
+		lgr.Error("TicketService:: SearchBySurname:: Repository SearchByName returned error", zap.Error(err))
+		return nil, err
 	}
 
-	formattedSurname := strings.ToLower(*surname)
-	formattedSurname = strings.ReplaceAll(formattedSurname, "ё", "е")
-
-	partSurnameToSearch := string([]rune(formattedSurname)[0]) + "%"
-	allTickets, err := ts.repo.SearchBySurname(ctx, partSurnameToSearch)
-	if err != nil {
-		lgr.Error("TicketService:: SearchBySurname:: Repository GetAllTickets returned error", zap.Error(err))
-		msg := "Ошибка при получении данных из базы"
-		return nil, msg, err
+	if len(foundTickets) == 0 {
+		lgr.Info("TicketService:: SearchBySurname:: No clients found with specified name via trigram similarity")
+		return nil, nil
 	}
+	ts.attachBlacklist(ctx, foundTickets)
 
-	var foundTickets []models.TicketResponse
-	const maxDistance = 3
+	lgr.Info("TicketsService:: Finished SearchBySurname method call")
+	return foundTickets, nil
+}
 
-	for _, ticket := range allTickets {
-		distance := levenshtein.ComputeDistance(formattedSurname, ticket.Surname)
+func (ts *TicketsService) SearchById(ctx context.Context, ticketId string) (*models.TicketResponse, error) {
+	lgr := logger.New(ctx)
 
-		if distance <= maxDistance {
-			foundTickets = append(foundTickets, ticket)
-		}
-	}
+	lgr.Info("TicketService:: Started SearchById method call")
 
-	if len(foundTickets) == 0 {
-		lgr.Info("TicketService:: SearchBySurname:: No clients found with specified surname via Levenshtein")
-		msg := "Не удалось найти клиента с указанной фамилией, даже с учетом опечаток."
-		return nil, msg, nil
+	if ticketId == "" {
+		lgr.Error("TicketService:: SearchById:: Empty ticketId passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticketId")
 	}
-	lgr.Info("TicketsService:: SearchBySurname:: Repository method returned result successfully")
+	lgr.Debug("TicketsService:: SearchById:: ticketId checked")
 
-	var result strings.Builder
-	result.WriteString("Найдены следующие покупатели (с учетом возможных опечаток):\n\n")
-	for _, resp := range foundTickets {
-		result.WriteString(utils.ResponseMapper(&resp, ts.Cfg.LacesColor) + "\n\n")
+	resp, err := ts.repo.SearchById(ctx, ticketId)
+	if err != nil {
+		lgr.Warn("TicketService:: SearchById:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrTicketNotFound)
 	}
+	tickets := []models.TicketResponse{*resp}
+	ts.attachBlacklist(ctx, tickets)
 
-	lgr.Info("TicketsService:: Finished SearchBySurname method call")
-	return foundTickets, result.String(), nil
+	lgr.Info("TicketsService:: Finished SearchById method call")
+	return &tickets[0], nil
 }
 
-func (ts *TicketsService) SearchById(ctx context.Context, userId *string, chatID *int64, bot *tgbotapi.BotAPI) (*models.TicketResponse, string, error) {
+// MarkAsEntered lets the guest in through the checker's checkpoint, as long as they are not inside and
+// the re-entry policy allows another entry. A guest at the wrong checkpoint is let in as well:
+// the checker has already been warned by the door card. A guest matching the blacklist is refused
+// with ErrBlacklisted until an admin overrides it with OverrideBlacklist. When the event has a minimum
+// age and the guest is younger or has no birth date, the entry is refused with ErrIDCheckRequired
+// until the checker confirms they have seen an ID. Both errors come with the ticket for the warning.
+func (ts *TicketsService) MarkAsEntered(ctx context.Context, checker models.Actor, ticketId, checkpoint string, idChecked bool) (*models.TicketResponse, error) {
 	lgr := logger.New(ctx)
 
-	lgr.Info("TicketService:: Started SearchById method call")
+	lgr.Info("TicketService:: Started MarkAsEntered method call")
+
+	if ticketId == "" {
+		lgr.Error("TicketService:: MarkAsEntered:: Empty ticketId passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticketId")
+	}
+	lgr.Debug("TicketsService:: MarkAsEntered:: ticketId checked")
 
-	if userId == nil || *userId == "" {
-		msg := "Предоставлен пустой ID пользователя"
-		lgr.Error("TicketService:: SearchById:: Empty userId passed")
-		return nil, msg, errors.Wrap(errs.ErrCheckingBaseParameters, "userId")
+	ticket, err := ts.SearchById(ctx, ticketId)
+	if err != nil {
+		return nil, err
 	}
-	lgr.Debug("TicketsService:: SearchById:: userId checked")
 
-	if chatID == nil {
-		msg := "Предоставлен пустой ID чата"
-		lgr.Error("TicketService:: SearchById:: Empty chatId passed")
-		return nil, msg, errors.Wrap(errs.ErrCheckingBaseParameters, "chatId")
+	blacklisted, err := ts.blacklistedAtEntry(ctx, ticket)
+	if err != nil {
+		return nil, err
+	}
+	if len(blacklisted) > 0 {
+		lgr.Warn("TicketService:: MarkAsEntered:: Guest matches the blacklist, admin's override required",
+			zap.String("ticket_id", ticketId), zap.String("checker", checker.Tag()))
+		return ticket, errs.ErrBlacklisted
 	}
-	lgr.Debug("TicketsService:: SearchById:: chatId checked")
 
-	if bot == nil {
-		lgr.Panic("TicketsService:: SearchById:: Bot instance is empty (nil)")
+	if minAge := ts.Cfg.Event.MinAge; minAge > 0 && !idChecked && utils.NeedsIDCheck(ticket.BirthDate, minAge, ts.nowFn()) {
+		lgr.Info("TicketService:: MarkAsEntered:: ID check required", zap.String("ticket_id", ticketId))
+		return ticket, errs.ErrIDCheckRequired
 	}
 
-	resp, err := ts.repo.SearchById(ctx, *userId)
+	entry := models.Entry{
+		TicketId:  ticketId,
+		Direction: models.EntryDirectionIn,
+		Checker:   checker.Tag(),
+		Door:      checkpoint,
+		IDChecked: idChecked,
+	}
+	resp, err := ts.repo.AddEntry(ctx, entry, ts.checkEntry)
 	if err != nil {
-		lgr.Error("TicketService:: SearchById:: Repository method returned error", zap.Error(err))
-		msg := "Не найдено пользователся с указанным номером билета или ФИО"
-		return nil, msg, err
+		lgr.Warn("TicketService:: MarkAsEntered:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrTicketNotFound)
 	}
-	lgr.Info("TicketsService:: SearchById:: Repository method returned result successfully")
-
-	var resultMsg strings.Builder
-	resultMsg.WriteString("Найдены следующие покупатели:\n\n")
-	resultMsg.WriteString(utils.ResponseMapper(resp, ts.Cfg.LacesColor) + "\n\n")
+	resp.Blacklist = ticket.Blacklist
 
-	lgr.Info("TicketsService:: Finished SearchById method call")
+	if !utils.CheckpointAllowed(resp.TicketType, checkpoint, ts.Cfg.Door) {
+		lgr.Warn("TicketService:: MarkAsEntered:: Guest entered through a checkpoint not meant for the ticket type",
+			zap.String("ticket_id", ticketId), zap.String("ticket_type", resp.TicketType), zap.String("checkpoint", checkpoint))
+	}
 
-	return resp, resultMsg.String(), nil
+	lgr.Info("TicketsService:: Finished MarkAsEntered method call")
+	return resp, nil
 }
 
-func (ts *TicketsService) MarkAsEntered(ctx context.Context, userId *string, chatID *int64, bot *tgbotapi.BotAPI) (string, error) {
+// MarkAsExited lets the guest out, so that they can come back if the re-entry policy allows it.
+func (ts *TicketsService) MarkAsExited(ctx context.Context, checker models.Actor, ticketId, checkpoint string) (*models.TicketResponse, error) {
 	lgr := logger.New(ctx)
 
-	lgr.Info("TicketService:: Started MarkAsEntered method call")
+	lgr.Info("TicketService:: Started MarkAsExited method call")
 
-	if userId == nil || *userId == "" {
-		msg := "Предоставлен пустой ID пользователя"
-		lgr.Error("TicketService:: MarkAsEntered:: Empty userId passed")
-		return msg, errors.Wrap(errs.ErrCheckingBaseParameters, "userId")
+	if ticketId == "" {
+		lgr.Error("TicketService:: MarkAsExited:: Empty ticketId passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticketId")
+	}
+
+	entry := models.Entry{
+		TicketId:  ticketId,
+		Direction: models.EntryDirectionOut,
+		Checker:   checker.Tag(),
+		Door:      checkpoint,
+	}
+	resp, err := ts.repo.AddEntry(ctx, entry, checkExit)
+	if err != nil {
+		lgr.Warn("TicketService:: MarkAsExited:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrTicketNotFound)
 	}
-	lgr.Debug("TicketsService:: MarkAsEntered:: userId checked")
+	exited := []models.TicketResponse{*resp}
+	ts.attachBlacklist(ctx, exited)
+	resp = &exited[0]
 
-	if chatID == nil {
-		msg := "Предоставлен пустой ID чата"
-		lgr.Error("TicketService:: MarkAsEntered:: Empty chatId passed")
-		return msg, errors.Wrap(errs.ErrCheckingBaseParameters, "chatID")
+	lgr.Info("TicketsService:: Finished MarkAsExited method call")
+	return resp, nil
+}
+
+// checkEntry refuses to let in a guest who is already inside or has used up the re-entries
+// allowed by REENTRY_POLICY.
+func (ts *TicketsService) checkEntry(state models.EntryState) error {
+	if state.Inside {
+		return errs.ErrAlreadyInside
+	}
+	if state.Entries == 0 {
+		return nil
 	}
-	lgr.Debug("TicketsService:: MarkAsEntered:: chatId checked")
 
-	if bot == nil {
-		lgr.Panic("TicketsService:: MarkAsEntered:: Bot instance is empty (nil)")
+	switch ts.Cfg.Entry.ReentryPolicy {
+	case configs.ReentryUnlimited:
+		return nil
+	case configs.ReentryLimited:
+		if state.Entries-1 < ts.Cfg.Entry.MaxReentries {
+			return nil
+		}
 	}
 
-	resp, err := ts.repo.MarkAsEntered(ctx, *userId)
-	if err != nil || resp == nil {
-		lgr.Error("TicketService:: MarkAsEntered:: Repository method returned error", zap.Error(err))
-		msg := "Ошибка вызова метода репозитория MarkAsEntered"
-		return msg, err
+	return errs.ErrReentryNotAllowed
+}
+
+func checkExit(state models.EntryState) error {
+	if !state.Inside {
+		return errs.ErrNotInside
 	}
-	lgr.Info("TicketsService:: MarkAsEntered:: Repository method returned result successfully")
 
-	lgr.Info("TicketsService:: Finished MarkAsEntered method call")
+	return nil
+}
+
+// FindNamesakes returns the active tickets sold for the same name, so the seller can make sure
+// the buyer is a different person before selling another one.
+func (ts *TicketsService) FindNamesakes(ctx context.Context, fio string) ([]models.TicketResponse, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started FindNamesakes method call")
+
+	tickets, err := ts.repo.SearchByFullName(ctx, utils.NormalizeName(fio))
+	if err != nil {
+		lgr.Error("TicketService:: FindNamesakes:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
 
-	mappedResp := fmt.Sprintf("%s прошел контроль (ID: %s)", resp.Name, resp.Id)
-	return mappedResp, nil
+	lgr.Info("TicketsService:: Finished FindNamesakes method call", zap.Int("namesakes", len(tickets)))
+	return tickets, nil
 }
 
-func (ts *TicketsService) SellTicket(
-	ctx context.Context,
-	update tgbotapi.Update,
-	bot *tgbotapi.BotAPI,
-	client *models.ClientData,
-) (string, *bytes.Buffer, bool, error) {
+// SellTicket stores the sale and then runs the side effects (sellers table, Google Sheet,
+// ticket image, buyer link). A failed side effect does not undo the sale: it is logged
+// and reported through the flags of the returned result. A buyer matching the blacklist
+// is refused with ErrBlacklisted, unless an admin sells with BlacklistOverride set.
+func (ts *TicketsService) SellTicket(ctx context.Context, seller models.Actor, client models.ClientData) (*models.SaleResult, error) {
 	lgr := logger.New(ctx)
 
 	lgr.Info("TicketService:: Started SellTicket method call")
 
-	if client == nil {
-		msg := "Данные клиента не были предоставлены"
-		lgr.Error("TicketService:: SellTicket:: Empty userId passed")
-		return msg, nil, false, errors.Wrap(errs.ErrCheckingBaseParameters, "client")
+	if client.FIO == "" || client.TicketType == "" {
+		lgr.Error("TicketService:: SellTicket:: Empty client data passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "client")
 	}
 	lgr.Debug("TicketsService:: SellTicket:: client checked")
 
-	if bot == nil {
-		lgr.Panic("TicketsService:: SellTicket:: Bot instance is empty (nil)")
+	blacklisted, err := ts.BlacklistMatches(ctx, client.FIO)
+	if err != nil {
+		return nil, err
+	}
+	if len(blacklisted) > 0 {
+		if !client.BlacklistOverride {
+			lgr.Info("TicketService:: SellTicket:: Buyer matches the blacklist, admin's override required", zap.Int("matches", len(blacklisted)))
+			return nil, errs.ErrBlacklisted
+		}
+		if !ts.canOverrideBlacklist(seller) {
+			lgr.Warn("TicketService:: SellTicket:: Blacklist override attempted without admin rights", zap.String("seller", seller.Tag()))
+			return nil, errs.ErrOverrideNotAllowed
+		}
+	}
+
+	if !client.NamesakeConfirmed {
+		namesakes, err := ts.FindNamesakes(ctx, client.FIO)
+		if err != nil {
+			return nil, err
+		}
+		if len(namesakes) > 0 {
+			lgr.Info("TicketService:: SellTicket:: Namesake found, seller's confirmation required", zap.Int("namesakes", len(namesakes)))
+			return nil, errs.ErrPossibleNamesake
+		}
 	}
 
 	lgr.Debug("TicketsService:: SellTicket:: Starting data preparation to call repository layer")
 	client.FIO = strings.Title(client.FIO)
 	clientSurname := utils.GetSurnameLowercase(client.FIO)
-	actualTicketPrice := utils.CalculateActualTicketPrice(ts.nowFn(), ts.Cfg.SalesOption, *client)
-	sellerTag := "@" + update.Message.From.UserName
-	sellerId := update.Message.From.ID
+	actualTicketPrice := utils.CalculateActualTicketPrice(ts.nowFn(), ts.Cfg.SalesOption, client)
+	sellerTag := seller.Tag()
 	client.TicketType = strings.ToUpper(client.TicketType)
 	lgr.Debug("TicketsService:: SellTicket:: All the data prepared to call repository layer")
 
 	lgr.Debug("TicketsService:: SellTicket:: Calling repository method")
-	ticketNo, err := ts.repo.SellTicket(ctx, *client, sellerTag, clientSurname, actualTicketPrice)
+	ticketNo, err := ts.sellWithinCapacity(ctx, client, sellerTag, clientSurname, actualTicketPrice)
+	if errors.Is(err, errs.ErrSoldOut) {
+		lgr.Info("TicketService:: SellTicket:: Event is sold out", zap.Int("capacity", ts.Cfg.SalesOption.Capacity))
+		return nil, err
+	}
 	if err != nil {
 		var pqErr *pq.Error
-		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
-			lgr.Info("TicketService:: SellTicket:: This client already bought a ticket")
-			msg := "Данный клиент уже купил билет"
-			return msg, nil, false, err
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == ticketNoConstraint {
+			lgr.Error("TicketService:: SellTicket:: Ticket number is already taken, check tickets_ticketno_seq", zap.Error(err))
+			return nil, errors.WithMessagef(errs.ErrTicketNoTaken, "constraint %s", pqErr.Constraint)
 		}
 		lgr.Error("TicketService:: SellTicket:: Repository method returned error", zap.Error(err))
-		msg := "Ошибка вызова метода репозитория SellTicket"
-		return msg, nil, false, err
+		return nil, err
 	}
 	lgr.Info("TicketsService:: SellTicket:: Repository method returned result successfully")
 
+	err = ts.logBlacklistOverrides(ctx, seller, strconv.FormatInt(ticketNo, 10), blacklisted, models.BlacklistOverrideSale)
+	if err != nil {
+		lgr.Error("TicketService:: SellTicket:: Sale override is only in the application log", zap.Error(err))
+	}
+
+	if client.WaitlistId != 0 {
+		ts.markWaitlistSold(ctx, client.WaitlistId)
+	}
+
+	result := &models.SaleResult{TicketNo: ticketNo, FIO: client.FIO}
+
 	lgr.Debug("TicketsService:: SellTicket:: Trying to update seller's table")
-	err = ts.repo.UpdateSellersTable(ctx, ticketNo, sellerId, sellerTag)
+	err = ts.repo.UpdateSellersTable(ctx, ticketNo, seller.ID, sellerTag)
 	if err != nil {
 		lgr.Error("TicketService:: SellTicket:: Can't update sellers table with error: ", zap.Error(err))
+	} else {
+		lgr.Info("TicketsService:: SellTicket:: Sellers table updated successfully")
 	}
-	lgr.Info("TicketsService:: SellTicket:: Sellers table updated successfully")
-
-	ts.mu.Lock()
-	defer ts.mu.Unlock()
 
 	lgr.Debug("TicketsService:: SellTicket:: Trying to add row to Google Sheet")
-	err = ts.addRowToGoogleSheet(*client, sellerTag, ticketNo)
+	ts.mu.Lock()
+	err = ts.addRowToGoogleSheet(client, sellerTag, ticketNo)
+	ts.mu.Unlock()
 	if err != nil {
 		lgr.Error("TicketService:: SellTicket:: Can't update Google Sheet with error: ", zap.Error(err))
-		msgTmpl := "Не удалось записать данные в гугл таблицу. Напишите @yahor_malinouski номер билета (%v), который не был записан в гугл таблицу и сгенерируйте билет вручную через Canva"
-		msg := fmt.Sprintf(msgTmpl, ticketNo)
-		return msg, nil, false, err
+	} else {
+		result.SheetSynced = true
+		lgr.Info("TicketsService:: SellTicket:: Google Sheet updated successfully")
 	}
-	lgr.Info("TicketsService:: SellTicket:: Google Sheet updated successfully")
 
 	lgr.Debug("TicketsService:: SellTicket:: Trying to generate ticket image")
-	ticketGenerated := true
 	imageBuffer, err := ts.generateTicketImageFn(ticketNo)
 	if err != nil {
-		ticketGenerated = false
 		lgr.Error("TicketService:: SellTicket:: Can't generate ticket image with error: ", zap.Error(err))
-		msgTmpl := "Не удалось сгенерировать изображение билета. Cгенерируйте билет вручную через Canva (номер билета: %v)"
-		msg := fmt.Sprintf(msgTmpl, ticketNo)
-		return msg, nil, ticketGenerated, err
+	} else {
+		result.Image = imageBuffer.Bytes()
+		lgr.Info("TicketsService:: SellTicket:: Ticket image generated successfully")
+	}
+
+	if client.BuyerTag != "" || client.BuyerTgId != 0 {
+		lgr.Debug("TicketsService:: SellTicket:: Trying to create buyer's ticket link")
+		token, err := ts.createTicketLink(ctx, ticketNo, client)
+		if err != nil {
+			result.LinkFailed = true
+			lgr.Error("TicketService:: SellTicket:: Can't create buyer's ticket link with error: ", zap.Error(err))
+		} else {
+			result.LinkToken = token
+			lgr.Info("TicketsService:: SellTicket:: Buyer's ticket link created successfully")
+		}
 	}
-	lgr.Info("TicketsService:: SellTicket:: Ticket image generated successfully")
 
 	lgr.Info("TicketsService:: Finished SellTicket method call")
+	return result, nil
+}
+
+// sellWithinCapacity sells the ticket unless the event is sold out. A ticket sold for a waitlist
+// entry takes the spot held for it.
+func (ts *TicketsService) sellWithinCapacity(ctx context.Context, client models.ClientData, sellerTag, clientSurname string, actualPrice int) (int64, error) {
+	ts.capacityMu.Lock()
+	defer ts.capacityMu.Unlock()
+
+	free, err := ts.freeSpots(ctx, client.WaitlistId)
+	if err != nil {
+		return 0, err
+	}
+	if free <= 0 {
+		return 0, errs.ErrSoldOut
+	}
+
+	return ts.repo.SellTicket(ctx, client, sellerTag, clientSurname, actualPrice)
+}
+
+// LinkTicket binds the buyer's chat to the ticket behind the token. If the image can't be
+// generated, the ticket is still linked and the result is returned without an image.
+func (ts *TicketsService) LinkTicket(ctx context.Context, token string, buyer models.Actor, chatID int64) (*models.IssuedTicket, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started LinkTicket method call")
+
+	if token == "" {
+		lgr.Error("TicketService:: LinkTicket:: Empty token passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "token")
+	}
+	lgr.Debug("TicketsService:: LinkTicket:: token checked")
 
-	msg := fmt.Sprintf("Билет успешно продан!\nФИО покупателя: %s\nНомер билета: %d", client.FIO, ticketNo)
-	return msg, imageBuffer, ticketGenerated, nil
+	resp, err := ts.repo.BindTicketLink(ctx, token, chatID, buyer.ID, buyer.Tag())
+	if err != nil {
+		lgr.Warn("TicketService:: LinkTicket:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrInvalidTicketLink)
+	}
+	lgr.Info("TicketsService:: LinkTicket:: Ticket linked successfully")
+
+	issued := &models.IssuedTicket{Ticket: *resp}
+	image, err := ts.TicketImage(ctx, resp.Id)
+	if err != nil {
+		lgr.Error("TicketService:: LinkTicket:: Can't generate ticket image with error: ", zap.Error(err))
+	} else {
+		issued.Image = image
+	}
+
+	lgr.Info("TicketsService:: Finished LinkTicket method call")
+	return issued, nil
+}
+
+func (ts *TicketsService) MyTickets(ctx context.Context, chatID int64) ([]models.TicketResponse, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started MyTickets method call")
+
+	tickets, err := ts.repo.SearchByChatId(ctx, chatID)
+	if err != nil {
+		lgr.Error("TicketService:: MyTickets:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished MyTickets method call", zap.Int("tickets", len(tickets)))
+	return tickets, nil
+}
+
+func (ts *TicketsService) TicketImage(ctx context.Context, ticketId string) ([]byte, error) {
+	ticketNo, err := strconv.ParseInt(ticketId, 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticketId")
+	}
+
+	imageBuffer, err := ts.generateTicketImageFn(ticketNo)
+	if err != nil {
+		logger.New(ctx).Error("TicketService:: TicketImage:: Can't generate ticket image with error: ", zap.Error(err))
+		return nil, errors.WithMessagef(errs.ErrImageGeneration, "ticket %d", ticketNo)
+	}
+
+	return imageBuffer.Bytes(), nil
+}
+
+func (ts *TicketsService) ReissueTicket(ctx context.Context, actor models.Actor, ticketId string) (*models.IssuedTicket, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started ReissueTicket method call")
+
+	if ticketId == "" {
+		lgr.Error("TicketService:: ReissueTicket:: Empty ticketId passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticketId")
+	}
+	lgr.Debug("TicketsService:: ReissueTicket:: ticketId checked")
+
+	resp, err := ts.repo.SearchById(ctx, ticketId)
+	if err != nil {
+		lgr.Error("TicketService:: ReissueTicket:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrTicketNotFound)
+	}
+	lgr.Info("TicketsService:: ReissueTicket:: Ticket found successfully")
+
+	lgr.Debug("TicketsService:: ReissueTicket:: Trying to generate ticket image")
+	image, err := ts.TicketImage(ctx, resp.Id)
+	if err != nil {
+		lgr.Error("TicketService:: ReissueTicket:: Can't generate ticket image with error: ", zap.Error(err))
+		return nil, err
+	}
+	lgr.Info("TicketsService:: ReissueTicket:: Ticket image generated successfully")
+
+	// The image is handed out only once the reissue is recorded, so there is no reissue without a record.
+	ticketNo, _ := strconv.ParseInt(resp.Id, 10, 64)
+	err = ts.repo.AddReissueRecord(ctx, ticketNo, actor.ID, actor.Tag())
+	if err != nil {
+		lgr.Error("TicketService:: ReissueTicket:: Can't add reissue record with error: ", zap.Error(err))
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished ReissueTicket method call")
+	return &models.IssuedTicket{Ticket: *resp, Image: image}, nil
+}
+
+func (ts *TicketsService) RefundTicket(ctx context.Context, ticketId string) (*models.TicketResponse, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started RefundTicket method call")
+
+	if ticketId == "" {
+		lgr.Error("TicketService:: RefundTicket:: Empty ticketId passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticketId")
+	}
+	lgr.Debug("TicketsService:: RefundTicket:: ticketId checked")
+
+	resp, err := ts.repo.RefundTicket(ctx, ticketId)
+	if err != nil {
+		lgr.Error("TicketService:: RefundTicket:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrTicketNotRefundable)
+	}
+	ts.wakeWaitlist()
+
+	lgr.Info("TicketsService:: Finished RefundTicket method call")
+	return resp, nil
+}
+
+func (ts *TicketsService) BuyerContacts(ctx context.Context) ([]models.BuyerContact, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started BuyerContacts method call")
+
+	contacts, err := ts.repo.SearchContacts(ctx)
+	if err != nil {
+		lgr.Error("TicketService:: BuyerContacts:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished BuyerContacts method call", zap.Int("contacts", len(contacts)))
+	return contacts, nil
+}
+
+func (ts *TicketsService) Stats(ctx context.Context) (*models.Stats, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started Stats method call")
+
+	stats, err := ts.repo.Stats(ctx)
+	if err != nil {
+		lgr.Error("TicketService:: Stats:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished Stats method call")
+	return stats, nil
+}
+
+func (ts *TicketsService) GuestList(ctx context.Context) ([]models.TicketResponse, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started GuestList method call")
+
+	tickets, err := ts.repo.SearchAllTickets(ctx)
+	if err != nil {
+		lgr.Error("TicketService:: GuestList:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+	ts.attachBlacklist(ctx, tickets)
+
+	lgr.Info("TicketsService:: Finished GuestList method call", zap.Int("tickets", len(tickets)))
+	return tickets, nil
+}
+
+// SyncOfflineEntry replays an entry registered by an offline door. The entry is checked
+// the same way MarkAsEntered does it; if the guest is already inside or may not come in again,
+// the entry is reported as a conflict and left untouched. The entry is attributed to the door,
+// which has no way to confirm an ID check.
+func (ts *TicketsService) SyncOfflineEntry(ctx context.Context, ticketId, door string) (*models.TicketResponse, bool, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started SyncOfflineEntry method call")
+
+	if ticketId == "" {
+		lgr.Error("TicketService:: SyncOfflineEntry:: Empty ticketId passed")
+		return nil, false, errors.Wrap(errs.ErrCheckingBaseParameters, "ticketId")
+	}
+
+	current, err := ts.repo.SearchById(ctx, ticketId)
+	if err != nil {
+		lgr.Error("TicketService:: SyncOfflineEntry:: Repository SearchById returned error", zap.Error(err))
+		return nil, false, notFoundAs(err, errs.ErrTicketNotFound)
+	}
+
+	entry := models.Entry{
+		TicketId:  ticketId,
+		Direction: models.EntryDirectionIn,
+		Door:      door,
+	}
+	resp, err := ts.repo.AddEntry(ctx, entry, ts.checkEntry)
+	if errors.Is(err, errs.ErrAlreadyInside) || errors.Is(err, errs.ErrReentryNotAllowed) {
+		lgr.Warn("TicketService:: SyncOfflineEntry:: Ticket was already marked as entered", zap.String("ticket_id", ticketId), zap.Error(err))
+		return current, true, nil
+	}
+	if err != nil {
+		lgr.Error("TicketService:: SyncOfflineEntry:: Repository AddEntry returned error", zap.Error(err))
+		return nil, false, err
+	}
+
+	lgr.Info("TicketsService:: Finished SyncOfflineEntry method call")
+	return resp, false, nil
+}
+
+func (ts *TicketsService) createTicketLink(ctx context.Context, ticketNo int64, client models.ClientData) (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	err := ts.repo.CreateTicketLink(ctx, ticketNo, token, client.BuyerTag, client.BuyerTgId)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// notFoundAs replaces sql.ErrNoRows with the given domain error and keeps other errors as they are.
+func notFoundAs(err, domainErr error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return domainErr
+	}
+
+	return err
 }
 
 func (ts *TicketsService) addRowToGoogleSheet(client models.ClientData, sellerTag string, ticketNo int64) error {
+	// Practice sales in demo mode must not reach the real sheet.
+	if ts.Cfg.Demo.Enabled {
+		return nil
+	}
+
 	data := map[string]interface{}{
 		"secret":     ts.Cfg.Sheet.Secret,
 		"TableId":    ts.Cfg.Sheet.TableID,
@@ -287,12 +665,84 @@ func (ts *TicketsService) addRowToGoogleSheet(client models.ClientData, sellerTa
 		"SellerTag":  sellerTag,
 	}
 
+	return ts.postToGoogleSheet(ts.Cfg.Sheet.DeploymentURL, data, nil)
+}
+
+// addSurchargeRowToGoogleSheet appends the surcharge of an upgrade as a payment row credited to
+// the seller of the upgrade. The ticket's sale row stays first, where the update script looks for it.
+func (ts *TicketsService) addSurchargeRowToGoogleSheet(details models.TicketDetails, sellerTag string, surcharge int, ticketNo int64) error {
+	if ts.Cfg.Demo.Enabled {
+		return nil
+	}
+
+	data := map[string]interface{}{
+		"secret":     ts.Cfg.Sheet.Secret,
+		"TableId":    ts.Cfg.Sheet.TableID,
+		"TicketNo":   ticketNo,
+		"FIO":        details.FullName,
+		"TicketType": "Доплата: " + details.TicketType,
+		"Price":      surcharge,
+		"SellerTag":  sellerTag,
+		"Kind":       models.PaymentKindSurcharge,
+	}
+
+	return ts.postToGoogleSheet(ts.Cfg.Sheet.DeploymentURL, data, nil)
+}
+
+// sheetUpdateScriptVersion is the version of scripts/sheet_update.gs the bot expects behind UPDATE_DEPLOYMENT_URL.
+const sheetUpdateScriptVersion = 1
+
+// sheetUpdateReply is what scripts/sheet_update.gs answers once it has looked for the row.
+type sheetUpdateReply struct {
+	Result   string `json:"result"`
+	TicketNo int64  `json:"ticketNo"`
+	Version  int    `json:"version"`
+}
+
+// updateGoogleSheetRow replaces the sale row of the edited ticket through the update script. The
+// sale script behind DEPLOYMENT_URL only appends rows, so the update goes to its own deployment,
+// and it counts as done only when the script confirms it replaced the row of this ticket.
+func (ts *TicketsService) updateGoogleSheetRow(details models.TicketDetails, ticketNo int64) error {
+	if ts.Cfg.Demo.Enabled {
+		return nil
+	}
+	if ts.Cfg.Sheet.UpdateURL == "" {
+		return errors.New("UPDATE_DEPLOYMENT_URL is not set")
+	}
+
+	data := map[string]interface{}{
+		"secret":     ts.Cfg.Sheet.Secret,
+		"TableId":    ts.Cfg.Sheet.TableID,
+		"TicketNo":   ticketNo,
+		"FIO":        details.FullName,
+		"TicketType": details.TicketType,
+		"Price":      details.Price,
+		"SellerTag":  details.Seller,
+	}
+
+	var reply sheetUpdateReply
+	err := ts.postToGoogleSheet(ts.Cfg.Sheet.UpdateURL, data, &reply)
+	if err != nil {
+		return err
+	}
+	if reply.Version != sheetUpdateScriptVersion {
+		return fmt.Errorf("sheet update script is version %d, want %d", reply.Version, sheetUpdateScriptVersion)
+	}
+	if reply.Result != "updated" || reply.TicketNo != ticketNo {
+		return fmt.Errorf("sheet update script did not replace row of ticket %d: %q for ticket %d", ticketNo, reply.Result, reply.TicketNo)
+	}
+
+	return nil
+}
+
+// postToGoogleSheet posts the data to the script deployment and, if reply is not nil, decodes the answer into it.
+func (ts *TicketsService) postToGoogleSheet(url string, data map[string]interface{}, reply interface{}) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	resp, err := ts.httpPostFn(ts.Cfg.Sheet.DeploymentURL, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := ts.httpPostFn(url, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
@@ -301,6 +751,14 @@ func (ts *TicketsService) addRowToGoogleSheet(client models.ClientData, sellerTa
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("received non-OK response: %s", resp.Status)
 	}
+	if reply == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(reply)
+	if err != nil {
+		return fmt.Errorf("can't decode sheet script reply: %v", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,229 @@
+package ticket_service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+// fakeSalesRepo implements what SellTicket needs. Any other repository call panics on the nil interface.
+type fakeSalesRepo struct {
+	TicketsRepo
+	sellErr error
+	sold    []models.ClientData
+}
+
+func (fr *fakeSalesRepo) SearchBlacklist(ctx context.Context) ([]models.BlacklistEntry, error) {
+	return nil, nil
+}
+
+func (fr *fakeSalesRepo) SearchByFullName(ctx context.Context, normalizedName string) ([]models.TicketResponse, error) {
+	return nil, nil
+}
+
+func (fr *fakeSalesRepo) SellTicket(ctx context.Context, client models.ClientData, seller string, clientSurname string, actualPrice int) (int64, error) {
+	if fr.sellErr != nil {
+		return 0, fr.sellErr
+	}
+	fr.sold = append(fr.sold, client)
+	return int64(len(fr.sold)), nil
+}
+
+func (fr *fakeSalesRepo) UpdateSellersTable(ctx context.Context, ticketId, sellerId int64, seller string) error {
+	return nil
+}
+
+func TestSellTicket(t *testing.T) {
+	duplicate := &pq.Error{Code: uniqueViolation, Constraint: ticketNoConstraint}
+	okSheet := func(url, contentType string, body io.Reader) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+	okImage := func(ticketNo int64) (*bytes.Buffer, error) {
+		return bytes.NewBufferString("png"), nil
+	}
+
+	tests := []struct {
+		name       string
+		client     models.ClientData
+		sellErr    error
+		sheet      func(url, contentType string, body io.Reader) (*http.Response, error)
+		image      func(ticketNo int64) (*bytes.Buffer, error)
+		wantErr    error
+		wantSheet  bool
+		wantImage  bool
+		wantSoldAs string
+	}{
+		{
+			name:       "sale with every side effect",
+			client:     models.ClientData{FIO: "иванов иван", TicketType: "базовый", Price: 17},
+			wantSheet:  true,
+			wantImage:  true,
+			wantSoldAs: "Иванов Иван",
+		},
+		{
+			name:   "failed sheet keeps the sale",
+			client: models.ClientData{FIO: "иванов иван", TicketType: "базовый", Price: 17},
+			sheet: func(url, contentType string, body io.Reader) (*http.Response, error) {
+				return nil, fmt.Errorf("network is down")
+			},
+			wantImage:  true,
+			wantSoldAs: "Иванов Иван",
+		},
+		{
+			name:   "failed image keeps the sale",
+			client: models.ClientData{FIO: "иванов иван", TicketType: "базовый", Price: 17},
+			image: func(ticketNo int64) (*bytes.Buffer, error) {
+				return nil, fmt.Errorf("no font")
+			},
+			wantSheet:  true,
+			wantSoldAs: "Иванов Иван",
+		},
+		{
+			name:    "taken ticket number",
+			client:  models.ClientData{FIO: "иванов иван", TicketType: "базовый", Price: 17},
+			sellErr: duplicate,
+			wantErr: errs.ErrTicketNoTaken,
+		},
+		{
+			name:    "empty client",
+			client:  models.ClientData{TicketType: "базовый"},
+			wantErr: errs.ErrCheckingBaseParameters,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeSalesRepo{sellErr: tt.sellErr}
+			ts := New(repo, configs.Config{Sheet: configs.GoogleSheets{DeploymentURL: "https://sheet.example"}})
+			ts.httpPostFn, ts.generateTicketImageFn = okSheet, okImage
+			if tt.sheet != nil {
+				ts.httpPostFn = tt.sheet
+			}
+			if tt.image != nil {
+				ts.generateTicketImageFn = tt.image
+			}
+
+			result, err := ts.SellTicket(context.Background(), models.Actor{ID: 1, UserName: "seller"}, tt.client)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("SellTicket() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SellTicket() error = %v", err)
+			}
+
+			if result.SheetSynced != tt.wantSheet {
+				t.Errorf("SheetSynced = %v, want %v", result.SheetSynced, tt.wantSheet)
+			}
+			if (result.Image != nil) != tt.wantImage {
+				t.Errorf("Image = %v, want an image: %v", result.Image, tt.wantImage)
+			}
+			if len(repo.sold) != 1 || repo.sold[0].FIO != tt.wantSoldAs {
+				t.Errorf("sold %+v, want one ticket for %s", repo.sold, tt.wantSoldAs)
+			}
+		})
+	}
+}
+
+func TestUpdateGoogleSheetRow(t *testing.T) {
+	tests := []struct {
+		name      string
+		updateURL string
+		reply     string
+		wantErr   bool
+	}{
+		{name: "row replaced", updateURL: "https://update.example", reply: `{"result":"updated","ticketNo":42,"version":1}`},
+		{name: "no update endpoint", wantErr: true},
+		{name: "append-only script", updateURL: "https://update.example", reply: `ok`, wantErr: true},
+		{name: "row not found", updateURL: "https://update.example", reply: `{"result":"not_found","ticketNo":42,"version":1}`, wantErr: true},
+		{name: "another ticket", updateURL: "https://update.example", reply: `{"result":"updated","ticketNo":41,"version":1}`, wantErr: true},
+		{name: "old script", updateURL: "https://update.example", reply: `{"result":"updated","ticketNo":42,"version":0}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := New(&fakeSalesRepo{}, configs.Config{Sheet: configs.GoogleSheets{DeploymentURL: "https://sheet.example", UpdateURL: tt.updateURL}})
+			var posted []string
+			ts.httpPostFn = func(url, contentType string, body io.Reader) (*http.Response, error) {
+				posted = append(posted, url)
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(tt.reply))}, nil
+			}
+
+			err := ts.updateGoogleSheetRow(models.TicketDetails{FullName: "Иванов Иван", TicketType: "БАЗОВЫЙ", Price: 17}, 42)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateGoogleSheetRow() error = %v, want an error: %v", err, tt.wantErr)
+			}
+			for _, url := range posted {
+				if url != tt.updateURL {
+					t.Errorf("update posted to %s, want %s", url, tt.updateURL)
+				}
+			}
+		})
+	}
+}
+
+// fakeReissueRepo implements what ReissueTicket needs.
+type fakeReissueRepo struct {
+	TicketsRepo
+	recordErr error
+	records   []int64
+}
+
+func (fr *fakeReissueRepo) SearchById(ctx context.Context, id string) (*models.TicketResponse, error) {
+	return &models.TicketResponse{Id: id, Name: "Иванов Иван", TicketType: "БАЗОВЫЙ"}, nil
+}
+
+func (fr *fakeReissueRepo) AddReissueRecord(ctx context.Context, ticketId, actorId int64, actor string) error {
+	if fr.recordErr != nil {
+		return fr.recordErr
+	}
+	fr.records = append(fr.records, ticketId)
+	return nil
+}
+
+func TestReissueTicket(t *testing.T) {
+	tests := []struct {
+		name      string
+		recordErr error
+		wantErr   bool
+	}{
+		{name: "recorded reissue"},
+		{name: "unrecorded reissue is refused", recordErr: fmt.Errorf("connection refused"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeReissueRepo{recordErr: tt.recordErr}
+			ts := New(repo, configs.Config{})
+			ts.generateTicketImageFn = func(ticketNo int64) (*bytes.Buffer, error) {
+				return bytes.NewBufferString("png"), nil
+			}
+
+			issued, err := ts.ReissueTicket(context.Background(), models.Actor{ID: 1, UserName: "seller"}, "7")
+			if tt.wantErr {
+				if err == nil || issued != nil {
+					t.Fatalf("ReissueTicket() = %+v, %v, want an error and no ticket", issued, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReissueTicket() error = %v", err)
+			}
+			if len(repo.records) != 1 || repo.records[0] != 7 || issued.Image == nil {
+				t.Fatalf("reissued %+v with records %v, want ticket 7 recorded once", issued, repo.records)
+			}
+		})
+	}
+}
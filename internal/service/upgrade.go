@@ -0,0 +1,141 @@
+package ticket_service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+// UpgradeQuote returns what upgrading the ticket would look like and cost now, without changing it.
+func (ts *TicketsService) UpgradeQuote(ctx context.Context, upgrade models.TicketUpgrade) (*models.TicketUpgradeResult, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started UpgradeQuote method call")
+
+	ticketType, err := ts.upgradeTicketType(upgrade)
+	if err != nil {
+		lgr.Error("TicketService:: UpgradeQuote:: Invalid ticket type passed", zap.String("ticket_type", upgrade.TicketType))
+		return nil, err
+	}
+
+	before, err := ts.repo.SearchTicketDetails(ctx, upgrade.TicketId)
+	if err != nil {
+		lgr.Warn("TicketService:: UpgradeQuote:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrTicketNotUpgradable)
+	}
+
+	after, err := ts.upgradeDetails(*before, ticketType, ts.nowFn())
+	if err != nil {
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished UpgradeQuote method call")
+	return upgradeResult(upgrade.TicketId, *before, after), nil
+}
+
+// UpgradeTicket moves a Базовый ticket to the VIP table. The surcharge is the VIP price from
+// CalculateActualTicketPrice less the stored actual_ticket_price, and is recorded as a payment
+// credited to the seller. In the Google Sheet the sale row gets the new type and the surcharge
+// is appended as a row of its own, so the sheet adds up to /stats. The door reads the lace
+// color from the new type. Only VIP sellers and admins may upgrade.
+func (ts *TicketsService) UpgradeTicket(ctx context.Context, seller models.Actor, upgrade models.TicketUpgrade) (*models.TicketUpgradeResult, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started UpgradeTicket method call")
+
+	if !utils.CanUpgradeToVIP(seller.UserName, ts.Cfg.AllowList) {
+		lgr.Warn("TicketService:: UpgradeTicket:: Upgrade attempted without VIP seller or admin rights", zap.String("seller", seller.Tag()))
+		return nil, errs.ErrUpgradeNotAllowed
+	}
+
+	ticketNo, err := strconv.ParseInt(upgrade.TicketId, 10, 64)
+	if err != nil {
+		lgr.Error("TicketService:: UpgradeTicket:: Invalid ticketId passed", zap.Error(err))
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "ticket id")
+	}
+	ticketType, err := ts.upgradeTicketType(upgrade)
+	if err != nil {
+		lgr.Error("TicketService:: UpgradeTicket:: Invalid ticket type passed", zap.String("ticket_type", upgrade.TicketType))
+		return nil, err
+	}
+	lgr.Debug("TicketsService:: UpgradeTicket:: upgrade checked")
+
+	now := ts.nowFn()
+	apply := func(details models.TicketDetails) (models.TicketDetails, error) {
+		return ts.upgradeDetails(details, ticketType, now)
+	}
+
+	before, after, err := ts.repo.UpgradeTicket(ctx, upgrade.TicketId, apply, seller)
+	if err != nil {
+		lgr.Warn("TicketService:: UpgradeTicket:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrTicketNotUpgradable)
+	}
+	result := upgradeResult(upgrade.TicketId, *before, *after)
+
+	lgr.Debug("TicketsService:: UpgradeTicket:: Trying to update row in Google Sheet")
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	err = ts.updateGoogleSheetRow(*after, ticketNo)
+	if err != nil {
+		lgr.Error("TicketService:: UpgradeTicket:: Can't update Google Sheet row with error: ", zap.Error(err))
+	} else {
+		result.SheetSynced = true
+	}
+
+	// The surcharge is already stored as a payment, so its row is appended even if the sale row was not replaced.
+	result.SurchargeSynced = true
+	if result.Surcharge > 0 {
+		lgr.Debug("TicketsService:: UpgradeTicket:: Trying to add surcharge row to Google Sheet")
+		err = ts.addSurchargeRowToGoogleSheet(*after, seller.Tag(), result.Surcharge, ticketNo)
+		if err != nil {
+			lgr.Error("TicketService:: UpgradeTicket:: Can't add surcharge row to Google Sheet with error: ", zap.Error(err))
+			result.SurchargeSynced = false
+		}
+	}
+
+	lgr.Info("TicketsService:: Finished UpgradeTicket method call", zap.String("ticket_id", upgrade.TicketId), zap.Int("surcharge", result.Surcharge), zap.String("seller", seller.Tag()))
+	return result, nil
+}
+
+// upgradeTicketType checks that the ticket is upgraded to an existing VIP table.
+func (ts *TicketsService) upgradeTicketType(upgrade models.TicketUpgrade) (string, error) {
+	ticketType, ok := utils.ValidateTicketType(strings.ReplaceAll(upgrade.TicketType, " ", ""), ts.Cfg.SalesOption)
+	if !ok || !strings.HasPrefix(ticketType, "вип") {
+		return "", errors.Wrap(errs.ErrCheckingBaseParameters, "ticket type")
+	}
+
+	return strings.ToUpper(ticketType), nil
+}
+
+// upgradeDetails gives the Базовый ticket the VIP type and the VIP price as of now.
+func (ts *TicketsService) upgradeDetails(details models.TicketDetails, ticketType string, now time.Time) (models.TicketDetails, error) {
+	if !strings.EqualFold(details.TicketType, "базовый") {
+		return details, errs.ErrTicketNotUpgradable
+	}
+
+	actualPrice := utils.CalculateActualTicketPrice(now, ts.Cfg.SalesOption, models.ClientData{TicketType: ticketType})
+	if actualPrice < 0 {
+		return details, errors.Wrap(errs.ErrCheckingBaseParameters, "prices")
+	}
+
+	details.TicketType = ticketType
+	details.ActualPrice = actualPrice
+	return details, nil
+}
+
+func upgradeResult(ticketId string, before, after models.TicketDetails) *models.TicketUpgradeResult {
+	return &models.TicketUpgradeResult{
+		TicketId:  ticketId,
+		Before:    before,
+		After:     after,
+		Surcharge: max(after.ActualPrice-before.ActualPrice, 0),
+	}
+}
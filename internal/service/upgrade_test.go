@@ -0,0 +1,151 @@
+package ticket_service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	ticket_repository "github.com/qRe0/afterparty-bot/internal/repository"
+)
+
+func TestUpgradeTicketSendsSurchargeRow(t *testing.T) {
+	ctx := context.Background()
+	repo := ticket_repository.NewMemory()
+	ts := New(repo, configs.Config{
+		Sheet:     configs.GoogleSheets{DeploymentURL: "https://sheet.example", UpdateURL: "https://update.example"},
+		AllowList: configs.AllowList{VIPSellers: map[string]bool{"upgrader": true}},
+		SalesOption: configs.SalesOptions{
+			VIPTablesCount: 1,
+			Prices:         []int{15, 12, 20, 17, 50},
+			Dates:          []string{"2099-01-01"},
+		},
+	})
+	ts.nowFn = func() time.Time { return time.Date(2026, 10, 1, 20, 0, 0, 0, time.UTC) }
+	ts.generateTicketImageFn = func(ticketNo int64) (*bytes.Buffer, error) {
+		return bytes.NewBufferString("png"), nil
+	}
+
+	var posted []map[string]interface{}
+	ts.httpPostFn = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		var data map[string]interface{}
+		if err := json.NewDecoder(body).Decode(&data); err != nil {
+			t.Fatalf("sheet request: %v", err)
+		}
+		data["url"] = url
+		posted = append(posted, data)
+
+		reply := ""
+		if url == "https://update.example" {
+			reply = `{"result":"updated","ticketNo":1,"version":1}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(reply))}, nil
+	}
+
+	sold, err := ts.SellTicket(ctx, models.Actor{ID: 1, UserName: "seller"}, models.ClientData{FIO: "иванов иван", TicketType: "базовый", Price: 15})
+	if err != nil {
+		t.Fatalf("SellTicket() error = %v", err)
+	}
+	posted = nil
+
+	result, err := ts.UpgradeTicket(ctx, models.Actor{ID: 2, UserName: "upgrader"}, models.TicketUpgrade{TicketId: "1", TicketType: "вип1"})
+	if err != nil {
+		t.Fatalf("UpgradeTicket() error = %v", err)
+	}
+	if sold.TicketNo != 1 || result.Surcharge != 35 || !result.SheetSynced || !result.SurchargeSynced {
+		t.Fatalf("upgrade of ticket %d = %+v, want surcharge 35 synced to the sheet", sold.TicketNo, result)
+	}
+
+	if len(posted) != 2 {
+		t.Fatalf("posted %d sheet requests, want the row update and the surcharge row: %v", len(posted), posted)
+	}
+	update, surcharge := posted[0], posted[1]
+	if update["url"] != "https://update.example" || update["TicketType"] != "ВИП1" || update["Price"] != 15.0 || update["SellerTag"] != "@seller" {
+		t.Errorf("row update = %v, want the sale row as ВИП1 at 15 by @seller", update)
+	}
+	if surcharge["url"] != "https://sheet.example" || surcharge["Price"] != 35.0 || surcharge["SellerTag"] != "@upgrader" || surcharge["Kind"] != models.PaymentKindSurcharge {
+		t.Errorf("surcharge row = %v, want 35 appended for @upgrader", surcharge)
+	}
+}
+
+func TestUpgradeTicketSurchargeRowSurvivesFailedRowUpdate(t *testing.T) {
+	ctx := context.Background()
+	repo := ticket_repository.NewMemory()
+	ts := New(repo, configs.Config{
+		Sheet:       configs.GoogleSheets{DeploymentURL: "https://sheet.example", UpdateURL: "https://update.example"},
+		AllowList:   configs.AllowList{VIPSellers: map[string]bool{"upgrader": true}},
+		SalesOption: configs.SalesOptions{VIPTablesCount: 1, Prices: []int{15, 12, 20, 17, 50}, Dates: []string{"2099-01-01"}},
+	})
+	ts.nowFn = func() time.Time { return time.Date(2026, 10, 1, 20, 0, 0, 0, time.UTC) }
+
+	var appended []string
+	ts.httpPostFn = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		if url == "https://update.example" {
+			return nil, fmt.Errorf("network is down")
+		}
+		appended = append(appended, url)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	if _, err := repo.SellTicket(ctx, models.ClientData{FIO: "Иванов Иван", TicketType: "БАЗОВЫЙ", Price: 15}, "@seller", "иванов", 15); err != nil {
+		t.Fatalf("SellTicket() error = %v", err)
+	}
+
+	result, err := ts.UpgradeTicket(ctx, models.Actor{ID: 2, UserName: "upgrader"}, models.TicketUpgrade{TicketId: "1", TicketType: "вип1"})
+	if err != nil {
+		t.Fatalf("UpgradeTicket() error = %v", err)
+	}
+	if result.SheetSynced || !result.SurchargeSynced {
+		t.Fatalf("UpgradeTicket() = %+v, want the row update failed and the surcharge row synced", result)
+	}
+	if len(appended) != 1 || appended[0] != "https://sheet.example" {
+		t.Fatalf("appended rows to %v, want the surcharge row", appended)
+	}
+}
+
+func TestUpgradeTicketNeedsVIPSeller(t *testing.T) {
+	ctx := context.Background()
+	repo := ticket_repository.NewMemory()
+	ts := New(repo, configs.Config{
+		AllowList: configs.AllowList{
+			AllowedSellers: map[string]bool{"seller": true},
+			VIPSellers:     map[string]bool{"vip": true},
+			Admins:         map[string]bool{"admin": true},
+		},
+		SalesOption: configs.SalesOptions{VIPTablesCount: 1, Prices: []int{15, 12, 20, 17, 50}, Dates: []string{"2099-01-01"}},
+		Demo:        configs.DemoOptions{Enabled: true},
+	})
+
+	tests := []struct {
+		userName string
+		wantErr  error
+	}{
+		{userName: "seller", wantErr: errs.ErrUpgradeNotAllowed},
+		{userName: "vip"},
+		{userName: "admin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userName, func(t *testing.T) {
+			ticketNo, err := repo.SellTicket(ctx, models.ClientData{FIO: "Иванов Иван", TicketType: "БАЗОВЫЙ", Price: 15}, "@seller", "иванов", 15)
+			if err != nil {
+				t.Fatalf("SellTicket() error = %v", err)
+			}
+
+			_, err = ts.UpgradeTicket(ctx, models.Actor{UserName: tt.userName}, models.TicketUpgrade{TicketId: strconv.FormatInt(ticketNo, 10), TicketType: "вип1"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpgradeTicket() by %s error = %v, want %v", tt.userName, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package ticket_service
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"go.uber.org/zap"
+)
+
+// Waitlist returns the people waiting for a ticket and those holding an offer, in the order they were added.
+func (ts *TicketsService) Waitlist(ctx context.Context) ([]models.WaitlistEntry, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started Waitlist method call")
+
+	entries, err := ts.repo.SearchWaitlist(ctx)
+	if err != nil {
+		lgr.Error("TicketService:: Waitlist:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished Waitlist method call", zap.Int("entries", len(entries)))
+	return entries, nil
+}
+
+// AddToWaitlist puts the person at the end of the waitlist. Offers go to the seller's chat.
+func (ts *TicketsService) AddToWaitlist(ctx context.Context, seller models.Actor, chatID int64, entry models.WaitlistEntry) (*models.WaitlistEntry, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started AddToWaitlist method call")
+
+	entry.FullName = strings.Title(strings.Join(strings.Fields(entry.FullName), " "))
+	entry.Contact = strings.TrimSpace(entry.Contact)
+	if entry.FullName == "" || entry.Contact == "" {
+		lgr.Error("TicketService:: AddToWaitlist:: Empty name or contact passed")
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "waitlist entry")
+	}
+	entry.AddedBy = seller.Tag()
+	entry.AddedByChatId = chatID
+	entry.Status = models.WaitlistStatusWaiting
+
+	id, err := ts.repo.AddToWaitlist(ctx, entry)
+	if err != nil {
+		lgr.Error("TicketService:: AddToWaitlist:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+	entry.Id = id
+
+	// There may already be a free spot, if the event isn't sold out or has no capacity set.
+	ts.wakeWaitlist()
+
+	lgr.Info("TicketsService:: Finished AddToWaitlist method call", zap.Int64("waitlist_id", id), zap.String("seller", entry.AddedBy))
+	return &entry, nil
+}
+
+// RemoveFromWaitlist takes the person off the waitlist. A spot held for them goes to the next person.
+func (ts *TicketsService) RemoveFromWaitlist(ctx context.Context, actor models.Actor, id int64) error {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started RemoveFromWaitlist method call")
+
+	from := []string{models.WaitlistStatusWaiting, models.WaitlistStatusOffered}
+	_, err := ts.repo.UpdateWaitlistStatus(ctx, id, from, models.WaitlistStatusRemoved)
+	if err != nil {
+		lgr.Warn("TicketService:: RemoveFromWaitlist:: Repository method returned error", zap.Error(err))
+		return notFoundAs(err, errs.ErrWaitlistNotFound)
+	}
+	ts.wakeWaitlist()
+
+	lgr.Info("TicketsService:: Finished RemoveFromWaitlist method call", zap.Int64("waitlist_id", id), zap.String("actor", actor.Tag()))
+	return nil
+}
+
+// WaitlistOffer returns the entry if a spot is still held for it.
+func (ts *TicketsService) WaitlistOffer(ctx context.Context, id int64) (*models.WaitlistEntry, error) {
+	entries, err := ts.repo.SearchWaitlist(ctx)
+	if err != nil {
+		logger.New(ctx).Error("TicketService:: WaitlistOffer:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.Id == id && ts.holdsSpot(entry) {
+			return &entry, nil
+		}
+	}
+
+	return nil, errs.ErrWaitlistNotFound
+}
+
+// DeclineWaitlistOffer records that the person doesn't want the spot any more, which then goes to the next person.
+func (ts *TicketsService) DeclineWaitlistOffer(ctx context.Context, seller models.Actor, id int64) (*models.WaitlistEntry, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started DeclineWaitlistOffer method call")
+
+	entry, err := ts.repo.UpdateWaitlistStatus(ctx, id, []string{models.WaitlistStatusOffered}, models.WaitlistStatusDeclined)
+	if err != nil {
+		lgr.Warn("TicketService:: DeclineWaitlistOffer:: Repository method returned error", zap.Error(err))
+		return nil, notFoundAs(err, errs.ErrWaitlistNotFound)
+	}
+	ts.wakeWaitlist()
+
+	lgr.Info("TicketsService:: Finished DeclineWaitlistOffer method call", zap.Int64("waitlist_id", id), zap.String("seller", seller.Tag()))
+	return entry, nil
+}
+
+// SoldOut reports whether no more tickets may be sold apart from the spots held for the waitlist.
+func (ts *TicketsService) SoldOut(ctx context.Context) (bool, error) {
+	free, err := ts.freeSpots(ctx, 0)
+	if err != nil {
+		return false, err
+	}
+
+	return free <= 0, nil
+}
+
+// WaitlistWake signals that a spot may have been freed, so the waitlist should be advanced
+// without waiting for the next periodic check.
+func (ts *TicketsService) WaitlistWake() <-chan struct{} {
+	return ts.waitlistWake
+}
+
+// AdvanceWaitlist ends the offers held for longer than WAITLIST_HOLD and offers every free spot
+// to the next person waiting. It returns the new offers and the expired ones, so the sellers can be told.
+func (ts *TicketsService) AdvanceWaitlist(ctx context.Context) ([]models.WaitlistEntry, []models.WaitlistEntry, error) {
+	lgr := logger.New(ctx)
+
+	ts.capacityMu.Lock()
+	defer ts.capacityMu.Unlock()
+
+	now := ts.nowFn()
+	expired, err := ts.repo.ExpireWaitlistOffers(ctx, now)
+	if err != nil {
+		lgr.Error("TicketService:: AdvanceWaitlist:: Can't expire waitlist offers", zap.Error(err))
+		return nil, nil, err
+	}
+
+	free, err := ts.freeSpots(ctx, 0)
+	if err != nil {
+		return nil, expired, err
+	}
+
+	var offered []models.WaitlistEntry
+	for ; free > 0; free-- {
+		entry, err := ts.repo.OfferNextWaitlisted(ctx, now.Add(ts.Cfg.SalesOption.WaitlistHold))
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			lgr.Error("TicketService:: AdvanceWaitlist:: Can't offer a spot", zap.Error(err))
+			return offered, expired, err
+		}
+
+		lgr.Info("TicketService:: AdvanceWaitlist:: Spot offered", zap.Int64("waitlist_id", entry.Id), zap.String("seller", entry.AddedBy))
+		offered = append(offered, *entry)
+	}
+
+	return offered, expired, nil
+}
+
+// freeSpots is how many tickets may still be sold: the capacity less the tickets sold and the spots
+// held for the waitlist, except the one held for waitlistId. Without a capacity there's no limit.
+func (ts *TicketsService) freeSpots(ctx context.Context, waitlistId int64) (int, error) {
+	lgr := logger.New(ctx)
+
+	capacity := ts.Cfg.SalesOption.Capacity
+	if capacity <= 0 {
+		return math.MaxInt, nil
+	}
+
+	stats, err := ts.repo.Stats(ctx)
+	if err != nil {
+		lgr.Error("TicketService:: Can't count sold tickets", zap.Error(err))
+		return 0, err
+	}
+
+	entries, err := ts.repo.SearchWaitlist(ctx)
+	if err != nil {
+		lgr.Error("TicketService:: Can't load waitlist", zap.Error(err))
+		return 0, err
+	}
+
+	held := 0
+	for _, entry := range entries {
+		if entry.Id != waitlistId && ts.holdsSpot(entry) {
+			held++
+		}
+	}
+
+	return capacity - stats.Sold - held, nil
+}
+
+// holdsSpot reports whether a spot is held for the entry. Offers past their time count as ended
+// even before AdvanceWaitlist marks them expired.
+func (ts *TicketsService) holdsSpot(entry models.WaitlistEntry) bool {
+	return entry.Status == models.WaitlistStatusOffered && entry.OfferedUntil != nil && entry.OfferedUntil.After(ts.nowFn())
+}
+
+// markWaitlistSold closes the waitlist entry the ticket was sold for. The ticket is sold by then,
+// so a failure is only logged.
+func (ts *TicketsService) markWaitlistSold(ctx context.Context, id int64) {
+	from := []string{models.WaitlistStatusWaiting, models.WaitlistStatusOffered, models.WaitlistStatusExpired, models.WaitlistStatusDeclined}
+	_, err := ts.repo.UpdateWaitlistStatus(ctx, id, from, models.WaitlistStatusSold)
+	if err != nil {
+		logger.New(ctx).Error("TicketService:: Can't close waitlist entry after the sale", zap.Int64("waitlist_id", id), zap.Error(err))
+	}
+}
+
+func (ts *TicketsService) wakeWaitlist() {
+	select {
+	case ts.waitlistWake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,81 @@
+package ticket_service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	ticket_repository "github.com/qRe0/afterparty-bot/internal/repository"
+)
+
+// slowCountRepo widens the window between counting the free spots and taking one.
+type slowCountRepo struct {
+	*ticket_repository.MemoryRepo
+}
+
+func (sr slowCountRepo) SearchWaitlist(ctx context.Context) ([]models.WaitlistEntry, error) {
+	entries, err := sr.MemoryRepo.SearchWaitlist(ctx)
+	time.Sleep(time.Millisecond)
+	return entries, err
+}
+
+// TestLastSpotIsTakenOnce races a sale against AdvanceWaitlist for the last spot: either the
+// ticket is sold or the spot is offered, never both.
+func TestLastSpotIsTakenOnce(t *testing.T) {
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		repo := slowCountRepo{ticket_repository.NewMemory()}
+		ts := New(repo, configs.Config{SalesOption: configs.SalesOptions{Capacity: 1, WaitlistHold: time.Hour}})
+		ts.httpPostFn = func(url, contentType string, body io.Reader) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		ts.generateTicketImageFn = func(ticketNo int64) (*bytes.Buffer, error) {
+			return bytes.NewBufferString("png"), nil
+		}
+		_, err := repo.AddToWaitlist(ctx, models.WaitlistEntry{FullName: "Петров Пётр", AddedBy: "@seller"})
+		if err != nil {
+			t.Fatalf("AddToWaitlist() error = %v", err)
+		}
+
+		var (
+			wg      sync.WaitGroup
+			sold    bool
+			offered []models.WaitlistEntry
+			sellErr error
+			waitErr error
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			client := models.ClientData{FIO: fmt.Sprintf("иванов иван%d", i), TicketType: "базовый", Price: 17}
+			_, sellErr = ts.SellTicket(ctx, models.Actor{ID: 1, UserName: "seller"}, client)
+			sold = sellErr == nil
+		}()
+		go func() {
+			defer wg.Done()
+			offered, _, waitErr = ts.AdvanceWaitlist(ctx)
+		}()
+		wg.Wait()
+
+		if sellErr != nil && !errors.Is(sellErr, errs.ErrSoldOut) {
+			t.Fatalf("SellTicket() error = %v", sellErr)
+		}
+		if waitErr != nil {
+			t.Fatalf("AdvanceWaitlist() error = %v", waitErr)
+		}
+		if taken := len(offered) + map[bool]int{true: 1}[sold]; taken != 1 {
+			t.Fatalf("run %d: sold = %v, offered %d spots; want the only spot taken once", i, sold, len(offered))
+		}
+	}
+}
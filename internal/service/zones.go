@@ -0,0 +1,46 @@
+package ticket_service
+
+import (
+	"context"
+	"slices"
+
+	"github.com/pkg/errors"
+	errs "github.com/qRe0/afterparty-bot/internal/errors"
+	"github.com/qRe0/afterparty-bot/internal/models"
+	"github.com/qRe0/afterparty-bot/internal/shared/logger"
+	"github.com/qRe0/afterparty-bot/internal/shared/utils"
+	"go.uber.org/zap"
+)
+
+// CheckZoneAccess tells the zone checker whether the ticket holder may enter the zone
+// and logs the scan, whatever the answer.
+func (ts *TicketsService) CheckZoneAccess(ctx context.Context, checker models.Actor, zone, ticketId string) (*models.ZoneScan, error) {
+	lgr := logger.New(ctx)
+
+	lgr.Info("TicketService:: Started CheckZoneAccess method call")
+
+	if !slices.Contains(ts.Cfg.Zone.Zones, zone) {
+		lgr.Error("TicketService:: CheckZoneAccess:: Unknown zone passed", zap.String("zone", zone))
+		return nil, errors.Wrap(errs.ErrCheckingBaseParameters, "zone")
+	}
+
+	ticket, err := ts.SearchById(ctx, ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := models.ZoneScan{
+		Zone:    zone,
+		Allowed: utils.ZoneAllowed(ticket, zone, ts.Cfg.Zone),
+		Ticket:  ticket,
+	}
+
+	err = ts.repo.AddZoneScan(ctx, scan, checker.Tag())
+	if err != nil {
+		lgr.Error("TicketService:: CheckZoneAccess:: Repository method returned error", zap.Error(err))
+		return nil, err
+	}
+
+	lgr.Info("TicketsService:: Finished CheckZoneAccess method call", zap.String("zone", zone), zap.Bool("allowed", scan.Allowed))
+	return &scan, nil
+}
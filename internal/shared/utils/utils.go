@@ -2,7 +2,9 @@ package utils
 
 import (
 	"fmt"
+	"net/mail"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -16,57 +18,123 @@ const (
 	vipTicketTypeTemplate = "вип%d"
 	formattedFIOTemplate  = "%s %s %s"
 	formattedFITemplate   = "%s %s"
+
+	// BirthDateInputLayout is how sellers type birth dates, BirthDateLayout is how they are stored.
+	BirthDateInputLayout = "02.01.2006"
+	BirthDateLayout      = time.DateOnly
+)
+
+var (
+	telegramUsernameRegexp  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{4,31}$`)
+	phoneDigitsRegexp       = regexp.MustCompile(`^[0-9]{9,15}$`)
+	phoneSeparatorsReplacer = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "")
 )
 
+// latinDigraphs are checked longest first, before single letters.
+var latinDigraphs = []struct {
+	latin    string
+	cyrillic string
+}{
+	{"shch", "щ"}, {"sch", "щ"},
+	{"zh", "ж"}, {"kh", "х"}, {"ts", "ц"}, {"ch", "ч"}, {"sh", "ш"},
+	{"yu", "ю"}, {"ju", "ю"}, {"ya", "я"}, {"ja", "я"}, {"yo", "е"}, {"jo", "е"}, {"ye", "е"},
+	{"iy", "ий"}, {"yy", "ый"},
+}
+
+var latinLetters = map[rune]string{
+	'a': "а", 'b': "б", 'c': "ц", 'd': "д", 'e': "е", 'f': "ф", 'g': "г", 'h': "х", 'i': "и",
+	'j': "й", 'k': "к", 'l': "л", 'm': "м", 'n': "н", 'o': "о", 'p': "п", 'q': "к", 'r': "р",
+	's': "с", 't': "т", 'u': "у", 'v': "в", 'w': "в", 'x': "кс", 'y': "ы", 'z': "з",
+}
+
+var cyrillicLetters = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s",
+	'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ў': "u",
+}
+
 func ShowOptions(chatID int64, bot *tgbotapi.BotAPI, userName string, cfg configs.AllowList) {
 	msg := tgbotapi.NewMessage(chatID, "Выберите опцию:")
 
-	checker := UserInList(userName, cfg.AllowedCheckers)
+	var keyboard tgbotapi.ReplyKeyboardMarkup
+	if rows := optionRows(userName, cfg); len(rows) > 0 {
+		keyboard = tgbotapi.NewReplyKeyboard(rows...)
+	}
+
+	msg.ReplyMarkup = keyboard
+	_, _ = bot.Send(msg)
+}
+
+// optionRows lays out the staff menu one role per row: the door, sales, tickets already sold, admin.
+func optionRows(userName string, cfg configs.AllowList) [][]tgbotapi.KeyboardButton {
 	seller := UserInList(userName, cfg.AllowedSellers)
+	admin := UserInList(userName, cfg.Admins)
 
-	var keyboard tgbotapi.ReplyKeyboardMarkup
+	var rows [][]tgbotapi.KeyboardButton
 	var row []tgbotapi.KeyboardButton
-
-	if checker {
-		row = append(row, tgbotapi.NewKeyboardButton("Отметить вход"))
+	add := func(show bool, label string) {
+		if show {
+			row = append(row, tgbotapi.NewKeyboardButton(label))
+		}
 	}
-
-	if seller {
-		row = append(row, tgbotapi.NewKeyboardButton("Продать билет"))
+	endRow := func() {
+		if len(row) > 0 {
+			rows = append(rows, row)
+			row = nil
+		}
 	}
 
-	if len(row) > 0 {
-		keyboard = tgbotapi.NewReplyKeyboard(row)
-	}
+	add(UserInList(userName, cfg.AllowedCheckers), "Отметить вход")
+	add(UserInList(userName, cfg.ZoneCheckers), "Проверить доступ")
+	endRow()
+
+	add(seller, "Продать билет")
+	add(CanUpgradeToVIP(userName, cfg), "Повысить до ВИП")
+	endRow()
+
+	add(seller || admin, "Перевыпустить билет")
+	add(seller || admin, "Передать билет")
+	add(seller || admin, "Лист ожидания")
+	endRow()
+
+	add(admin, "Изменить билет")
+	endRow()
+
+	return rows
+}
+
+func ShowBuyerOptions(chatID int64, bot *tgbotapi.BotAPI) {
+	msg := tgbotapi.NewMessage(chatID, "Выберите опцию:")
+
+	keyboard := tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("Мой билет"),
+			tgbotapi.NewKeyboardButton("О мероприятии"),
+		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("Помощь"),
+		),
+	)
+	keyboard.ResizeKeyboard = true
 
 	msg.ReplyMarkup = keyboard
 	_, _ = bot.Send(msg)
 }
 
-func ResponseMapper(resp *models.TicketResponse, cfg configs.LacesColors) string {
-	successEmoji := "ДА ✅✅✅"
-	failEmoji := "НЕТ ❌❌❌"
-
-	var laceColor string
-	ticketType := strings.ToLower(resp.TicketType)
+func LaceColor(ticketType string, cfg configs.LacesColors) (string, bool) {
+	ticketType = strings.ToLower(ticketType)
 	switch {
 	case ticketType == "орг":
-		laceColor = cfg.Org
+		return cfg.Org, true
 	case strings.HasPrefix(ticketType, "вип"):
-		laceColor = cfg.VIP
+		return cfg.VIP, true
 	case ticketType == "базовый":
-		laceColor = cfg.Base
+		return cfg.Base, true
 	default:
-		return "Неизвестный тип билета"
-	}
-
-	controlStatus := failEmoji
-	if resp.PassedControlZone {
-		controlStatus = successEmoji
+		return "", false
 	}
-
-	return fmt.Sprintf("Номер билета: %s,\nФИО: %s,\nТип браслета: %s,\nЦвет браслета: %s,\nПрошел контроль? - %s",
-		resp.Id, resp.Name, resp.TicketType, laceColor, controlStatus)
 }
 
 func ValidateTicketType(ticketType string, cfg configs.SalesOptions) (string, bool) {
@@ -219,9 +287,303 @@ func GetSurnameLowercase(surname string) string {
 		return ""
 	}
 
-	formattedSurname := strings.ToLower(parts[0])
-	formattedSurname = strings.Replace(formattedSurname, "ё", "е", -1)
-	return formattedSurname
+	return NormalizeName(parts[0])
+}
+
+// NormalizeName brings a name to the form stored in the surname column:
+// lowercase, trimmed, with "ё" replaced by "е".
+func NormalizeName(name string) string {
+	formattedName := strings.ToLower(strings.Join(strings.Fields(name), " "))
+	return strings.ReplaceAll(formattedName, "ё", "е")
+}
+
+// SearchTerms returns the normalized query together with its Latin↔Cyrillic
+// transliteration, so "ivanov" finds "иванов" and vice versa.
+func SearchTerms(query string) []string {
+	normalized := NormalizeName(query)
+	if normalized == "" {
+		return nil
+	}
+
+	terms := []string{normalized}
+	if transliterated := Transliterate(normalized); transliterated != normalized {
+		terms = append(terms, transliterated)
+	}
+
+	return terms
+}
+
+// TrigramSimilarity mirrors pg_trgm's similarity(): every word is padded with two
+// leading and one trailing space, and the result is the share of common trigrams.
+func TrigramSimilarity(a, b string) float64 {
+	trigramsA, trigramsB := trigrams(a), trigrams(b)
+	if len(trigramsA) == 0 || len(trigramsB) == 0 {
+		return 0
+	}
+
+	common := 0
+	for trigram := range trigramsA {
+		if trigramsB[trigram] {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(trigramsA)+len(trigramsB)-common)
+}
+
+// NameMatchScore is the offline counterpart of the trigram search query: the best similarity
+// of any search term to the surname or to any single word of the full name.
+func NameMatchScore(terms []string, surname, fullName string) float64 {
+	words := strings.Fields(NormalizeName(fullName))
+
+	var best float64
+	for _, term := range terms {
+		best = max(best, TrigramSimilarity(term, surname))
+		for _, word := range words {
+			best = max(best, TrigramSimilarity(term, word))
+		}
+	}
+
+	return best
+}
+
+// BlacklistMatches returns the blacklist entries close to the full name. Names are normalized and
+// transliterated the same way as in the door search, but every word of the shorter name has to
+// match, and at least two of them, so that sharing only a surname with a banned person is not
+// enough to be flagged.
+func BlacklistMatches(fullName string, blacklist []models.BlacklistEntry, minScore float64) []models.BlacklistEntry {
+	terms := SearchTerms(fullName)
+
+	var matches []models.BlacklistEntry
+	for _, entry := range blacklist {
+		name := NormalizeName(entry.FullName)
+		for _, term := range terms {
+			if wordsMatchScore(term, name) >= minScore {
+				matches = append(matches, entry)
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+// wordsMatchScore is the worst similarity among the words of the shorter name, each compared
+// with its closest word in the other name. Word order does not matter. A name of a single word
+// scores 0: one matching word, usually a surname, says nothing about the person.
+func wordsMatchScore(a, b string) float64 {
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	if len(wordsA) > len(wordsB) {
+		wordsA, wordsB = wordsB, wordsA
+	}
+	if len(wordsA) < 2 {
+		return 0
+	}
+
+	worst := 1.0
+	for _, wordA := range wordsA {
+		var best float64
+		for _, wordB := range wordsB {
+			best = max(best, TrigramSimilarity(wordA, wordB))
+		}
+		worst = min(worst, best)
+	}
+
+	return worst
+}
+
+func trigrams(text string) map[string]bool {
+	result := make(map[string]bool)
+	for _, word := range strings.Fields(NormalizeName(text)) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			result[string(padded[i:i+3])] = true
+		}
+	}
+
+	return result
+}
+
+// Transliterate converts lowercase Latin text to Cyrillic and everything else to Latin.
+func Transliterate(text string) string {
+	if isLatin(text) {
+		return latinToCyrillic(text)
+	}
+
+	return cyrillicToLatin(text)
+}
+
+func isLatin(text string) bool {
+	for _, r := range text {
+		if r >= 'a' && r <= 'z' {
+			return true
+		}
+	}
+
+	return false
+}
+
+func latinToCyrillic(text string) string {
+	var result strings.Builder
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); {
+		matched := false
+		for _, digraph := range latinDigraphs {
+			size := len([]rune(digraph.latin))
+			if i+size <= len(runes) && string(runes[i:i+size]) == digraph.latin {
+				result.WriteString(digraph.cyrillic)
+				i += size
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		if cyrillic, ok := latinLetters[runes[i]]; ok {
+			result.WriteString(cyrillic)
+		} else {
+			result.WriteRune(runes[i])
+		}
+		i++
+	}
+
+	return result.String()
+}
+
+func cyrillicToLatin(text string) string {
+	var result strings.Builder
+	for _, r := range text {
+		if latin, ok := cyrillicLetters[r]; ok {
+			result.WriteString(latin)
+		} else {
+			result.WriteRune(r)
+		}
+	}
+
+	return result.String()
+}
+
+func ParseTelegramUsername(input string) (string, error) {
+	username := strings.TrimPrefix(strings.TrimSpace(input), "@")
+	if !telegramUsernameRegexp.MatchString(username) {
+		return "", fmt.Errorf("invalid telegram username: %s", input)
+	}
+
+	return "@" + username, nil
+}
+
+// ParsePhone accepts a phone typed with spaces, dashes or brackets, or taken from a shared
+// contact, and returns it as "+" and digits.
+func ParsePhone(input string) (string, error) {
+	digits := strings.TrimPrefix(phoneSeparatorsReplacer.Replace(strings.TrimSpace(input)), "+")
+	if !phoneDigitsRegexp.MatchString(digits) {
+		return "", fmt.Errorf("invalid phone number: %s", input)
+	}
+
+	return "+" + digits, nil
+}
+
+func ParseEmail(input string) (string, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(input))
+	if err != nil || !strings.Contains(address.Address, ".") {
+		return "", fmt.Errorf("invalid email: %s", input)
+	}
+
+	return strings.ToLower(address.Address), nil
+}
+
+// ParseBirthDate parses a date typed as DD.MM.YYYY and returns it in the storage layout.
+func ParseBirthDate(input string, now time.Time) (string, error) {
+	birthDate, err := time.Parse(BirthDateInputLayout, strings.TrimSpace(input))
+	if err != nil {
+		return "", fmt.Errorf("invalid birth date: %s", input)
+	}
+	if birthDate.After(now) || birthDate.Year() < now.Year()-120 {
+		return "", fmt.Errorf("birth date out of range: %s", input)
+	}
+
+	return birthDate.Format(BirthDateLayout), nil
+}
+
+// NeedsIDCheck tells whether the checker has to see an ID before letting the guest in:
+// the guest is younger than minAge or their birth date is unknown. minAge 0 disables the check.
+func NeedsIDCheck(birthDate string, minAge int, now time.Time) bool {
+	if minAge <= 0 {
+		return false
+	}
+
+	age, ok := Age(birthDate, now)
+	return !ok || age < minAge
+}
+
+// Age returns the full years between a YYYY-MM-DD birth date and now.
+func Age(birthDate string, now time.Time) (int, bool) {
+	born, err := time.Parse(BirthDateLayout, birthDate)
+	if err != nil {
+		return 0, false
+	}
+
+	age := now.Year() - born.Year()
+	if now.Month() < born.Month() || (now.Month() == born.Month() && now.Day() < born.Day()) {
+		age--
+	}
+
+	return age, true
+}
+
+// TicketCheckpoints returns the checkpoints the ticket type is restricted to, or nil if it may use any.
+// A rule applies to every type it is a prefix of, so "вип" covers all VIP tables; the longest prefix wins.
+func TicketCheckpoints(ticketType string, cfg configs.DoorOptions) []string {
+	ticketType = strings.ToLower(ticketType)
+
+	var (
+		matched     string
+		checkpoints []string
+	)
+	for prefix, doors := range cfg.TicketTypeCheckpoints {
+		if strings.HasPrefix(ticketType, prefix) && len(prefix) > len(matched) {
+			matched, checkpoints = prefix, doors
+		}
+	}
+
+	return checkpoints
+}
+
+// CheckpointAllowed tells whether the ticket may come in through the checkpoint.
+// An unknown checkpoint (the checker has not chosen one) never triggers a warning.
+func CheckpointAllowed(ticketType, checkpoint string, cfg configs.DoorOptions) bool {
+	checkpoints := TicketCheckpoints(ticketType, cfg)
+	if checkpoint == "" || checkpoints == nil {
+		return true
+	}
+
+	return slices.Contains(checkpoints, checkpoint)
+}
+
+// ZoneAllowed tells whether the ticket may enter the zone: its number ("#42") or a prefix
+// of its ticket type ("вип") has to be listed in the zone's rule.
+func ZoneAllowed(ticket *models.TicketResponse, zone string, cfg configs.ZoneOptions) bool {
+	ticketType := strings.ToLower(ticket.TicketType)
+	for _, allowed := range cfg.Rules[zone] {
+		if allowed == "#"+ticket.Id || (!strings.HasPrefix(allowed, "#") && strings.HasPrefix(ticketType, allowed)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func IsStaff(userName string, cfg configs.AllowList) bool {
+	return UserInList(userName, cfg.AllowedCheckers) || UserInList(userName, cfg.ZoneCheckers) ||
+		UserInList(userName, cfg.AllowedSellers) || UserInList(userName, cfg.Admins)
+}
+
+// CanUpgradeToVIP tells whether the user may move a guest to a VIP table: VIP sellers and admins may.
+func CanUpgradeToVIP(userName string, cfg configs.AllowList) bool {
+	return UserInList(userName, cfg.VIPSellers) || UserInList(userName, cfg.Admins)
 }
 
 func UserInList(userName string, list map[string]bool) bool {
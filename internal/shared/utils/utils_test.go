@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/qRe0/afterparty-bot/internal/configs"
+	"github.com/qRe0/afterparty-bot/internal/models"
+)
+
+func TestBlacklistMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		fullName  string
+		entry     string
+		wantMatch bool
+	}{
+		{name: "same person", fullName: "Иванов Иван", entry: "Иванов Иван", wantMatch: true},
+		{name: "typo and word order", fullName: "Иван Иваноф", entry: "Иванов Иван", wantMatch: true},
+		{name: "transliterated", fullName: "Ivanov Ivan", entry: "Иванов Иван", wantMatch: true},
+		{name: "patronymic on one side", fullName: "Иванов Иван Петрович", entry: "Иванов Иван", wantMatch: true},
+		{name: "namesake by surname", fullName: "Иванов Пётр", entry: "Иванов Иван"},
+		{name: "single-word entry", fullName: "Иванов Пётр", entry: "Иванов"},
+		{name: "single-word guest", fullName: "Иванов", entry: "Иванов Иван"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := BlacklistMatches(tt.fullName, []models.BlacklistEntry{{Id: 1, FullName: tt.entry}}, 0.5)
+			if got := len(matches) > 0; got != tt.wantMatch {
+				t.Fatalf("BlacklistMatches(%q, %q) matched = %v, want %v", tt.fullName, tt.entry, got, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestOptionRows(t *testing.T) {
+	cfg := configs.AllowList{
+		AllowedCheckers: map[string]bool{"all": true, "checker": true},
+		ZoneCheckers:    map[string]bool{"all": true},
+		AllowedSellers:  map[string]bool{"all": true, "seller": true},
+		VIPSellers:      map[string]bool{"all": true},
+		Admins:          map[string]bool{"all": true},
+	}
+
+	tests := []struct {
+		userName string
+		want     [][]string
+	}{
+		{userName: "checker", want: [][]string{{"Отметить вход"}}},
+		{userName: "seller", want: [][]string{{"Продать билет"}, {"Перевыпустить билет", "Передать билет", "Лист ожидания"}}},
+		{userName: "all", want: [][]string{
+			{"Отметить вход", "Проверить доступ"},
+			{"Продать билет", "Повысить до ВИП"},
+			{"Перевыпустить билет", "Передать билет", "Лист ожидания"},
+			{"Изменить билет"},
+		}},
+		{userName: "stranger"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userName, func(t *testing.T) {
+			var got [][]string
+			for _, row := range optionRows(tt.userName, cfg) {
+				var labels []string
+				for _, button := range row {
+					labels = append(labels, button.Text)
+				}
+				got = append(got, labels)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("optionRows(%s) = %q, want %q", tt.userName, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Replaces the sale row of an edited or upgraded ticket in the sales sheet.
+//
+// Deploy it as its own web app (Execute as: Me, Who has access: Anyone) next to the script
+// behind DEPLOYMENT_URL, which only appends rows, and put its URL into UPDATE_DEPLOYMENT_URL.
+// Set the SECRET_KEY script property to the bot's SECRET_KEY. Adjust COLUMNS if the sheet
+// keeps the sale fields in other columns.
+//
+// The bot checks VERSION and counts the update as done only when the reply is
+// {"result": "updated", "ticketNo": <the ticket>, "version": VERSION}. Bump VERSION together
+// with sheetUpdateScriptVersion in internal/service/tickets_processing.go.
+
+var VERSION = 1;
+
+var COLUMNS = {
+  TicketNo: 1,
+  FIO: 2,
+  TicketType: 3,
+  Price: 4,
+  SellerTag: 5,
+};
+
+function doPost(e) {
+  var data = JSON.parse(e.postData.contents);
+  if (data.secret !== PropertiesService.getScriptProperties().getProperty('SECRET_KEY')) {
+    return reply('forbidden', data.TicketNo);
+  }
+
+  var lock = LockService.getScriptLock();
+  lock.waitLock(30000);
+  try {
+    var sheet = SpreadsheetApp.openById(data.TableId).getSheets()[0];
+    var ticketNos = sheet.getRange(1, COLUMNS.TicketNo, sheet.getLastRow(), 1).getValues();
+
+    // The sale row is the first one with the number: surcharge rows of upgrades come after it.
+    for (var i = 0; i < ticketNos.length; i++) {
+      if (String(ticketNos[i][0]) === String(data.TicketNo)) {
+        var row = i + 1;
+        sheet.getRange(row, COLUMNS.FIO).setValue(data.FIO);
+        sheet.getRange(row, COLUMNS.TicketType).setValue(data.TicketType);
+        sheet.getRange(row, COLUMNS.Price).setValue(data.Price);
+        sheet.getRange(row, COLUMNS.SellerTag).setValue(data.SellerTag);
+        return reply('updated', data.TicketNo);
+      }
+    }
+
+    return reply('not_found', data.TicketNo);
+  } finally {
+    lock.releaseLock();
+  }
+}
+
+function reply(result, ticketNo) {
+  var body = JSON.stringify({ result: result, ticketNo: Number(ticketNo), version: VERSION });
+  return ContentService.createTextOutput(body).setMimeType(ContentService.MimeType.JSON);
+}